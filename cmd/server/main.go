@@ -1,14 +1,26 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/josephburgess/breeze/internal/api"
+	"github.com/josephburgess/breeze/internal/api/handlers"
+	"github.com/josephburgess/breeze/internal/api/middleware"
 	"github.com/josephburgess/breeze/internal/config"
 	"github.com/josephburgess/breeze/internal/logging"
 	"github.com/josephburgess/breeze/internal/services/auth"
+	"github.com/josephburgess/breeze/internal/services/idlekeys"
 	"github.com/josephburgess/breeze/internal/services/store"
+	"github.com/josephburgess/breeze/internal/services/triggers"
 	"github.com/josephburgess/breeze/internal/services/weather"
+	"github.com/josephburgess/breeze/internal/services/webhook"
 )
 
 func main() {
@@ -16,22 +28,107 @@ func main() {
 
 	weatherClient := weather.NewClient(cfg.OpenWeatherAPIKey)
 
-	userStore, err := store.NewUserStore(cfg.DBPath)
+	userStore, err := store.NewUserStore(cfg.DBPath, cfg.APIKeyPepper)
 	if err != nil {
 		logging.Error("Failed to initialize user store", err)
 		return
 	}
 	defer userStore.Close()
+	userStore.QuotaScope = cfg.RateLimitScope
+	if cfg.UsageFlushInterval > 0 {
+		userStore.EnableUsageCache(cfg.UsageFlushInterval)
+	}
 
-	githubOAuth := auth.NewGitHubOAuth(
-		cfg.GithubClientID,
-		cfg.GithubClientSecret,
-		cfg.GithubRedirectURI,
-	)
+	// sign-ins in progress are kept in memory unless they need to survive restarts
+	var oauthStates auth.StateStore = auth.NewMemoryStateStore()
+	if cfg.OAuthStateStore == "sqlite" {
+		oauthStates = userStore
+	}
+
+	// GitHub stays the default provider when it's configured
+	var providers []auth.Provider
+	if cfg.GithubClientID != "" {
+		providers = append(providers, auth.NewGitHubOAuth(cfg.GithubClientID, cfg.GithubClientSecret))
+	}
+	if cfg.OIDCIssuer != "" {
+		oidcProvider, err := auth.NewOIDCProvider(cfg.OIDCName, cfg.OIDCIssuer, cfg.OIDCClientID, cfg.OIDCClientSecret)
+		if err != nil {
+			logging.Error("Failed to set up OpenID Connect provider", err)
+			return
+		}
+		oidcProvider.Scopes = cfg.OIDCScopes
+		providers = append(providers, oidcProvider)
+	}
+
+	oauth := auth.NewOAuth(cfg.GithubRedirectURI, oauthStates, providers...)
+	oauth.StateTTL = cfg.OAuthStateTTL
+
+	forecastCache := weather.NewCache(weatherClient, cfg.ForecastCacheTTL)
+
+	// on SIGINT or SIGTERM, stop the background jobs and finish in-flight requests so
+	// the store can flush usage on its way out
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var jobs sync.WaitGroup
+	runJob := func(run func(context.Context)) {
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+			run(ctx)
+		}()
+	}
+
+	alertPoller := webhook.NewPoller(userStore, forecastCache, webhook.NewSender(), cfg.AlertPollInterval)
+	runJob(alertPoller.Run)
+
+	triggerScheduler := triggers.NewScheduler(userStore, forecastCache, webhook.NewSender(), cfg.TriggerInterval)
+	runJob(triggerScheduler.Run)
 
-	router := api.NewRouter(weatherClient, userStore, githubOAuth)
+	idleKeyCleaner := idlekeys.NewCleaner(userStore, cfg.IdleKeyFlagDays, cfg.IdleKeyDisableDays, cfg.IdleKeyInterval)
+	runJob(idleKeyCleaner.Run)
+
+	router := api.NewRouter(
+		weatherClient,
+		userStore,
+		oauth,
+		cfg.QueryAPIKeys,
+		idleKeyCleaner,
+		middleware.Admins{GithubIDs: cfg.AdminGithubIDs, Identities: cfg.AdminUsers},
+		cfg.TrustedProxies,
+		middleware.IPRateLimitConfig{Burst: cfg.AuthIPBurst, Refill: cfg.AuthIPRefill},
+		middleware.IPRateLimitConfig{Burst: cfg.SearchIPBurst, Refill: cfg.SearchIPRefill},
+		handlers.DeviceFlowConfig{
+			VerificationURI: cfg.PublicURL + "/api/auth/device/verify",
+			CodeTTL:         cfg.DeviceCodeTTL,
+			PollInterval:    cfg.DevicePollInterval,
+		},
+	)
 	router.Use(logging.Middleware)
 
+	server := &http.Server{Addr: ":" + cfg.Port, Handler: router}
+
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		<-ctx.Done()
+		logging.Info("Shutting down server")
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logging.Error("Failed to shut down server", err)
+		}
+	}()
+
 	logging.Info("Starting server on port %s", cfg.Port)
-	logging.Error("Server encountered an error", http.ListenAndServe(":"+cfg.Port, router))
+	if err := server.ListenAndServe(); errors.Is(err, http.ErrServerClosed) {
+		<-shutdown
+	} else {
+		logging.Error("Server encountered an error", err)
+	}
+
+	// the jobs write to the store too, so they finish before it is closed
+	stop()
+	jobs.Wait()
 }
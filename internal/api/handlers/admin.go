@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/josephburgess/breeze/internal/logging"
+	"github.com/josephburgess/breeze/internal/models"
+	"github.com/josephburgess/breeze/internal/services/idlekeys"
+	"github.com/josephburgess/breeze/internal/services/store"
+)
+
+type AdminHandler struct {
+	userStore *store.UserStore
+	idleKeys  *idlekeys.Cleaner
+}
+
+func NewAdminHandler(userStore *store.UserStore, idleKeys *idlekeys.Cleaner) *AdminHandler {
+	return &AdminHandler{
+		userStore: userStore,
+		idleKeys:  idleKeys,
+	}
+}
+
+// IdleKeysReport lists the keys the idle key cleanup would flag or disable if it ran
+// now, without changing anything.
+func (h *AdminHandler) IdleKeysReport(w http.ResponseWriter, r *http.Request) {
+	report, err := h.idleKeys.Sweep(r.Context(), true)
+	if err != nil {
+		http.Error(w, "Failed to build idle key report", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+func (h *AdminHandler) ListPlans(w http.ResponseWriter, r *http.Request) {
+	plans, err := h.userStore.ListPlans()
+	if err != nil {
+		http.Error(w, "Failed to list plans", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(plans)
+}
+
+// UpdatePlan replaces a plan's daily_limit, burst_limit and allowed_endpoints for
+// every key on it.
+func (h *AdminHandler) UpdatePlan(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		DailyLimit       int      `json:"daily_limit"`
+		BurstLimit       int      `json:"burst_limit"`
+		AllowedEndpoints []string `json:"allowed_endpoints"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		logging.Error("Invalid request body", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if request.DailyLimit < 0 || request.BurstLimit < 0 {
+		http.Error(w, "daily_limit and burst_limit can't be negative", http.StatusBadRequest)
+		return
+	}
+
+	plan := &models.Plan{
+		Name:             mux.Vars(r)["name"],
+		DailyLimit:       request.DailyLimit,
+		BurstLimit:       request.BurstLimit,
+		AllowedEndpoints: request.AllowedEndpoints,
+	}
+
+	if err := h.userStore.UpdatePlan(plan); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "Plan not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to update plan", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(plan)
+}
+
+// SetKeyPlan moves any user's key onto another plan.
+func (h *AdminHandler) SetKeyPlan(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Plan string `json:"plan"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		logging.Error("Invalid request body", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.userStore.SetCredentialPlan(mux.Vars(r)["id"], request.Plan); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "API key or plan not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to set plan", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// EndpointUsage totals the requests made to each endpoint by all keys, over the
+// same from and to dates as a user's usage.
+func (h *AdminHandler) EndpointUsage(w http.ResponseWriter, r *http.Request) {
+	from, to, err := parseUsageRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	usage, err := h.userStore.ListEndpointUsage(from, to)
+	if err != nil {
+		http.Error(w, "Failed to get usage", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"from":      from.Format(time.DateOnly),
+		"to":        to.Format(time.DateOnly),
+		"endpoints": usage,
+	})
+}
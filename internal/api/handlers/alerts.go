@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/josephburgess/breeze/internal/logging"
+	"github.com/josephburgess/breeze/internal/models"
+	"github.com/josephburgess/breeze/internal/services/alerts"
+	"github.com/josephburgess/breeze/internal/services/timeformat"
+	"github.com/josephburgess/breeze/internal/services/weather"
+)
+
+type AlertsHandler struct {
+	weatherClient *weather.Client
+}
+
+func NewAlertsHandler(weatherClient *weather.Client) *AlertsHandler {
+	return &AlertsHandler{
+		weatherClient: weatherClient,
+	}
+}
+
+func (h *AlertsHandler) GetAlerts(w http.ResponseWriter, r *http.Request) {
+	cityName := mux.Vars(r)["city"]
+	customApiKey := customAPIKey(r)
+
+	logging.Info("Fetching alerts for city: %s", cityName)
+
+	city, err := h.weatherClient.GetCoordinates(cityName, customApiKey)
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid_api_key") {
+			logging.Error("Invalid API key provided", err)
+			http.Error(w, "Invalid API key", http.StatusUnauthorized)
+			return
+		}
+
+		logging.Error("Error finding city", err)
+		http.Error(w, "Error finding city", http.StatusNotFound)
+		return
+	}
+
+	h.writeAlerts(w, city, city.Lat, city.Lon, customApiKey)
+}
+
+func (h *AlertsHandler) GetAlertsByCoordinates(w http.ResponseWriter, r *http.Request) {
+	lat, err := strconv.ParseFloat(r.URL.Query().Get("lat"), 64)
+	if err != nil || lat < -90 || lat > 90 {
+		http.Error(w, "A valid lat parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	lon, err := strconv.ParseFloat(r.URL.Query().Get("lon"), 64)
+	if err != nil || lon < -180 || lon > 180 {
+		http.Error(w, "A valid lon parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	logging.Info("Fetching alerts for lat: %f, lon: %f", lat, lon)
+
+	h.writeAlerts(w, nil, lat, lon, customAPIKey(r))
+}
+
+func (h *AlertsHandler) writeAlerts(w http.ResponseWriter, city *models.City, lat, lon float64, customApiKey string) {
+	weather, err := h.weatherClient.GetWeather(lat, lon, "", customApiKey)
+	if err != nil {
+		logging.Error("Error getting weather", err)
+		http.Error(w, "Error getting weather", http.StatusInternalServerError)
+		return
+	}
+
+	loc := timeformat.LoadLocation(weather.Timezone, weather.TimezoneOffset)
+	active := alerts.Normalize(weather.Alerts, loc, time.Now())
+
+	logging.Info("Found %d active alerts for lat: %f, lon: %f", len(active), lat, lon)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.AlertsResponse{
+		City:     city,
+		Lat:      weather.Lat,
+		Lon:      weather.Lon,
+		Timezone: weather.Timezone,
+		Alerts:   active,
+	})
+}
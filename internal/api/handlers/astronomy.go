@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/josephburgess/breeze/internal/logging"
+	"github.com/josephburgess/breeze/internal/services/astronomy"
+)
+
+type AstronomyHandler struct{}
+
+func NewAstronomyHandler() *AstronomyHandler {
+	return &AstronomyHandler{}
+}
+
+func (h *AstronomyHandler) GetAstronomy(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	lat, err := strconv.ParseFloat(query.Get("lat"), 64)
+	if err != nil || lat < -90 || lat > 90 {
+		http.Error(w, "A valid lat parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	lon, err := strconv.ParseFloat(query.Get("lon"), 64)
+	if err != nil || lon < -180 || lon > 180 {
+		http.Error(w, "A valid lon parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	// default to today at the location, estimated from its longitude
+	date := time.Now().UTC().Add(time.Duration(lon / 15 * float64(time.Hour)))
+	if dateParam := query.Get("date"); dateParam != "" {
+		date, err = time.Parse("2006-01-02", dateParam)
+		if err != nil {
+			http.Error(w, "Invalid date, expected YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+	}
+
+	logging.Info("Calculating astronomy for lat: %f, lon: %f, date: %s", lat, lon, date.Format("2006-01-02"))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(astronomy.Calculate(lat, lon, date))
+}
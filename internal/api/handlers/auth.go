@@ -2,8 +2,11 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 
 	"github.com/josephburgess/breeze/internal/logging"
@@ -14,26 +17,71 @@ import (
 )
 
 type AuthHandler struct {
-	githubOAuth *auth.GitHubOAuth
-	userStore   *store.UserStore
+	oauth     *auth.OAuth
+	userStore *store.UserStore
+	device    DeviceFlowConfig
 }
 
-func NewAuthHandler(githubOAuth *auth.GitHubOAuth, userStore *store.UserStore) *AuthHandler {
+func NewAuthHandler(oauth *auth.OAuth, userStore *store.UserStore, device DeviceFlowConfig) *AuthHandler {
 	return &AuthHandler{
-		githubOAuth: githubOAuth,
-		userStore:   userStore,
+		oauth:     oauth,
+		userStore: userStore,
+		device:    device,
 	}
 }
 
+// Providers lists the identity providers users can sign in with, the default first.
+func (h *AuthHandler) Providers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{
+		"providers": h.oauth.Providers(),
+	})
+}
+
+// the loopback ports clients may ask identity providers to send users back to,
+// which excludes privileged ports
+const (
+	minCallbackPort = 1024
+	maxCallbackPort = 65535
+)
+
+const defaultCallbackPort = 9876
+
+// RequestAuth starts a sign-in with the identity provider named by provider, or the
+// default, that ends at the client's local callback on callback_port. Clients
+// exchange the code themselves, so they must send a PKCE code_challenge (S256) and
+// later prove they hold its verifier.
 func (h *AuthHandler) RequestAuth(w http.ResponseWriter, r *http.Request) {
-	callbackPort := r.URL.Query().Get("callback_port")
-	if callbackPort == "" {
-		callbackPort = "9876"
+	codeChallenge := r.URL.Query().Get("code_challenge")
+	if method := r.URL.Query().Get("code_challenge_method"); method != "" && method != "S256" {
+		http.Error(w, "code_challenge_method must be S256", http.StatusBadRequest)
+		return
+	}
+	if !auth.ValidCodeChallenge(codeChallenge) {
+		http.Error(w, "code_challenge is required, as the base64url encoded SHA-256 hash of a code verifier", http.StatusBadRequest)
+		return
+	}
+
+	callbackPort := defaultCallbackPort
+	if value := r.URL.Query().Get("callback_port"); value != "" {
+		port, err := strconv.Atoi(value)
+		if err != nil || port < minCallbackPort || port > maxCallbackPort {
+			http.Error(w, fmt.Sprintf("callback_port must be a number from %d to %d", minCallbackPort, maxCallbackPort), http.StatusBadRequest)
+			return
+		}
+		callbackPort = port
 	}
 
-	callbackURL := fmt.Sprintf("http://localhost:%s/callback", callbackPort)
-	h.githubOAuth.RedirectURI = callbackURL
-	authURL, state := h.githubOAuth.GetAuthURL()
+	callbackURL := fmt.Sprintf("http://localhost:%d/callback", callbackPort)
+	authURL, state, err := h.oauth.GetAuthURL(r.URL.Query().Get("provider"), callbackURL, codeChallenge)
+	if errors.Is(err, auth.ErrUnknownProvider) {
+		http.Error(w, "provider must be one of: "+strings.Join(h.oauth.Providers(), ", "), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to start authentication", http.StatusInternalServerError)
+		return
+	}
 
 	logging.Info("Generated authentication URL: %s", authURL)
 
@@ -44,25 +92,39 @@ func (h *AuthHandler) RequestAuth(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// Callback finishes a sign-in an identity provider sent back to breeze. Sign-ins
+// started for a local client are passed on to its callback, which exchanges the
+// code itself, and those started from the device verification page go on to
+// approve the device.
 func (h *AuthHandler) Callback(w http.ResponseWriter, r *http.Request) {
 	code := r.URL.Query().Get("code")
 	state := r.URL.Query().Get("state")
 
-	if strings.HasPrefix(h.githubOAuth.RedirectURI, "http://localhost:") {
-		redirectURL := fmt.Sprintf("%s?code=%s&state=%s", h.githubOAuth.RedirectURI, code, state)
-		logging.Info("Redirecting to local callback: %s", redirectURL)
+	saved, err := h.oauth.GetState(state)
+	if err != nil {
+		authFailed(w, err)
+		return
+	}
+
+	if saved.DeviceUserCode != "" {
+		h.handleDeviceCallback(w, r, code, state, saved)
+		return
+	}
+
+	if strings.HasPrefix(saved.RedirectURI, "http://localhost:") {
+		redirectURL := saved.RedirectURI + "?" + url.Values{"code": {code}, "state": {state}}.Encode()
+		logging.Info("Redirecting to local callback: %s", saved.RedirectURI)
 		http.Redirect(w, r, redirectURL, http.StatusFound)
 		return
 	}
 
-	h.handleGitHubCallback(w, code, state)
+	h.handleWebCallback(w, code, state)
 }
 
-func (h *AuthHandler) handleGitHubCallback(w http.ResponseWriter, code, state string) {
-	user, apiKey, err := h.handleGitHubAuthCode(code, state)
+func (h *AuthHandler) handleWebCallback(w http.ResponseWriter, code, state string) {
+	user, apiKey, err := h.handleAuthCode(code, state, "", store.DefaultKeyName)
 	if err != nil {
-		logging.Error("Authentication failed", err)
-		http.Error(w, "Authentication failed", http.StatusInternalServerError)
+		authFailed(w, err)
 		return
 	}
 
@@ -75,38 +137,47 @@ func (h *AuthHandler) handleGitHubCallback(w http.ResponseWriter, code, state st
 	}
 }
 
-func (h *AuthHandler) handleGitHubAuthCode(code, state string) (*models.User, string, error) {
-	token, err := h.githubOAuth.ExchangeCodeForToken(code, state)
+// handleAuthCode signs the user in and issues a new key for the credential
+// called keyName. codeVerifier must match the sign-in's code challenge, if it has
+// one.
+func (h *AuthHandler) handleAuthCode(code, state, codeVerifier, keyName string) (*models.User, string, error) {
+	user, err := h.signIn(code, state, codeVerifier)
 	if err != nil {
-		logging.Error("Failed to exchange code for token", err)
-		return nil, "", fmt.Errorf("failed to exchange code for token: %w", err)
+		return nil, "", err
 	}
 
-	user, err := h.githubOAuth.GetUserInfo(token)
+	credential, err := h.userStore.IssueAPIKey(user.GithubID, keyName)
 	if err != nil {
-		logging.Error("Failed to get user info", err)
-		return nil, "", fmt.Errorf("failed to get user info: %w", err)
+		logging.Error("Failed to issue API key", err)
+		return nil, "", fmt.Errorf("failed to issue API key: %w", err)
 	}
 
-	if err := h.userStore.SaveUser(user); err != nil {
-		logging.Error("Failed to save user", err)
-		return nil, "", fmt.Errorf("failed to save user: %w", err)
-	}
+	logging.Info("Successfully issued API key %s... for user: %s", credential.KeyPrefix, user.Login)
+	return user, credential.ApiKey, nil
+}
 
-	credential, err := h.userStore.GetOrCreateAPICredential(user.GithubID)
+// signIn exchanges the code the identity provider returned for a sign-in and saves
+// the user it belongs to.
+func (h *AuthHandler) signIn(code, state, codeVerifier string) (*models.User, error) {
+	user, err := h.oauth.SignIn(code, state, codeVerifier)
 	if err != nil {
-		logging.Error("Failed to create API credential", err)
-		return nil, "", fmt.Errorf("failed to create API credential: %w", err)
+		logging.Error("Failed to exchange code for user", err)
+		return nil, fmt.Errorf("failed to exchange code for user: %w", err)
 	}
 
-	logging.Info("Successfully created API credential for user: %s", user.Login)
-	return user, credential.ApiKey, nil
+	if err := h.userStore.SaveUser(user); err != nil {
+		logging.Error("Failed to save user", err)
+		return nil, fmt.Errorf("failed to save user: %w", err)
+	}
+	return user, nil
 }
 
 func (h *AuthHandler) ExchangeToken(w http.ResponseWriter, r *http.Request) {
 	var request struct {
 		Code         string `json:"code"`
-		CallbackPort int    `json:"callback_port"`
+		State        string `json:"state"`
+		CodeVerifier string `json:"code_verifier"`
+		KeyName      string `json:"key_name"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
@@ -115,12 +186,21 @@ func (h *AuthHandler) ExchangeToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.githubOAuth.RedirectURI = fmt.Sprintf("http://localhost:%d/callback", request.CallbackPort)
+	if request.Code == "" || request.State == "" || request.CodeVerifier == "" {
+		http.Error(w, "code, state and code_verifier are required", http.StatusBadRequest)
+		return
+	}
 
-	user, apiKey, err := h.handleGitHubAuthCode(request.Code, "")
+	// clients on several machines can pass their own key_name so signing in on one
+	// doesn't replace the key used by another
+	keyName := strings.TrimSpace(request.KeyName)
+	if keyName == "" {
+		keyName = store.DefaultKeyName
+	}
+
+	user, apiKey, err := h.handleAuthCode(request.Code, request.State, request.CodeVerifier, keyName)
 	if err != nil {
-		logging.Error("Authentication failed", err)
-		http.Error(w, "Authentication failed", http.StatusInternalServerError)
+		authFailed(w, err)
 		return
 	}
 
@@ -130,5 +210,29 @@ func (h *AuthHandler) ExchangeToken(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{
 		"api_key":     apiKey,
 		"github_user": user.Login,
+		"provider":    user.Provider,
 	})
 }
+
+// authFailed reports a failed sign-in, telling clients when they need to start over
+// because the sign-in expired or was already used.
+func authFailed(w http.ResponseWriter, err error) {
+	logging.Error("Authentication failed", err)
+	if errors.Is(err, auth.ErrInvalidState) {
+		http.Error(w, "Sign-in expired or already used, please start again", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, auth.ErrInvalidCodeVerifier) {
+		http.Error(w, "code_verifier doesn't match the code_challenge, please start again", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, auth.ErrUnknownProvider) {
+		http.Error(w, "Sign-in provider is no longer available, please start again", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, auth.ErrInvalidIDToken) {
+		http.Error(w, "Sign-in couldn't be verified", http.StatusUnauthorized)
+		return
+	}
+	http.Error(w, "Authentication failed", http.StatusInternalServerError)
+}
@@ -0,0 +1,322 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/josephburgess/breeze/internal/logging"
+	"github.com/josephburgess/breeze/internal/models"
+	"github.com/josephburgess/breeze/internal/services/auth"
+	"github.com/josephburgess/breeze/internal/services/store"
+	"github.com/josephburgess/breeze/internal/templates"
+)
+
+// DeviceFlowConfig configures sign-in for devices that can't open a browser.
+type DeviceFlowConfig struct {
+	// the page users open to enter a device's user code
+	VerificationURI string
+	// how long a device has to be approved
+	CodeTTL time.Duration
+	// how long devices wait between polls, to begin with
+	PollInterval time.Duration
+}
+
+// DeviceAuthorization starts a device sign-in (RFC 8628). The device shows the user
+// code and verification URI to the user, then polls DeviceToken with the device code.
+func (h *AuthHandler) DeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		KeyName string `json:"key_name"`
+	}
+
+	// the body is optional
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+		logging.Error("Invalid request body", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	keyName := strings.TrimSpace(request.KeyName)
+	if keyName == "" {
+		keyName = store.DefaultKeyName
+	}
+
+	deviceCode, authorization, err := h.userStore.CreateDeviceAuthorization(keyName, h.device.CodeTTL, h.device.PollInterval)
+	if err != nil {
+		http.Error(w, "Failed to start device authorization", http.StatusInternalServerError)
+		return
+	}
+
+	logging.Info("Started device authorization %s", authorization.UserCode)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]any{
+		"device_code":               deviceCode,
+		"user_code":                 authorization.UserCode,
+		"verification_uri":          h.device.VerificationURI,
+		"verification_uri_complete": h.device.VerificationURI + "?" + url.Values{"user_code": {authorization.UserCode}}.Encode(),
+		"expires_in":                int(h.device.CodeTTL / time.Second),
+		"interval":                  authorization.Interval,
+	})
+}
+
+// the browser session a device sign-in runs in, so the browser that enters the code
+// is the one that has to sign in and approve the device
+const deviceSessionCookie = "breeze_device_session"
+
+// where the confirmation page posts approvals to
+const deviceApprovePath = "/api/auth/device/approve"
+
+// DeviceVerify shows the page where users enter a device's user code and, when the
+// form is submitted, sends them to the identity provider they picked to sign in.
+func (h *AuthHandler) DeviceVerify(w http.ResponseWriter, r *http.Request) {
+	userCode := store.NormalizeUserCode(r.FormValue("user_code"))
+
+	if r.Method != http.MethodPost {
+		h.renderDeviceVerify(w, r, http.StatusOK, userCode, "")
+		return
+	}
+
+	session, ok := deviceSession(r)
+	if !ok || !validCSRFToken(session, r.FormValue("csrf_token")) {
+		logging.Warn("Device verification submitted without a valid CSRF token")
+		h.renderDeviceVerify(w, r, http.StatusForbidden, userCode, "This page has expired, please try again.")
+		return
+	}
+
+	authorization, err := h.userStore.GetPendingDeviceAuthorization(userCode)
+	if err != nil {
+		http.Error(w, "Failed to look up code", http.StatusInternalServerError)
+		return
+	}
+	if authorization == nil {
+		h.renderDeviceVerify(w, r, http.StatusBadRequest, userCode, "That code isn't valid or has expired. Check your terminal for the current code.")
+		return
+	}
+
+	authURL, _, err := h.oauth.GetDeviceAuthURL(r.FormValue("provider"), authorization.UserCode, hashSession(session))
+	if errors.Is(err, auth.ErrUnknownProvider) {
+		h.renderDeviceVerify(w, r, http.StatusBadRequest, userCode, "Pick one of the sign-in options below.")
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to start authentication", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, authURL, http.StatusSeeOther)
+}
+
+func (h *AuthHandler) renderDeviceVerify(w http.ResponseWriter, r *http.Request, status int, userCode, errorMessage string) {
+	session := h.startDeviceSession(w, r)
+
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(status)
+	if err := templates.RenderDeviceVerifyTemplate(w, userCode, errorMessage, h.oauth.Providers(), csrfToken(session)); err != nil {
+		logging.Error("Failed to render template", err)
+	}
+}
+
+// handleDeviceCallback signs in the user who is approving the device with the
+// sign-in's user code, then asks them to confirm the approval. Only the browser
+// session that started the sign-in can finish it.
+func (h *AuthHandler) handleDeviceCallback(w http.ResponseWriter, r *http.Request, code, state string, saved *models.OAuthState) {
+	session, ok := deviceSession(r)
+	if !ok || saved.DeviceSession == "" || subtle.ConstantTimeCompare([]byte(hashSession(session)), []byte(saved.DeviceSession)) != 1 {
+		logging.Warn("Device sign-in for %s finished in a different browser to the one it started in", saved.DeviceUserCode)
+		http.Error(w, "This sign-in was started in a different browser, please enter the code again", http.StatusForbidden)
+		return
+	}
+
+	user, err := h.signIn(code, state, "")
+	if err != nil {
+		authFailed(w, err)
+		return
+	}
+
+	authorization, err := h.userStore.SignInDeviceAuthorization(saved.DeviceUserCode, user.GithubID, hashSession(session))
+	if err != nil {
+		deviceApprovalFailed(w, err)
+		return
+	}
+
+	logging.Info("User %s signed in to approve device %s", user.Login, authorization.UserCode)
+
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := templates.RenderDeviceConfirmTemplate(w, user.Login, authorization.UserCode, authorization.KeyName, deviceApprovePath, csrfToken(session)); err != nil {
+		logging.Error("Failed to render template", err)
+		http.Error(w, "Failed to render template", http.StatusInternalServerError)
+	}
+}
+
+// DeviceApprove approves a device once the user who signed in for it confirms, from
+// the same browser session.
+func (h *AuthHandler) DeviceApprove(w http.ResponseWriter, r *http.Request) {
+	session, ok := deviceSession(r)
+	if !ok || !validCSRFToken(session, r.FormValue("csrf_token")) {
+		logging.Warn("Device approval submitted without a valid CSRF token")
+		http.Error(w, "This page has expired, please start again on your device", http.StatusForbidden)
+		return
+	}
+
+	authorization, err := h.userStore.ApproveDeviceAuthorization(r.FormValue("user_code"), hashSession(session))
+	if err != nil {
+		deviceApprovalFailed(w, err)
+		return
+	}
+
+	user, err := h.userStore.GetUser(authorization.GithubUserID)
+	if err != nil || user == nil {
+		http.Error(w, "Failed to get user", http.StatusInternalServerError)
+		return
+	}
+
+	logging.Info("Device %s approved by user: %s", authorization.UserCode, user.Login)
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := templates.RenderDeviceApprovedTemplate(w, user.Login); err != nil {
+		logging.Error("Failed to render template", err)
+		http.Error(w, "Failed to render template", http.StatusInternalServerError)
+	}
+}
+
+func deviceApprovalFailed(w http.ResponseWriter, err error) {
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "This device sign-in has expired or was already approved, please start again on your device", http.StatusBadRequest)
+		return
+	}
+	http.Error(w, "Failed to approve device", http.StatusInternalServerError)
+}
+
+// startDeviceSession returns the request's device session, starting a new one if it
+// doesn't have one.
+func (h *AuthHandler) startDeviceSession(w http.ResponseWriter, r *http.Request) string {
+	if session, ok := deviceSession(r); ok {
+		return session
+	}
+
+	bytes := make([]byte, 32)
+	rand.Read(bytes)
+	session := base64.RawURLEncoding.EncodeToString(bytes)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     deviceSessionCookie,
+		Value:    session,
+		Path:     "/api/auth",
+		MaxAge:   int(h.device.CodeTTL / time.Second),
+		Secure:   strings.HasPrefix(h.device.VerificationURI, "https://"),
+		HttpOnly: true,
+		// sent when the identity provider sends the user back, but not with
+		// requests other sites make
+		SameSite: http.SameSiteLaxMode,
+	})
+	return session
+}
+
+// deviceSession returns the request's device session, if it has one.
+func deviceSession(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(deviceSessionCookie)
+	if err != nil || cookie.Value == "" {
+		return "", false
+	}
+	return cookie.Value, true
+}
+
+// hashSession is what a device session is stored as.
+func hashSession(session string) string {
+	sum := sha256.Sum256([]byte(session))
+	return hex.EncodeToString(sum[:])
+}
+
+// csrfToken returns the token the device pages' forms submit, which is tied to the
+// browser's session so other sites can't submit the forms for it.
+func csrfToken(session string) string {
+	sum := sha256.Sum256([]byte("csrf:" + session))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func validCSRFToken(session, token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(csrfToken(session)), []byte(token)) == 1
+}
+
+// DeviceToken is polled by devices waiting to be approved. Until then it returns a
+// 400 with an RFC 8628 error: authorization_pending, slow_down (with the new
+// interval) or expired_token. Once approved it issues the device's API key, once.
+func (h *AuthHandler) DeviceToken(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		DeviceCode string `json:"device_code"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.DeviceCode == "" {
+		deviceError(w, "invalid_request", "device_code is required", nil)
+		return
+	}
+
+	authorization, err := h.userStore.PollDeviceAuthorization(request.DeviceCode)
+	switch {
+	case errors.Is(err, store.ErrAuthorizationPending):
+		deviceError(w, "authorization_pending", "The user hasn't approved this device yet", nil)
+		return
+	case errors.Is(err, store.ErrSlowDown):
+		deviceError(w, "slow_down", "Polling too often, wait longer between requests", map[string]any{"interval": authorization.Interval})
+		return
+	case errors.Is(err, store.ErrDeviceCodeExpired):
+		deviceError(w, "expired_token", "The device code has expired, please start again", nil)
+		return
+	case errors.Is(err, store.ErrNotFound):
+		deviceError(w, "invalid_grant", "Unknown or already used device code", nil)
+		return
+	case err != nil:
+		http.Error(w, "Failed to check device authorization", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := h.userStore.GetUser(authorization.GithubUserID)
+	if err != nil || user == nil {
+		http.Error(w, "Failed to get user", http.StatusInternalServerError)
+		return
+	}
+
+	credential, err := h.userStore.IssueAPIKey(user.GithubID, authorization.KeyName)
+	if err != nil {
+		http.Error(w, "Failed to issue API key", http.StatusInternalServerError)
+		return
+	}
+
+	logging.Info("Issued API key %s... to device %s for user: %s", credential.KeyPrefix, authorization.UserCode, user.Login)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]string{
+		"api_key":     credential.ApiKey,
+		"github_user": user.Login,
+		"provider":    user.Provider,
+	})
+}
+
+// deviceError responds with an RFC 8628 style error, plus any extra fields.
+func deviceError(w http.ResponseWriter, code, description string, extra map[string]any) {
+	body := map[string]any{
+		"error":             code,
+		"error_description": description,
+	}
+	for key, value := range extra {
+		body[key] = value
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(body)
+}
@@ -5,12 +5,20 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/josephburgess/breeze/internal/api/handlers"
 	"github.com/josephburgess/breeze/internal/api/middleware"
 	"github.com/josephburgess/breeze/internal/models"
+	"github.com/josephburgess/breeze/internal/services/auth"
+	"github.com/josephburgess/breeze/internal/services/store"
+	"github.com/josephburgess/breeze/internal/services/weather"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -44,7 +52,7 @@ func (m *MockWeatherClient) SearchCities(query string, limit int) ([]models.City
 type UserStoreInterface interface {
 	SaveUser(user *models.User) error
 	GetUser(githubID int64) (*models.User, error)
-	GetOrCreateAPICredential(githubUserID int64) (*models.ApiCredential, error)
+	IssueAPIKey(githubUserID int64, name string) (*models.ApiCredential, error)
 	ValidateAPIKey(apiKey string) (*models.User, error)
 	Close() error
 }
@@ -66,8 +74,8 @@ func (m *MockUserStore) GetUser(githubID int64) (*models.User, error) {
 	return args.Get(0).(*models.User), args.Error(1)
 }
 
-func (m *MockUserStore) GetOrCreateAPICredential(githubUserID int64) (*models.ApiCredential, error) {
-	args := m.Called(githubUserID)
+func (m *MockUserStore) IssueAPIKey(githubUserID int64, name string) (*models.ApiCredential, error) {
+	args := m.Called(githubUserID, name)
 	return args.Get(0).(*models.ApiCredential), args.Error(1)
 }
 
@@ -196,7 +204,11 @@ func TestWeatherHandler_GetWeather(t *testing.T) {
 }
 
 func TestUserHandler_GetUser(t *testing.T) {
-	handler := handlers.NewUserHandler()
+	userStore, err := store.NewUserStore(filepath.Join(t.TempDir(), "test.db"), "test-pepper")
+	require.NoError(t, err)
+	defer userStore.Close()
+
+	handler := handlers.NewUserHandler(userStore)
 
 	testUser := &models.User{
 		ID:       1,
@@ -231,6 +243,120 @@ func TestUserHandler_GetUser(t *testing.T) {
 	} else {
 		t.Fatalf("Neither github_id nor id found in response: %v", response)
 	}
+	assert.Contains(t, response, "preferences")
+}
+
+func TestUserHandler_Preferences(t *testing.T) {
+	userStore, err := store.NewUserStore(filepath.Join(t.TempDir(), "test.db"), "test-pepper")
+	require.NoError(t, err)
+	defer userStore.Close()
+
+	testUser := &models.User{GithubID: 12345, Login: "testuser", Token: "token"}
+	require.NoError(t, userStore.SaveUser(testUser))
+
+	handler := handlers.NewUserHandler(userStore)
+
+	request := func(method, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/user/preferences", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), middleware.UserContextKey, testUser))
+		rr := httptest.NewRecorder()
+		if method == "PUT" {
+			handler.UpdatePreferences(rr, req)
+		} else {
+			handler.GetPreferences(rr, req)
+		}
+		return rr
+	}
+
+	rr := request("PUT", `{"units":"imperial","language":"fr","time_format":"local","timezone":"Europe/Paris","sections":["current","daily"]}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	rr = request("GET", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var preferences models.UserPreferences
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &preferences))
+	assert.Equal(t, "imperial", preferences.Units)
+	assert.Equal(t, "fr", preferences.Language)
+	assert.Equal(t, "Europe/Paris", preferences.Timezone)
+	assert.Equal(t, []string{"current", "daily"}, preferences.Sections)
+
+	for _, body := range []string{
+		`{"units":"kelvin"}`,
+		`{"language":"french"}`,
+		`{"time_format":"local"}`,
+		`{"sections":["radar"]}`,
+		`{"default_location_id":99}`,
+	} {
+		rr = request("PUT", body)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, body)
+	}
+}
+
+func TestUserHandler_GetUsage(t *testing.T) {
+	userStore, err := store.NewUserStore(filepath.Join(t.TempDir(), "test.db"), "test-pepper")
+	require.NoError(t, err)
+	defer userStore.Close()
+
+	require.NoError(t, userStore.SaveUser(&models.User{GithubID: 12345, Login: "testuser", Token: "token"}))
+	credential, err := userStore.IssueAPIKey(12345, store.DefaultKeyName)
+	require.NoError(t, err)
+
+	handler := handlers.NewUserHandler(userStore)
+	router := mux.NewRouter()
+	apiRouter := router.PathPrefix("/api").Subrouter()
+	apiRouter.Use(middleware.ApiKeyAuth(userStore, middleware.QueryKeysAllow))
+	apiRouter.HandleFunc("/weather/{city}", func(w http.ResponseWriter, r *http.Request) {})
+	apiRouter.HandleFunc("/user/usage", handler.GetUsage)
+
+	request := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("X-API-Key", credential.ApiKey)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	request("/api/weather/London")
+	request("/api/weather/Paris")
+
+	rr := request("/api/user/usage")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var response struct {
+		Today struct {
+			Used      int `json:"used"`
+			Limit     int `json:"limit"`
+			Remaining int `json:"remaining"`
+		} `json:"today"`
+		Requests int `json:"requests"`
+		Days     []struct {
+			Day       string         `json:"day"`
+			Requests  int            `json:"requests"`
+			Endpoints map[string]int `json:"endpoints"`
+		} `json:"days"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+
+	assert.Equal(t, 3, response.Today.Used)
+	assert.Equal(t, 50, response.Today.Limit)
+	assert.Equal(t, 47, response.Today.Remaining)
+	assert.Equal(t, 3, response.Requests)
+	require.Len(t, response.Days, 7, "a week of days, including those without requests")
+	today := response.Days[6]
+	assert.Equal(t, time.Now().UTC().Format(time.DateOnly), today.Day)
+	assert.Equal(t, map[string]int{"/api/weather/{city}": 2, "/api/user/usage": 1}, today.Endpoints, "requests are grouped by route")
+	assert.Zero(t, response.Days[0].Requests)
+
+	for _, query := range []string{"?from=yesterday", "?from=2024-05-02&to=2024-05-01", "?from=2020-01-01&to=2024-01-01"} {
+		rr = request("/api/user/usage" + query)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, query)
+	}
+
+	rr = request("/api/user/usage?from=2024-05-01&to=2024-05-01")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Len(t, response.Days, 1)
 }
 
 func TestAuthHandler_RequestAuth(t *testing.T) {
@@ -274,3 +400,543 @@ func TestAuthHandler_RequestAuth(t *testing.T) {
 func stringPtr(s string) *string {
 	return &s
 }
+
+func TestAuthHandler_PerLoginRedirect(t *testing.T) {
+	userStore, err := store.NewUserStore(filepath.Join(t.TempDir(), "test.db"), "test-pepper")
+	require.NoError(t, err)
+	defer userStore.Close()
+
+	oauth := auth.NewOAuth("http://breeze.example/api/auth/callback", auth.NewMemoryStateStore(), auth.NewGitHubOAuth("client-id", "client-secret"))
+	handler := handlers.NewAuthHandler(oauth, userStore, handlers.DeviceFlowConfig{})
+
+	challenge := auth.CodeChallengeS256(auth.NewCodeVerifier())
+	start := func(query string) (*httptest.ResponseRecorder, map[string]string) {
+		rr := httptest.NewRecorder()
+		handler.RequestAuth(rr, httptest.NewRequest("GET", "/api/auth/request?code_challenge="+challenge+"&"+strings.TrimPrefix(query, "?"), nil))
+		var response map[string]string
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr, response
+	}
+
+	_, first := start("?callback_port=5000")
+	_, second := start("?callback_port=6000")
+	assert.Contains(t, first["url"], url.QueryEscape("http://localhost:5000/callback"))
+	assert.Contains(t, second["url"], url.QueryEscape("http://localhost:6000/callback"))
+	assert.Equal(t, "http://breeze.example/api/auth/callback", oauth.RedirectURI, "the shared config isn't changed")
+
+	// each sign-in is sent back to its own client, whichever started last
+	for state, port := range map[string]string{first["state"]: "5000", second["state"]: "6000"} {
+		rr := httptest.NewRecorder()
+		handler.Callback(rr, httptest.NewRequest("GET", "/api/auth/callback?code=abc&state="+state, nil))
+		assert.Equal(t, http.StatusFound, rr.Code)
+		assert.Equal(t, "http://localhost:"+port+"/callback?code=abc&state="+state, rr.Header().Get("Location"))
+	}
+
+	rr := httptest.NewRecorder()
+	handler.Callback(rr, httptest.NewRequest("GET", "/api/auth/callback?code=abc&state=unknown", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr, _ = start("")
+	assert.Equal(t, http.StatusOK, rr.Code, "callback_port defaults to 9876")
+
+	for _, port := range []string{"80", "65536", "abc", "-1"} {
+		rr, _ := start("?callback_port=" + port)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, port)
+	}
+
+	rr, _ = start("?provider=github")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr, _ = start("?provider=keycloak")
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "only configured providers")
+
+	rr = httptest.NewRecorder()
+	handler.Providers(rr, httptest.NewRequest("GET", "/api/auth/providers", nil))
+	assert.JSONEq(t, `{"providers": ["github"]}`, rr.Body.String())
+}
+
+func TestAuthHandler_ExchangeToken_PKCE(t *testing.T) {
+	userStore, err := store.NewUserStore(filepath.Join(t.TempDir(), "test.db"), "test-pepper")
+	require.NoError(t, err)
+	defer userStore.Close()
+
+	// stands in for both github.com and api.github.com
+	github := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login/oauth/access_token":
+			require.NoError(t, r.ParseForm())
+			if r.FormValue("code") != "good-code" || r.FormValue("code_verifier") == "" {
+				w.Write([]byte("error=bad_verification_code&error_description=The+code+passed+is+incorrect"))
+				return
+			}
+			w.Write([]byte("access_token=gh-token&token_type=bearer"))
+		case "/user":
+			assert.Equal(t, "token gh-token", r.Header.Get("Authorization"))
+			w.Write([]byte(`{"id": 4242, "login": "pkceuser"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer github.Close()
+
+	githubOAuth := auth.NewGitHubOAuth("client-id", "client-secret")
+	githubOAuth.GitHubURL = github.URL
+	githubOAuth.APIURL = github.URL
+	handler := handlers.NewAuthHandler(auth.NewOAuth("", auth.NewMemoryStateStore(), githubOAuth), userStore, handlers.DeviceFlowConfig{})
+
+	verifier := auth.NewCodeVerifier()
+	start := func() string {
+		rr := httptest.NewRecorder()
+		handler.RequestAuth(rr, httptest.NewRequest("GET", "/api/auth/request?code_challenge_method=S256&code_challenge="+auth.CodeChallengeS256(verifier), nil))
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var response map[string]string
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+		return response["state"]
+	}
+	exchange := func(body map[string]string) *httptest.ResponseRecorder {
+		encoded, err := json.Marshal(body)
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		handler.ExchangeToken(rr, httptest.NewRequest("POST", "/api/auth/exchange", strings.NewReader(string(encoded))))
+		return rr
+	}
+
+	t.Run("challenge is required", func(t *testing.T) {
+		for _, query := range []string{"", "?code_challenge=short", "?code_challenge_method=plain&code_challenge=" + auth.CodeChallengeS256(verifier)} {
+			rr := httptest.NewRecorder()
+			handler.RequestAuth(rr, httptest.NewRequest("GET", "/api/auth/request"+query, nil))
+			assert.Equal(t, http.StatusBadRequest, rr.Code, query)
+		}
+	})
+
+	t.Run("intercepted code without the verifier", func(t *testing.T) {
+		state := start()
+
+		rr := exchange(map[string]string{"code": "good-code", "state": state})
+		assert.Equal(t, http.StatusBadRequest, rr.Code, "code_verifier is required")
+
+		rr = exchange(map[string]string{"code": "good-code", "state": state, "code_verifier": auth.NewCodeVerifier()})
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), "code_verifier")
+
+		rr = exchange(map[string]string{"code": "good-code", "state": state, "code_verifier": verifier})
+		assert.Equal(t, http.StatusBadRequest, rr.Code, "the state was used up by the failed attempt")
+
+		user, err := userStore.GetUser(4242)
+		require.NoError(t, err)
+		assert.Nil(t, user, "GitHub was never asked, so no user or key was created")
+	})
+
+	t.Run("matching verifier", func(t *testing.T) {
+		state := start()
+
+		rr := exchange(map[string]string{"code": "good-code", "state": state, "code_verifier": verifier})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		var response map[string]string
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+		assert.Equal(t, "pkceuser", response["github_user"])
+
+		credential, _, _, _, err := userStore.ValidateAPIKey(response["api_key"])
+		require.NoError(t, err)
+		assert.Equal(t, int64(4242), credential.GithubUserID)
+	})
+
+	t.Run("code rejected by GitHub", func(t *testing.T) {
+		state := start()
+
+		rr := exchange(map[string]string{"code": "bad-code", "state": state, "code_verifier": verifier})
+		assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	})
+}
+
+func TestAuthHandler_DeviceFlow(t *testing.T) {
+	userStore, err := store.NewUserStore(filepath.Join(t.TempDir(), "test.db"), "test-pepper")
+	require.NoError(t, err)
+	defer userStore.Close()
+
+	github := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login/oauth/access_token":
+			w.Write([]byte("access_token=gh-token&token_type=bearer"))
+		case "/user":
+			w.Write([]byte(`{"id": 5150, "login": "headless"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer github.Close()
+
+	githubOAuth := auth.NewGitHubOAuth("client-id", "client-secret")
+	githubOAuth.GitHubURL = github.URL
+	githubOAuth.APIURL = github.URL
+	oauth := auth.NewOAuth("https://breeze.example/api/auth/callback", auth.NewMemoryStateStore(), githubOAuth)
+	handler := handlers.NewAuthHandler(oauth, userStore, handlers.DeviceFlowConfig{
+		VerificationURI: "https://breeze.example/api/auth/device/verify",
+		CodeTTL:         15 * time.Minute,
+	})
+
+	rr := httptest.NewRecorder()
+	handler.DeviceAuthorization(rr, httptest.NewRequest("POST", "/api/auth/device", strings.NewReader(`{"key_name": "server"}`)))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var device struct {
+		DeviceCode              string `json:"device_code"`
+		UserCode                string `json:"user_code"`
+		VerificationURI         string `json:"verification_uri"`
+		VerificationURIComplete string `json:"verification_uri_complete"`
+		ExpiresIn               int    `json:"expires_in"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &device))
+	assert.Equal(t, "https://breeze.example/api/auth/device/verify", device.VerificationURI)
+	assert.Equal(t, device.VerificationURI+"?user_code="+device.UserCode, device.VerificationURIComplete)
+	assert.Equal(t, 900, device.ExpiresIn)
+
+	poll := func(deviceCode string) (*httptest.ResponseRecorder, map[string]any) {
+		rr := httptest.NewRecorder()
+		handler.DeviceToken(rr, httptest.NewRequest("POST", "/api/auth/device/token", strings.NewReader(`{"device_code": "`+deviceCode+`"}`)))
+		var response map[string]any
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr, response
+	}
+
+	rr, response := poll(device.DeviceCode)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "authorization_pending", response["error"])
+
+	rr, response = poll("unknown")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "invalid_grant", response["error"])
+
+	// the user opens the verification page and enters the code
+	rr = httptest.NewRecorder()
+	handler.DeviceVerify(rr, httptest.NewRequest("GET", "/api/auth/device/verify?user_code="+device.UserCode, nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `value="`+device.UserCode+`"`)
+	cookies := rr.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.True(t, cookies[0].HttpOnly)
+	assert.True(t, cookies[0].Secure)
+	session := cookies[0]
+	token := csrfTokenIn(t, rr.Body.String())
+
+	post := func(handle http.HandlerFunc, path string, form url.Values, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		rr := httptest.NewRecorder()
+		handle(rr, req)
+		return rr
+	}
+	verify := func(userCode string) *httptest.ResponseRecorder {
+		return post(handler.DeviceVerify, "/api/auth/device/verify", url.Values{"user_code": {userCode}, "csrf_token": {token}}, session)
+	}
+
+	rr = post(handler.DeviceVerify, "/api/auth/device/verify", url.Values{"user_code": {device.UserCode}}, session)
+	assert.Equal(t, http.StatusForbidden, rr.Code, "the form needs its CSRF token")
+	rr = post(handler.DeviceVerify, "/api/auth/device/verify", url.Values{"user_code": {device.UserCode}, "csrf_token": {token}})
+	assert.Equal(t, http.StatusForbidden, rr.Code, "the token only works with the session it was issued for")
+
+	rr = verify("ZZZZ-ZZZZ")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "isn&#39;t valid")
+
+	rr = verify(strings.ToLower(device.UserCode))
+	require.Equal(t, http.StatusSeeOther, rr.Code)
+	location, err := url.Parse(rr.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, github.URL+"/login/oauth/authorize", location.Scheme+"://"+location.Host+location.Path)
+	assert.Equal(t, "https://breeze.example/api/auth/callback", location.Query().Get("redirect_uri"))
+
+	callback := func(cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/auth/callback?code=abc&state="+location.Query().Get("state"), nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		rr := httptest.NewRecorder()
+		handler.Callback(rr, req)
+		return rr
+	}
+
+	// someone else's browser can't finish a sign-in started in this one
+	rr = callback()
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	rr = callback(&http.Cookie{Name: session.Name, Value: "someone-else"})
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	// GitHub sends the user back to breeze, which asks them to confirm
+	rr = callback(session)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Contains(t, rr.Body.String(), "Approve this device?")
+	assert.Contains(t, rr.Body.String(), device.UserCode)
+	assert.Contains(t, rr.Body.String(), "server")
+	assert.Contains(t, rr.Body.String(), "headless")
+
+	approve := url.Values{"user_code": {device.UserCode}, "csrf_token": {csrfTokenIn(t, rr.Body.String())}}
+
+	_, response = poll(device.DeviceCode)
+	assert.Equal(t, "authorization_pending", response["error"], "signing in alone doesn't approve the device")
+
+	rr = post(handler.DeviceApprove, "/api/auth/device/approve", url.Values{"user_code": {device.UserCode}}, session)
+	assert.Equal(t, http.StatusForbidden, rr.Code, "approvals need the CSRF token")
+	rr = post(handler.DeviceApprove, "/api/auth/device/approve", approve)
+	assert.Equal(t, http.StatusForbidden, rr.Code, "approvals need the session")
+
+	rr = post(handler.DeviceApprove, "/api/auth/device/approve", approve, session)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Contains(t, rr.Body.String(), "Device approved")
+	assert.NotContains(t, rr.Body.String(), "gust_", "the key only goes to the device")
+
+	rr, response = poll(device.DeviceCode)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, "headless", response["github_user"])
+
+	credential, _, _, _, err := userStore.ValidateAPIKey(response["api_key"].(string))
+	require.NoError(t, err)
+	assert.Equal(t, "server", credential.Name)
+
+	rr, response = poll(device.DeviceCode)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "invalid_grant", response["error"], "the device code is used up")
+}
+
+// csrfTokenIn returns the CSRF token in a device page's form.
+func csrfTokenIn(t *testing.T, page string) string {
+	match := regexp.MustCompile(`name="csrf_token" value="([^"]+)"`).FindStringSubmatch(page)
+	require.NotNil(t, match, "the page has a CSRF token")
+	return match[1]
+}
+
+func TestAstronomyHandler_GetAstronomy(t *testing.T) {
+	handler := handlers.NewAstronomyHandler()
+
+	t.Run("valid request", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/api/astronomy?lat=51.5074&lon=-0.1278&date=2024-06-21", nil)
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		handler.GetAstronomy(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var response models.Astronomy
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+		assert.Equal(t, "2024-06-21", response.Date)
+		assert.NotZero(t, response.Sunrise)
+		assert.NotZero(t, response.CivilTwilight.Dusk)
+		assert.Greater(t, response.DayLength, int64(0))
+	})
+
+	t.Run("invalid params", func(t *testing.T) {
+		for _, query := range []string{"lat=abc&lon=0", "lat=0", "lat=95&lon=0", "lat=0&lon=0&date=21-06-2024"} {
+			req, err := http.NewRequest("GET", "/api/astronomy?"+query, nil)
+			require.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+			handler.GetAstronomy(rr, req)
+
+			assert.Equal(t, http.StatusBadRequest, rr.Code, query)
+		}
+	})
+}
+
+func TestAlertsHandler_GetAlertsByCoordinates(t *testing.T) {
+	now := time.Now()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/data/3.0/onecall", r.URL.Path)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(models.OneCallResponse{
+			Lat:      36.15,
+			Lon:      -95.99,
+			Timezone: "America/Chicago",
+			Alerts: []models.Alert{
+				{
+					SenderName: "NWS Tulsa",
+					Event:      "Tornado Warning",
+					Start:      now.Add(-time.Hour).Unix(),
+					End:        now.Add(time.Hour).Unix(),
+					Tags:       []string{"Tornado"},
+				},
+				{
+					SenderName: "NWS Tulsa",
+					Event:      "Heat Advisory",
+					Start:      now.Add(-3 * time.Hour).Unix(),
+					End:        now.Add(-time.Hour).Unix(),
+				},
+			},
+		})
+	}))
+	defer server.Close()
+
+	client := weather.NewClient("test-api-key")
+	client.BaseURL = server.URL + "/"
+	handler := handlers.NewAlertsHandler(client)
+
+	req, err := http.NewRequest("GET", "/api/alerts?lat=36.15&lon=-95.99", nil)
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	handler.GetAlertsByCoordinates(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var response models.AlertsResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, "America/Chicago", response.Timezone)
+	require.Len(t, response.Alerts, 1)
+	assert.Equal(t, "Tornado Warning", response.Alerts[0].Event)
+	assert.Equal(t, "severe", response.Alerts[0].Severity)
+	assert.Equal(t, "tornado", response.Alerts[0].Category)
+	assert.NotEmpty(t, response.Alerts[0].ID)
+
+	start, err := time.Parse(time.RFC3339, response.Alerts[0].Start)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(-time.Hour).Unix(), start.Unix())
+
+	req, err = http.NewRequest("GET", "/api/alerts?lat=36.15", nil)
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	handler.GetAlertsByCoordinates(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestTriggerHandler_CreateRule_WebhookURL(t *testing.T) {
+	userStore, err := store.NewUserStore(filepath.Join(t.TempDir(), "test.db"), "test-pepper")
+	require.NoError(t, err)
+	defer userStore.Close()
+
+	testUser := &models.User{GithubID: 12345, Login: "testuser", Token: "token"}
+	require.NoError(t, userStore.SaveUser(testUser))
+	credential, err := userStore.IssueAPIKey(testUser.GithubID, store.DefaultKeyName)
+	require.NoError(t, err)
+
+	handler := handlers.NewTriggerHandler(userStore)
+
+	create := func(webhookURL string) *httptest.ResponseRecorder {
+		body := `{"name": "rain", "lat": 51.5, "lon": -0.12, "expression": "hourly.pop > 0.8", "webhook_url": "` + webhookURL + `"}`
+		req := httptest.NewRequest("POST", "/api/triggers", strings.NewReader(body))
+		ctx := context.WithValue(req.Context(), middleware.UserContextKey, testUser)
+		ctx = context.WithValue(ctx, middleware.CredentialContextKey, credential)
+		rr := httptest.NewRecorder()
+		handler.CreateRule(rr, req.WithContext(ctx))
+		return rr
+	}
+
+	for _, webhookURL := range []string{"http://169.254.169.254/latest/meta-data", "http://127.0.0.1:8080/hook", "http://10.1.2.3/hook", "ftp://8.8.8.8/hook"} {
+		rr := create(webhookURL)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, webhookURL)
+	}
+
+	rr := create("https://8.8.8.8/hook")
+	assert.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+}
+
+func TestTriggerHandler_CreateRule_Limits(t *testing.T) {
+	userStore, err := store.NewUserStore(filepath.Join(t.TempDir(), "test.db"), "test-pepper")
+	require.NoError(t, err)
+	defer userStore.Close()
+
+	testUser := &models.User{GithubID: 12345, Login: "testuser", Token: "token"}
+	require.NoError(t, userStore.SaveUser(testUser))
+	credential, err := userStore.IssueAPIKey(testUser.GithubID, store.DefaultKeyName)
+	require.NoError(t, err)
+
+	handler := handlers.NewTriggerHandler(userStore)
+
+	create := func(expression string) *httptest.ResponseRecorder {
+		body := `{"name": "rain", "lat": 51.5, "lon": -0.12, "expression": "` + expression + `", "webhook_url": "https://8.8.8.8/hook"}`
+		req := httptest.NewRequest("POST", "/api/triggers", strings.NewReader(body))
+		ctx := context.WithValue(req.Context(), middleware.UserContextKey, testUser)
+		ctx = context.WithValue(ctx, middleware.CredentialContextKey, credential)
+		rr := httptest.NewRecorder()
+		handler.CreateRule(rr, req.WithContext(ctx))
+		return rr
+	}
+
+	rr := create(strings.Repeat("(", 3<<20) + "hourly.pop > 0.8")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+
+	rr = create(strings.Repeat("(", 100) + "hourly.pop > 0.8" + strings.Repeat(")", 100))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "nested more than")
+
+	rr = create(strings.Repeat("(", 2000))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "longer than")
+}
+
+func TestKeyHandler_RestrictedKeysCantWidenRestrictions(t *testing.T) {
+	userStore, err := store.NewUserStore(filepath.Join(t.TempDir(), "test.db"), "test-pepper")
+	require.NoError(t, err)
+	defer userStore.Close()
+
+	testUser := &models.User{GithubID: 12345, Login: "testuser", Token: "token"}
+	require.NoError(t, userStore.SaveUser(testUser))
+	restricted, err := userStore.CreateAPICredential(testUser.GithubID, "widget", models.AllScopes, nil, models.KeyRestrictions{
+		AllowedOrigins: []string{"https://*.example.com"},
+		AllowedCIDRs:   []string{"10.0.0.0/8"},
+	}, models.PlanFree)
+	require.NoError(t, err)
+
+	handler := handlers.NewKeyHandler(userStore)
+
+	serve := func(method, path, body string, vars map[string]string, h http.HandlerFunc) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		ctx := context.WithValue(req.Context(), middleware.UserContextKey, testUser)
+		ctx = context.WithValue(ctx, middleware.CredentialContextKey, restricted)
+		req = mux.SetURLVars(req.WithContext(ctx), vars)
+		rr := httptest.NewRecorder()
+		h(rr, req)
+		return rr
+	}
+	create := func(body string) *httptest.ResponseRecorder {
+		return serve("POST", "/api/keys", body, nil, handler.CreateKey)
+	}
+
+	t.Run("new keys inherit the caller's restrictions", func(t *testing.T) {
+		rr := create(`{"name": "inherited"}`)
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+		var created models.ApiCredential
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&created))
+		assert.Equal(t, restricted.AllowedOrigins, created.AllowedOrigins)
+		assert.Equal(t, restricted.AllowedCIDRs, created.AllowedCIDRs)
+	})
+
+	t.Run("narrower restrictions are allowed", func(t *testing.T) {
+		rr := create(`{"name": "narrower", "allowed_origins": ["https://app.example.com", "https://*.eu.example.com"], "allowed_cidrs": ["10.1.2.3"]}`)
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	})
+
+	t.Run("wider restrictions are refused", func(t *testing.T) {
+		for _, body := range []string{
+			`{"name": "wider", "allowed_origins": ["https://example.org"]}`,
+			`{"name": "wider", "allowed_origins": ["https://example.com"]}`,
+			`{"name": "wider", "allowed_origins": ["http://app.example.com"]}`,
+			`{"name": "wider", "allowed_cidrs": ["0.0.0.0/0"]}`,
+			`{"name": "wider", "allowed_cidrs": ["10.0.0.0/7"]}`,
+			`{"name": "wider", "allowed_cidrs": ["::/0"]}`,
+		} {
+			rr := create(body)
+			assert.Equal(t, http.StatusForbidden, rr.Code, body)
+		}
+	})
+
+	t.Run("restrictions can't be removed from other keys", func(t *testing.T) {
+		other, err := userStore.IssueAPIKey(testUser.GithubID, "laptop")
+		require.NoError(t, err)
+
+		rr := serve("PUT", "/api/keys/"+other.ID+"/restrictions", `{"allowed_cidrs": ["192.168.0.0/16"]}`, map[string]string{"id": other.ID}, handler.SetKeyRestrictions)
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+
+		rr = serve("PUT", "/api/keys/"+other.ID+"/restrictions", `{}`, map[string]string{"id": other.ID}, handler.SetKeyRestrictions)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		var updated models.ApiCredential
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&updated))
+		assert.Equal(t, restricted.AllowedOrigins, updated.AllowedOrigins, "empty lists take the caller's")
+		assert.Equal(t, restricted.AllowedCIDRs, updated.AllowedCIDRs)
+	})
+}
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/josephburgess/breeze/internal/logging"
+	"github.com/josephburgess/breeze/internal/models"
+	"github.com/josephburgess/breeze/internal/services/store"
+)
+
+// the longest a rotated key can keep working for
+const maxGracePeriod = 7 * 24 * time.Hour
+
+type KeyHandler struct {
+	userStore *store.UserStore
+}
+
+func NewKeyHandler(userStore *store.UserStore) *KeyHandler {
+	return &KeyHandler{
+		userStore: userStore,
+	}
+}
+
+func (h *KeyHandler) ListKeys(w http.ResponseWriter, r *http.Request) {
+	user, ok := accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	credentials, err := h.userStore.ListAPICredentials(user.GithubID)
+	if err != nil {
+		http.Error(w, "Failed to list API keys", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(credentials)
+}
+
+// CreateKey adds a named key to the user's account, for example for a second machine
+// or a dashboard, so each can be revoked on its own. expires_at (RFC 3339) is
+// optional. scopes defaults to those of the key making the request, and can't
+// include any it doesn't have. allowed_origins and allowed_cidrs restrict where the
+// key can be used from, default to the requesting key's and can't be wider than
+// them. The new key is on the same plan as the requesting one.
+func (h *KeyHandler) CreateKey(w http.ResponseWriter, r *http.Request) {
+	credential, ok := accountCredential(w, r)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+		models.KeyRestrictions
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		logging.Error("Invalid request body", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	request.Name = strings.TrimSpace(request.Name)
+	if request.Name == "" || len(request.Name) > 64 {
+		http.Error(w, "name is required and must be at most 64 characters", http.StatusBadRequest)
+		return
+	}
+
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(time.Now()) {
+		http.Error(w, "expires_at must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	if err := request.KeyRestrictions.Normalize(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := request.KeyRestrictions.Within(credential.KeyRestrictions); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	scopes := request.Scopes
+	if scopes == nil {
+		scopes = models.AllScopes
+	}
+	if len(scopes) == 0 {
+		http.Error(w, "scopes must not be empty", http.StatusBadRequest)
+		return
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(models.AllScopes, scope) {
+			http.Error(w, fmt.Sprintf("unknown scope %q", scope), http.StatusBadRequest)
+			return
+		}
+		if !credential.HasScope(scope) {
+			http.Error(w, fmt.Sprintf("this API key can't grant the %s scope it doesn't have", scope), http.StatusForbidden)
+			return
+		}
+	}
+
+	created, err := h.userStore.CreateAPICredential(credential.GithubUserID, request.Name, slices.Compact(slices.Sorted(slices.Values(scopes))), request.ExpiresAt, request.KeyRestrictions, credential.PlanName)
+	if err != nil {
+		if errors.Is(err, store.ErrDuplicateKeyName) {
+			http.Error(w, "You already have a key with that name", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to create API key", http.StatusInternalServerError)
+		return
+	}
+
+	// the key is only ever returned here
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// RotateKey replaces the key used for the request. grace_period (a duration such as
+// "24h", up to a week) keeps the old key working while clients are updated.
+func (h *KeyHandler) RotateKey(w http.ResponseWriter, r *http.Request) {
+	credential, ok := accountCredential(w, r)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		GracePeriod string `json:"grace_period"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+		logging.Error("Invalid request body", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var grace time.Duration
+	if request.GracePeriod != "" {
+		var err error
+		grace, err = time.ParseDuration(request.GracePeriod)
+		if err != nil || grace < 0 || grace > maxGracePeriod {
+			http.Error(w, "grace_period must be a duration between 0s and 168h", http.StatusBadRequest)
+			return
+		}
+	}
+
+	rotated, err := h.userStore.RotateAPIKey(credential.GithubUserID, credential.ID, grace)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "API key not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to rotate API key", http.StatusInternalServerError)
+		return
+	}
+
+	// the new key is only ever returned here
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rotated)
+}
+
+// SetKeyRestrictions replaces a key's allowed_origins and allowed_cidrs. Empty lists
+// remove the restriction, unless the key making the request has it, in which case
+// they take its lists. Restrictions wider than the requesting key's are refused.
+func (h *KeyHandler) SetKeyRestrictions(w http.ResponseWriter, r *http.Request) {
+	caller, ok := accountCredential(w, r)
+	if !ok {
+		return
+	}
+
+	var restrictions models.KeyRestrictions
+	if err := json.NewDecoder(r.Body).Decode(&restrictions); err != nil {
+		logging.Error("Invalid request body", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := restrictions.Normalize(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := restrictions.Within(caller.KeyRestrictions); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	credential, err := h.userStore.SetKeyRestrictions(caller.GithubUserID, mux.Vars(r)["id"], restrictions)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "API key not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to update API key", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(credential)
+}
+
+func (h *KeyHandler) RevokeKey(w http.ResponseWriter, r *http.Request) {
+	user, ok := accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.userStore.RevokeAPIKey(user.GithubID, mux.Vars(r)["id"]); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "API key not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to revoke API key", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
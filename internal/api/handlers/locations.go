@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/josephburgess/breeze/internal/logging"
+	"github.com/josephburgess/breeze/internal/models"
+	"github.com/josephburgess/breeze/internal/services/store"
+)
+
+type LocationHandler struct {
+	userStore *store.UserStore
+}
+
+func NewLocationHandler(userStore *store.UserStore) *LocationHandler {
+	return &LocationHandler{
+		userStore: userStore,
+	}
+}
+
+func (h *LocationHandler) ListLocations(w http.ResponseWriter, r *http.Request) {
+	user, ok := accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	locations, err := h.userStore.ListSavedLocations(user.GithubID)
+	if err != nil {
+		http.Error(w, "Failed to list saved locations", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(locations)
+}
+
+func (h *LocationHandler) CreateLocation(w http.ResponseWriter, r *http.Request) {
+	user, ok := accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Name      string  `json:"name"`
+		Lat       float64 `json:"lat"`
+		Lon       float64 `json:"lon"`
+		IsDefault bool    `json:"is_default"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		logging.Error("Invalid request body", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	location := &models.SavedLocation{
+		GithubUserID: user.GithubID,
+		Name:         strings.TrimSpace(request.Name),
+		Lat:          request.Lat,
+		Lon:          request.Lon,
+		IsDefault:    request.IsDefault,
+	}
+
+	if !validLocation(w, location) {
+		return
+	}
+
+	if err := h.userStore.CreateSavedLocation(location); err != nil {
+		if errors.Is(err, store.ErrTooManyLocations) {
+			http.Error(w, fmt.Sprintf("You can save up to %d locations", store.MaxSavedLocations), http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to save location", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(location)
+}
+
+func (h *LocationHandler) GetLocation(w http.ResponseWriter, r *http.Request) {
+	user, ok := accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	location, ok := h.findLocation(w, r, user.GithubID)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(location)
+}
+
+// UpdateLocation applies a partial update; fields left out of the body are kept.
+func (h *LocationHandler) UpdateLocation(w http.ResponseWriter, r *http.Request) {
+	user, ok := accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	location, ok := h.findLocation(w, r, user.GithubID)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Name      *string  `json:"name"`
+		Lat       *float64 `json:"lat"`
+		Lon       *float64 `json:"lon"`
+		IsDefault *bool    `json:"is_default"`
+		Position  *int     `json:"position"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		logging.Error("Invalid request body", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if request.Name != nil {
+		location.Name = strings.TrimSpace(*request.Name)
+	}
+	if request.Lat != nil {
+		location.Lat = *request.Lat
+	}
+	if request.Lon != nil {
+		location.Lon = *request.Lon
+	}
+	if request.IsDefault != nil {
+		location.IsDefault = *request.IsDefault
+	}
+	if request.Position != nil {
+		location.Position = *request.Position
+	}
+
+	if !validLocation(w, location) {
+		return
+	}
+
+	if err := h.userStore.UpdateSavedLocation(location); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "Location not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to update location", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(location)
+}
+
+func (h *LocationHandler) DeleteLocation(w http.ResponseWriter, r *http.Request) {
+	user, ok := accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid location id", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.userStore.DeleteSavedLocation(user.GithubID, uint(id)); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "Location not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to delete location", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *LocationHandler) findLocation(w http.ResponseWriter, r *http.Request, githubUserID int64) (*models.SavedLocation, bool) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid location id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	location, err := h.userStore.GetSavedLocation(githubUserID, uint(id))
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "Location not found", http.StatusNotFound)
+			return nil, false
+		}
+		http.Error(w, "Failed to get location", http.StatusInternalServerError)
+		return nil, false
+	}
+	return location, true
+}
+
+func validLocation(w http.ResponseWriter, location *models.SavedLocation) bool {
+	if location.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return false
+	}
+	if location.Lat < -90 || location.Lat > 90 || location.Lon < -180 || location.Lon > 180 {
+		http.Error(w, "Invalid coordinates", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
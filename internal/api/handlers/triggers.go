@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/josephburgess/breeze/internal/api/middleware"
+	"github.com/josephburgess/breeze/internal/logging"
+	"github.com/josephburgess/breeze/internal/models"
+	"github.com/josephburgess/breeze/internal/services/store"
+	"github.com/josephburgess/breeze/internal/services/triggers"
+	"github.com/josephburgess/breeze/internal/services/webhook"
+)
+
+// the largest rule request body accepted, which leaves room for an expression of
+// triggers.MaxExpressionLength
+const maxRuleRequestBytes = 16 << 10
+
+type TriggerHandler struct {
+	userStore *store.UserStore
+}
+
+func NewTriggerHandler(userStore *store.UserStore) *TriggerHandler {
+	return &TriggerHandler{
+		userStore: userStore,
+	}
+}
+
+func (h *TriggerHandler) CreateRule(w http.ResponseWriter, r *http.Request) {
+	credential, ok := accountCredential(w, r)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Name         string  `json:"name"`
+		LocationName string  `json:"location_name"`
+		Lat          float64 `json:"lat"`
+		Lon          float64 `json:"lon"`
+		Units        string  `json:"units"`
+		Expression   string  `json:"expression"`
+		WindowHours  int     `json:"window_hours"`
+		QuietStart   string  `json:"quiet_start"`
+		QuietEnd     string  `json:"quiet_end"`
+		WebhookURL   string  `json:"webhook_url"`
+		Secret       string  `json:"secret"`
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRuleRequestBytes)
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		logging.Error("Invalid request body", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := triggers.Parse(request.Expression); err != nil {
+		http.Error(w, "Invalid expression: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if request.Lat < -90 || request.Lat > 90 || request.Lon < -180 || request.Lon > 180 {
+		http.Error(w, "Invalid coordinates", http.StatusBadRequest)
+		return
+	}
+
+	if request.WindowHours < 0 || request.WindowHours > 48 {
+		http.Error(w, "window_hours must be between 0 (default of 24) and 48", http.StatusBadRequest)
+		return
+	}
+
+	if request.Units == "" {
+		request.Units = "metric"
+	}
+	if request.Units != "metric" && request.Units != "imperial" && request.Units != "standard" {
+		http.Error(w, "units must be metric, imperial or standard", http.StatusBadRequest)
+		return
+	}
+
+	if (request.QuietStart == "") != (request.QuietEnd == "") {
+		http.Error(w, "quiet_start and quiet_end must be set together", http.StatusBadRequest)
+		return
+	}
+	for _, clock := range []string{request.QuietStart, request.QuietEnd} {
+		if _, err := triggers.ParseClock(clock); clock != "" && err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if request.WebhookURL != "" {
+		target, err := webhook.ValidateURL(r.Context(), request.WebhookURL)
+		if err != nil {
+			http.Error(w, "webhook_url: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		request.WebhookURL = target.String()
+		if request.Secret == "" {
+			request.Secret = generateWebhookSecret()
+		}
+	}
+
+	rule := &models.TriggerRule{
+		CredentialID: credential.ID,
+		GithubUserID: credential.GithubUserID,
+		Name:         request.Name,
+		LocationName: request.LocationName,
+		Lat:          request.Lat,
+		Lon:          request.Lon,
+		Units:        request.Units,
+		Expression:   request.Expression,
+		WindowHours:  request.WindowHours,
+		QuietStart:   request.QuietStart,
+		QuietEnd:     request.QuietEnd,
+		WebhookURL:   request.WebhookURL,
+		Secret:       request.Secret,
+		Enabled:      true,
+	}
+
+	if err := h.userStore.CreateTriggerRule(rule); err != nil {
+		http.Error(w, "Failed to create trigger rule", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	if rule.WebhookURL == "" {
+		json.NewEncoder(w).Encode(rule)
+		return
+	}
+
+	// the webhook secret is only ever returned here
+	json.NewEncoder(w).Encode(struct {
+		*models.TriggerRule
+		Secret string `json:"secret"`
+	}{rule, rule.Secret})
+}
+
+func (h *TriggerHandler) ListRules(w http.ResponseWriter, r *http.Request) {
+	user, ok := accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	rules, err := h.userStore.ListTriggerRules(user.GithubID)
+	if err != nil {
+		http.Error(w, "Failed to list trigger rules", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+func (h *TriggerHandler) DeleteRule(w http.ResponseWriter, r *http.Request) {
+	user, ok := accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid rule id", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.userStore.DeleteTriggerRule(user.GithubID, uint(id)); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "Trigger rule not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to delete trigger rule", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListFirings is the poll endpoint for rules without a webhook. since accepts unix
+// seconds or RFC 3339 and defaults to the last 24 hours.
+func (h *TriggerHandler) ListFirings(w http.ResponseWriter, r *http.Request) {
+	user, ok := accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	since := time.Now().Add(-24 * time.Hour)
+	if value := r.URL.Query().Get("since"); value != "" {
+		if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+			since = time.Unix(seconds, 0)
+		} else if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+			since = parsed
+		} else {
+			http.Error(w, "since must be unix seconds or RFC 3339", http.StatusBadRequest)
+			return
+		}
+	}
+
+	firings, err := h.userStore.ListTriggerFirings(user.GithubID, since)
+	if err != nil {
+		http.Error(w, "Failed to list trigger firings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(firings)
+}
+
+// accountCredential returns the API credential used for the request, for resources
+// that belong to a specific key rather than the whole account.
+func accountCredential(w http.ResponseWriter, r *http.Request) (*models.ApiCredential, bool) {
+	if _, ok := accountUser(w, r); !ok {
+		return nil, false
+	}
+
+	credential, ok := r.Context().Value(middleware.CredentialContextKey).(*models.ApiCredential)
+	if !ok {
+		http.Error(w, "This endpoint requires a breeze API key", http.StatusForbidden)
+		return nil, false
+	}
+	return credential, true
+}
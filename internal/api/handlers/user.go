@@ -2,20 +2,283 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/josephburgess/breeze/internal/api/middleware"
+	"github.com/josephburgess/breeze/internal/logging"
 	"github.com/josephburgess/breeze/internal/models"
+	"github.com/josephburgess/breeze/internal/services/store"
+	"github.com/josephburgess/breeze/internal/services/timeformat"
 )
 
-type UserHandler struct{}
+type UserHandler struct {
+	userStore *store.UserStore
+}
 
-func NewUserHandler() *UserHandler {
-	return &UserHandler{}
+func NewUserHandler(userStore *store.UserStore) *UserHandler {
+	return &UserHandler{
+		userStore: userStore,
+	}
 }
 
+// GetUser returns the user's identity along with their preferences and default
+// location, so clients can configure themselves from a single call.
 func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
 	user := r.Context().Value(middleware.UserContextKey).(*models.User)
+
+	w.Header().Set("Content-Type", "application/json")
+
+	// custom OpenWeather key users have nothing stored beyond their identity
+	if user.GithubID == 0 {
+		json.NewEncoder(w).Encode(user)
+		return
+	}
+
+	preferences, err := h.userStore.GetPreferences(user.GithubID)
+	if err != nil {
+		http.Error(w, "Failed to get preferences", http.StatusInternalServerError)
+		return
+	}
+
+	defaultLocation, err := h.userStore.GetDefaultLocation(user.GithubID)
+	if err != nil {
+		http.Error(w, "Failed to get default location", http.StatusInternalServerError)
+		return
+	}
+
+	// only the prefix of the key is ever shown after it has been issued
+	var keyPrefix string
+	if credential, ok := r.Context().Value(middleware.CredentialContextKey).(*models.ApiCredential); ok {
+		keyPrefix = credential.KeyPrefix
+	}
+
+	json.NewEncoder(w).Encode(struct {
+		*models.User
+		APIKeyPrefix    string                  `json:"api_key_prefix,omitempty"`
+		Preferences     *models.UserPreferences `json:"preferences"`
+		DefaultLocation *models.SavedLocation   `json:"default_location"`
+	}{user, keyPrefix, preferences, defaultLocation})
+}
+
+func (h *UserHandler) GetPreferences(w http.ResponseWriter, r *http.Request) {
+	user, ok := accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	preferences, err := h.userStore.GetPreferences(user.GithubID)
+	if err != nil {
+		http.Error(w, "Failed to get preferences", http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(user)
+	json.NewEncoder(w).Encode(preferences)
+}
+
+// UpdatePreferences replaces the user's preferences. default_location_id is optional
+// and, when given, must be one of the user's saved locations.
+func (h *UserHandler) UpdatePreferences(w http.ResponseWriter, r *http.Request) {
+	user, ok := accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Units             string   `json:"units"`
+		Language          string   `json:"language"`
+		TimeFormat        string   `json:"time_format"`
+		Timezone          string   `json:"timezone"`
+		Sections          []string `json:"sections"`
+		DefaultLocationID *uint    `json:"default_location_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		logging.Error("Invalid request body", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if request.Units != "" && request.Units != "metric" && request.Units != "imperial" && request.Units != "standard" {
+		http.Error(w, "units must be metric, imperial or standard", http.StatusBadRequest)
+		return
+	}
+
+	if request.Language != "" && !validLanguage(request.Language) {
+		http.Error(w, "language must be an OpenWeather language code such as en or zh_cn", http.StatusBadRequest)
+		return
+	}
+
+	if request.TimeFormat != "" {
+		if _, err := timeformat.Parse(request.TimeFormat); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if request.Timezone != "" {
+		if _, err := time.LoadLocation(request.Timezone); err != nil {
+			http.Error(w, "timezone must be a valid IANA zone", http.StatusBadRequest)
+			return
+		}
+	} else if request.TimeFormat == timeformat.Local {
+		http.Error(w, "time_format local requires a timezone", http.StatusBadRequest)
+		return
+	}
+
+	sections, err := parseSections(strings.Join(request.Sections, ","))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	preferences := &models.UserPreferences{
+		GithubUserID:      user.GithubID,
+		Units:             request.Units,
+		Language:          request.Language,
+		TimeFormat:        request.TimeFormat,
+		Timezone:          request.Timezone,
+		Sections:          sections,
+		DefaultLocationID: request.DefaultLocationID,
+	}
+
+	if err := h.userStore.SavePreferences(preferences); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "Default location not found", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Failed to save preferences", http.StatusInternalServerError)
+		return
+	}
+
+	saved, err := h.userStore.GetPreferences(user.GithubID)
+	if err != nil {
+		http.Error(w, "Failed to get preferences", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(saved)
+}
+
+// usage history longer than this has to be requested in parts
+const maxUsageDays = 366
+
+type dayUsage struct {
+	Day       string         `json:"day"`
+	Requests  int            `json:"requests"`
+	Endpoints map[string]int `json:"endpoints"`
+}
+
+// GetUsage returns the requests counted against the key's daily limit today, and
+// the user's requests per day and endpoint across all their keys. from and to are
+// UTC dates (YYYY-MM-DD) and default to the last 7 days. Every day in the range is
+// included, with no requests if the user made none.
+func (h *UserHandler) GetUsage(w http.ResponseWriter, r *http.Request) {
+	credential, ok := accountCredential(w, r)
+	if !ok {
+		return
+	}
+
+	from, to, err := parseUsageRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	used, limit, resetTime, err := h.userStore.UsageToday(credential)
+	if err != nil {
+		http.Error(w, "Failed to get usage", http.StatusInternalServerError)
+		return
+	}
+
+	records, err := h.userStore.ListUsage(credential.GithubUserID, from, to)
+	if err != nil {
+		http.Error(w, "Failed to get usage", http.StatusInternalServerError)
+		return
+	}
+
+	days := make([]*dayUsage, 0, int(to.Sub(from).Hours()/24)+1)
+	byDay := make(map[string]*dayUsage)
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		usage := &dayUsage{Day: day.Format(time.DateOnly), Endpoints: map[string]int{}}
+		days = append(days, usage)
+		byDay[usage.Day] = usage
+	}
+
+	total := 0
+	for _, record := range records {
+		if usage, ok := byDay[record.Day]; ok {
+			usage.Requests += record.Count
+			usage.Endpoints[record.Endpoint] += record.Count
+			total += record.Count
+		}
+	}
+
+	today := struct {
+		Used      int       `json:"used"`
+		Limit     int       `json:"limit"`
+		Remaining *int      `json:"remaining,omitempty"`
+		ResetAt   time.Time `json:"reset_at"`
+	}{Used: used, Limit: limit, ResetAt: resetTime}
+	if limit > 0 {
+		remaining := max(limit-used, 0)
+		today.Remaining = &remaining
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"plan":     credential.PlanName,
+		"today":    today,
+		"from":     from.Format(time.DateOnly),
+		"to":       to.Format(time.DateOnly),
+		"requests": total,
+		"days":     days,
+	})
+}
+
+// parseUsageRange reads the from and to query parameters, defaulting to the 7 days
+// ending today (UTC).
+func parseUsageRange(r *http.Request) (time.Time, time.Time, error) {
+	now := time.Now().UTC()
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if value := r.URL.Query().Get("to"); value != "" {
+		parsed, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("to must be a date such as 2024-05-01")
+		}
+		to = parsed
+	}
+
+	from := to.AddDate(0, 0, -6)
+	if value := r.URL.Query().Get("from"); value != "" {
+		parsed, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("from must be a date such as 2024-05-01")
+		}
+		from = parsed
+	}
+
+	if from.After(to) {
+		return time.Time{}, time.Time{}, errors.New("from must not be after to")
+	}
+	if to.Sub(from) >= maxUsageDays*24*time.Hour {
+		return time.Time{}, time.Time{}, fmt.Errorf("usage can be requested for at most %d days at a time", maxUsageDays)
+	}
+	return from, to, nil
+}
+
+// accountUser returns the authenticated breeze user, rejecting requests made with a
+// custom OpenWeather key since those have no account to attach data to.
+func accountUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	user, ok := r.Context().Value(middleware.UserContextKey).(*models.User)
+	if !ok || user.GithubID == 0 {
+		http.Error(w, "This endpoint requires a breeze API key", http.StatusForbidden)
+		return nil, false
+	}
+	return user, true
 }
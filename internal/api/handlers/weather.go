@@ -2,57 +2,93 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/josephburgess/breeze/internal/api/middleware"
 	"github.com/josephburgess/breeze/internal/logging"
 	"github.com/josephburgess/breeze/internal/models"
+	"github.com/josephburgess/breeze/internal/services/astronomy"
+	"github.com/josephburgess/breeze/internal/services/store"
+	"github.com/josephburgess/breeze/internal/services/timeformat"
 	"github.com/josephburgess/breeze/internal/services/weather"
 )
 
 type WeatherHandler struct {
 	weatherClient *weather.Client
+	userStore     *store.UserStore
 }
 
-func NewWeatherHandler(weatherClient *weather.Client) *WeatherHandler {
+func NewWeatherHandler(weatherClient *weather.Client, userStore *store.UserStore) *WeatherHandler {
 	return &WeatherHandler{
 		weatherClient: weatherClient,
+		userStore:     userStore,
 	}
 }
 
+// GetWeather returns weather for the city in the path, or for the user's default
+// saved location when no city is given. Options missing from the query fall back to
+// the user's preferences.
 func (h *WeatherHandler) GetWeather(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	cityName := vars["city"]
-	units := r.URL.Query().Get("units")
-	var customApiKey string
-	if key, ok := r.Context().Value(middleware.CustomApiContextKey).(string); ok {
-		customApiKey = key
-		logging.Info("Using direct OpenWeather API key")
-	}
 
-	logging.Info("Fetching weather for city: %s", cityName)
-	if units != "" {
-		logging.Info("Using units: %s", units)
+	options, err := h.weatherOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	city, err := h.weatherClient.GetCoordinates(cityName, customApiKey)
-	if err != nil {
-		if strings.Contains(err.Error(), "invalid_api_key") {
-			logging.Error("Invalid API key provided", err)
-			http.Error(w, "Invalid API key", http.StatusUnauthorized)
+	customApiKey := customAPIKey(r)
+
+	var city *models.City
+	if cityName == "" {
+		user, ok := accountUser(w, r)
+		if !ok {
 			return
 		}
 
-		logging.Error("Error finding city", err)
-		http.Error(w, "Error finding city", http.StatusNotFound)
-		return
+		location, err := h.userStore.GetDefaultLocation(user.GithubID)
+		if err != nil {
+			http.Error(w, "Failed to get default location", http.StatusInternalServerError)
+			return
+		}
+		if location == nil {
+			http.Error(w, "No city given and no default location saved", http.StatusNotFound)
+			return
+		}
+
+		logging.Info("Fetching weather for default location: %s", location.Name)
+		city = &models.City{Name: location.Name, Lat: location.Lat, Lon: location.Lon}
+	} else {
+		logging.Info("Fetching weather for city: %s", cityName)
+
+		city, err = h.weatherClient.GetCoordinates(cityName, customApiKey)
+		if err != nil {
+			if strings.Contains(err.Error(), "invalid_api_key") {
+				logging.Error("Invalid API key provided", err)
+				http.Error(w, "Invalid API key", http.StatusUnauthorized)
+				return
+			}
+
+			logging.Error("Error finding city", err)
+			http.Error(w, "Error finding city", http.StatusNotFound)
+			return
+		}
+
+		logging.Info("Found city: %s (Lat: %f, Lon: %f)", city.Name, city.Lat, city.Lon)
 	}
 
-	logging.Info("Found city: %s (Lat: %f, Lon: %f)", city.Name, city.Lat, city.Lon)
+	if options.units != "" {
+		logging.Info("Using units: %s", options.units)
+	}
 
-	weather, err := h.weatherClient.GetWeather(city.Lat, city.Lon, units, customApiKey)
+	weather, err := h.weatherClient.GetLocalizedWeather(city.Lat, city.Lon, options.units, options.lang, customApiKey)
 	if err != nil {
 		logging.Error("Error getting weather", err)
 		http.Error(w, "Error getting weather", http.StatusInternalServerError)
@@ -61,13 +97,271 @@ func (h *WeatherHandler) GetWeather(w http.ResponseWriter, r *http.Request) {
 
 	logging.Info("Retrieved weather for city: %s", city.Name)
 
+	astronomy.Merge(weather)
+
 	response := models.WeatherResponse{
-		City:    city,
-		Weather: weather,
+		City:      city,
+		Weather:   weather,
+		Astronomy: astronomy.Calculate(city.Lat, city.Lon, time.Now().In(timeformat.LoadLocation(weather.Timezone, weather.TimezoneOffset))),
+	}
+
+	formatted, err := options.format(response, weather)
+	if err != nil {
+		logging.Error("Failed to format response", err)
+		http.Error(w, "Failed to format response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(formatted)
+}
+
+// how many saved locations' weather is fetched at once
+const maxConcurrentFetches = 4
+
+// GetSavedWeather returns weather for every saved location of the user, fetched in
+// parallel. Each location after the first counts as another request against the
+// key's limits, and locations over the limits are reported as rate limited. A
+// failure for one location is reported in its entry rather than failing the whole
+// response.
+func (h *WeatherHandler) GetSavedWeather(w http.ResponseWriter, r *http.Request) {
+	credential, ok := accountCredential(w, r)
+	if !ok {
+		return
+	}
+
+	options, err := h.weatherOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
+	locations, err := h.userStore.ListSavedLocations(credential.GithubUserID)
+	if err != nil {
+		http.Error(w, "Failed to list saved locations", http.StatusInternalServerError)
+		return
+	}
+
+	logging.Info("Fetching weather for %d saved locations", len(locations))
+
+	results := make([]any, len(locations))
+	var wg sync.WaitGroup
+	fetches := make(chan struct{}, maxConcurrentFetches)
+	for i := range locations {
+		location := &locations[i]
+		entry := models.SavedLocationWeather{Location: location}
+
+		// the request itself was counted for the first location
+		if i > 0 {
+			if _, _, _, err := h.userStore.CountAPIRequest(credential); err != nil {
+				logging.Warn("Saved location %d not fetched: %v", location.ID, err)
+				entry.Error = "Rate limit exceeded"
+				if _, ok := err.(*store.RateLimitError); !ok {
+					entry.Error = "Error getting weather"
+				}
+				results[i] = entry
+				continue
+			}
+			if err := h.userStore.RecordUsage(credential, "/api/weather/saved"); err != nil {
+				logging.Warn("Failed to record usage for API key %s...: %v", credential.KeyPrefix, err)
+			}
+		}
+
+		wg.Add(1)
+		fetches <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-fetches }()
+
+			weather, err := h.weatherClient.GetLocalizedWeather(location.Lat, location.Lon, options.units, options.lang, "")
+			if err != nil {
+				logging.Error("Error getting weather for saved location", err)
+				entry.Error = "Error getting weather"
+				results[i] = entry
+				return
+			}
+
+			astronomy.Merge(weather)
+			entry.Weather = weather
+			entry.Astronomy = astronomy.Calculate(location.Lat, location.Lon, time.Now().In(timeformat.LoadLocation(weather.Timezone, weather.TimezoneOffset)))
+
+			formatted, err := options.format(entry, weather)
+			if err != nil {
+				logging.Error("Failed to format response", err)
+				formatted = entry
+			}
+			results[i] = formatted
+		}()
+	}
+	wg.Wait()
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(results)
+}
+
+type weatherOptions struct {
+	units          string
+	lang           string
+	timeFormat     string
+	callerLocation *time.Location
+	sections       []string
+}
+
+// weatherOptions reads units, lang, time_format, tz and sections from the query,
+// falling back to the user's saved preferences for any that are left out.
+func (h *WeatherHandler) weatherOptions(r *http.Request) (*weatherOptions, error) {
+	query := r.URL.Query()
+	preferences := &models.UserPreferences{}
+
+	if user, ok := r.Context().Value(middleware.UserContextKey).(*models.User); ok && user.GithubID != 0 {
+		saved, err := h.userStore.GetPreferences(user.GithubID)
+		if err != nil {
+			logging.Error("Failed to load preferences, using defaults", err)
+		} else {
+			preferences = saved
+		}
+	}
+
+	options := &weatherOptions{
+		units:    preferences.Units,
+		lang:     preferences.Language,
+		sections: preferences.Sections,
+	}
+
+	if query.Has("units") {
+		options.units = query.Get("units")
+	}
+	if query.Has("lang") {
+		options.lang = query.Get("lang")
+		if options.lang != "" && !validLanguage(options.lang) {
+			return nil, fmt.Errorf("invalid lang %q", options.lang)
+		}
+	}
+	if query.Has("sections") {
+		sections, err := parseSections(query.Get("sections"))
+		if err != nil {
+			return nil, err
+		}
+		options.sections = sections
+	}
+
+	format := preferences.TimeFormat
+	if query.Has("time_format") {
+		format = query.Get("time_format")
+	}
+	tz := preferences.Timezone
+	if query.Has("tz") {
+		tz = query.Get("tz")
+	}
+
+	timeFormat, err := timeformat.Parse(format)
+	if err != nil {
+		return nil, err
+	}
+	options.timeFormat = timeFormat
+
+	if timeFormat == timeformat.Local {
+		callerLocation, err := time.LoadLocation(tz)
+		if err != nil || tz == "" {
+			return nil, fmt.Errorf("time_format=local requires a valid IANA tz parameter")
+		}
+		options.callerLocation = callerLocation
+	}
+
+	return options, nil
+}
+
+// format applies the time format and section selection to a response.
+func (o *weatherOptions) format(response any, weather *models.OneCallResponse) (any, error) {
+	formatted, err := formatTimestamps(response, weather, o.timeFormat, o.callerLocation)
+	if err != nil {
+		return nil, err
+	}
+	return selectSections(formatted, o.sections)
+}
+
+// formatTimestamps rewrites response timestamps for iso8601 (the location's zone) or
+// local (the caller's zone). unix responses are returned untouched.
+func formatTimestamps(response any, weather *models.OneCallResponse, timeFormat string, callerLocation *time.Location) (any, error) {
+	switch timeFormat {
+	case timeformat.ISO8601:
+		return timeformat.Rewrite(response, timeformat.LoadLocation(weather.Timezone, weather.TimezoneOffset))
+	case timeformat.Local:
+		return timeformat.Rewrite(response, callerLocation)
+	default:
+		return response, nil
+	}
+}
+
+// selectSections drops the weather sections the caller didn't ask for. An empty
+// selection keeps everything.
+func selectSections(response any, sections []string) (any, error) {
+	if len(sections) == 0 {
+		return response, nil
+	}
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		return nil, err
+	}
+
+	var tree map[string]any
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil, err
+	}
+
+	weather, _ := tree["weather"].(map[string]any)
+	for _, section := range models.WeatherSections {
+		if slices.Contains(sections, section) {
+			continue
+		}
+		if section == "astronomy" {
+			delete(tree, section)
+		} else if weather != nil {
+			delete(weather, section)
+		}
+	}
+
+	return tree, nil
+}
+
+// parseSections validates a comma separated list of weather sections.
+func parseSections(value string) ([]string, error) {
+	var sections []string
+	for section := range strings.SplitSeq(value, ",") {
+		section = strings.TrimSpace(section)
+		if section == "" {
+			continue
+		}
+		if !slices.Contains(models.WeatherSections, section) {
+			return nil, fmt.Errorf("invalid section %q: expected any of %s", section, strings.Join(models.WeatherSections, ", "))
+		}
+		sections = append(sections, section)
+	}
+	return sections, nil
+}
+
+// validLanguage accepts OpenWeather language codes such as "de" or "zh_cn".
+func validLanguage(lang string) bool {
+	code, region, hasRegion := strings.Cut(lang, "_")
+	if len(code) != 2 || hasRegion && len(region) != 2 {
+		return false
+	}
+	for _, c := range code + region {
+		if c < 'a' || c > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+// customAPIKey returns the user's own OpenWeather key, if they authenticated with one.
+func customAPIKey(r *http.Request) string {
+	if key, ok := r.Context().Value(middleware.CustomApiContextKey).(string); ok {
+		logging.Info("Using direct OpenWeather API key")
+		return key
+	}
+	return ""
 }
 
 func (h *WeatherHandler) SearchCities(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/josephburgess/breeze/internal/logging"
+	"github.com/josephburgess/breeze/internal/models"
+	"github.com/josephburgess/breeze/internal/services/store"
+	"github.com/josephburgess/breeze/internal/services/webhook"
+)
+
+type WebhookHandler struct {
+	userStore *store.UserStore
+}
+
+func NewWebhookHandler(userStore *store.UserStore) *WebhookHandler {
+	return &WebhookHandler{
+		userStore: userStore,
+	}
+}
+
+func (h *WebhookHandler) CreateSubscription(w http.ResponseWriter, r *http.Request) {
+	user, ok := accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Name   string  `json:"name"`
+		Lat    float64 `json:"lat"`
+		Lon    float64 `json:"lon"`
+		URL    string  `json:"url"`
+		Secret string  `json:"secret"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		logging.Error("Invalid request body", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if request.Lat < -90 || request.Lat > 90 || request.Lon < -180 || request.Lon > 180 {
+		http.Error(w, "Invalid coordinates", http.StatusBadRequest)
+		return
+	}
+
+	target, err := webhook.ValidateURL(r.Context(), request.URL)
+	if err != nil {
+		http.Error(w, "url: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if request.Secret == "" {
+		request.Secret = generateWebhookSecret()
+	}
+
+	subscription := &models.WebhookSubscription{
+		GithubUserID: user.GithubID,
+		Name:         request.Name,
+		Lat:          request.Lat,
+		Lon:          request.Lon,
+		URL:          target.String(),
+		Secret:       request.Secret,
+	}
+
+	if err := h.userStore.CreateWebhookSubscription(subscription); err != nil {
+		http.Error(w, "Failed to create webhook subscription", http.StatusInternalServerError)
+		return
+	}
+
+	// the secret is only ever returned here
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		*models.WebhookSubscription
+		Secret string `json:"secret"`
+	}{subscription, subscription.Secret})
+}
+
+func (h *WebhookHandler) ListSubscriptions(w http.ResponseWriter, r *http.Request) {
+	user, ok := accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	subscriptions, err := h.userStore.ListWebhookSubscriptions(user.GithubID)
+	if err != nil {
+		http.Error(w, "Failed to list webhook subscriptions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(subscriptions)
+}
+
+func (h *WebhookHandler) DeleteSubscription(w http.ResponseWriter, r *http.Request) {
+	user, ok := accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid subscription id", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.userStore.DeleteWebhookSubscription(user.GithubID, uint(id)); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "Webhook subscription not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to delete webhook subscription", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *WebhookHandler) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	user, ok := accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid subscription id", http.StatusBadRequest)
+		return
+	}
+
+	deliveries, err := h.userStore.ListWebhookDeliveries(user.GithubID, uint(id), 100)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "Webhook subscription not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to list webhook deliveries", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+func generateWebhookSecret() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return "whsec_" + hex.EncodeToString(b)
+}
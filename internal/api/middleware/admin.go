@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/josephburgess/breeze/internal/logging"
+	"github.com/josephburgess/breeze/internal/models"
+)
+
+// Admins are the users allowed to use the admin endpoints.
+type Admins struct {
+	// GitHub user IDs
+	GithubIDs []int64
+	// users of any provider as provider:subject, e.g. keycloak:f3b5c9e2
+	Identities []string
+}
+
+// Contains reports whether user is one of the admins. GithubIDs only match GitHub
+// users, as other accounts' IDs are handed out when they first sign in.
+func (a Admins) Contains(user *models.User) bool {
+	if user.Provider == models.ProviderGitHub && user.GithubID != 0 && slices.Contains(a.GithubIDs, user.GithubID) {
+		return true
+	}
+	return user.Provider != "" && user.Subject != "" && slices.Contains(a.Identities, user.Provider+":"+user.Subject)
+}
+
+// RequireAdmin only lets through users who are admins. It must run after
+// ApiKeyAuth.
+func RequireAdmin(admins Admins) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := r.Context().Value(UserContextKey).(*models.User)
+			if !ok || !admins.Contains(user) {
+				if ok {
+					logging.Warn("Non-admin user %s requested %s %s", user.Login, r.Method, r.URL.Path)
+				}
+				http.Error(w, "Admin access required", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
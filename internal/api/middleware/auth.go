@@ -2,11 +2,17 @@ package middleware
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/josephburgess/breeze/internal/logging"
 	"github.com/josephburgess/breeze/internal/models"
 	"github.com/josephburgess/breeze/internal/services/store"
@@ -15,14 +21,27 @@ import (
 type contextKey string
 
 const (
-	UserContextKey      contextKey = "user"
-	CustomApiContextKey contextKey = "custom-api-user"
+	UserContextKey       contextKey = "user"
+	CredentialContextKey contextKey = "credential"
+	CustomApiContextKey  contextKey = "custom-api-user"
 )
 
-func ApiKeyAuth(userStore *store.UserStore) func(http.Handler) http.Handler {
+// how api_key query parameters are treated, see QUERY_API_KEYS
+const (
+	QueryKeysAllow     = "allow"
+	QueryKeysDeprecate = "deprecate"
+	QueryKeysReject    = "reject"
+)
+
+const queryKeyWarning = `299 breeze "api_key in the query string is deprecated, send it as an Authorization: Bearer or X-API-Key header"`
+
+// ApiKeyAuth authenticates requests by API key. Keys are read from, in order of
+// precedence, an Authorization: Bearer header, an X-API-Key header and the api_key
+// query parameter. queryKeys controls whether the query parameter is accepted.
+func ApiKeyAuth(userStore *store.UserStore, queryKeys string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			apiKey := r.URL.Query().Get("api_key")
+			apiKey, fromQuery := extractAPIKey(r)
 
 			if apiKey == "" {
 				logging.Warn("API key is missing in request")
@@ -30,6 +49,18 @@ func ApiKeyAuth(userStore *store.UserStore) func(http.Handler) http.Handler {
 				return
 			}
 
+			if fromQuery {
+				switch queryKeys {
+				case QueryKeysReject:
+					logging.Warn("Rejected API key sent in query string")
+					http.Error(w, "API keys are not accepted in the query string, send it as an Authorization: Bearer or X-API-Key header", http.StatusUnauthorized)
+					return
+				case QueryKeysDeprecate:
+					w.Header().Set("Deprecation", "true")
+					w.Header().Add("Warning", queryKeyWarning)
+				}
+			}
+
 			if !strings.HasPrefix(apiKey, "gust_") {
 				logging.Info("User has provided a custom API key")
 				user := &models.User{
@@ -47,7 +78,41 @@ func ApiKeyAuth(userStore *store.UserStore) func(http.Handler) http.Handler {
 				return
 			}
 
-			user, dailyLimit, dailyUsed, resetTime, err := userStore.ValidateAPIKey(apiKey)
+			credential, err := userStore.LookupAPIKey(apiKey)
+			if err != nil {
+				if errors.Is(err, store.ErrAPIKeyRevoked) {
+					keyRejected(w, http.StatusUnauthorized, "api_key_revoked", "This API key has been revoked, please re-authenticate")
+					return
+				}
+				if errors.Is(err, store.ErrAPIKeyExpired) {
+					keyRejected(w, http.StatusUnauthorized, "api_key_expired", "This API key has expired, please re-authenticate")
+					return
+				}
+				logging.Warn("Invalid API key attempted: %s...", store.KeyPrefix(apiKey))
+				http.Error(w, "Invalid API key", http.StatusUnauthorized)
+				return
+			}
+
+			// requests the key's restrictions or plan reject aren't counted against its
+			// quota or recorded in its history
+			if !credential.AllowsOrigin(r.Header.Get("Origin"), r.Header.Get("Referer")) {
+				logging.Warn("API key %s... used from disallowed origin %q (referer %q)", credential.KeyPrefix, r.Header.Get("Origin"), r.Header.Get("Referer"))
+				keyRejected(w, http.StatusForbidden, "origin_not_allowed", "This API key can't be used from this origin")
+				return
+			}
+			if ip := clientIP(r); !credential.AllowsIP(ip) {
+				logging.Warn("API key %s... used from disallowed IP %s", credential.KeyPrefix, ip)
+				keyRejected(w, http.StatusForbidden, "ip_not_allowed", "This API key can't be used from this IP address")
+				return
+			}
+
+			if !credential.Plan.AllowsEndpoint(r.URL.Path) {
+				logging.Warn("API key %s... on plan %s requested %s, which the plan doesn't include", credential.KeyPrefix, credential.PlanName, r.URL.Path)
+				keyRejected(w, http.StatusForbidden, "endpoint_not_in_plan", fmt.Sprintf("The %s plan doesn't include this endpoint", credential.PlanName))
+				return
+			}
+
+			dailyLimit, dailyUsed, resetTime, err := userStore.CountAPIRequest(credential)
 
 			if dailyLimit > 0 {
 				remaining := max(dailyLimit-dailyUsed, 0)
@@ -59,18 +124,87 @@ func ApiKeyAuth(userStore *store.UserStore) func(http.Handler) http.Handler {
 
 			if err != nil {
 				if rateLimitErr, ok := err.(*store.RateLimitError); ok {
+					w.Header().Set("Retry-After", retryAfterSeconds(rateLimitErr.RetryAfter))
 					http.Error(w, rateLimitErr.Message, http.StatusTooManyRequests)
 					return
 				}
-				logging.Warn("Invalid API key attempted: %s", apiKey)
-				http.Error(w, "Invalid API key", http.StatusUnauthorized)
+				http.Error(w, "Failed to check API key usage", http.StatusInternalServerError)
 				return
 			}
 
+			if err := userStore.RecordUsage(credential, endpoint(r)); err != nil {
+				logging.Warn("Failed to record usage for API key %s...: %v", credential.KeyPrefix, err)
+			}
+
+			user := &credential.User
 			logging.Info("Authenticated user: %s", user.Login)
 
 			ctx := context.WithValue(r.Context(), UserContextKey, user)
+			ctx = context.WithValue(ctx, CredentialContextKey, credential)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
+
+// endpoint names the route a request matched for usage history, so requests for
+// different cities are counted together.
+func endpoint(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return r.URL.Path
+}
+
+// keyRejected responds to known keys that can't be used with a machine readable
+// reason, so clients can prompt the user to sign in again or fix the key's
+// restrictions rather than report a bad key.
+func keyRejected(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":   code,
+		"message": message,
+	})
+}
+
+// retryAfterSeconds formats d for a Retry-After header, rounding up so clients never
+// retry too early.
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.Itoa(max(int(math.Ceil(d.Seconds())), 1))
+}
+
+// clientIP returns the IP address the request came from, as worked out by RealIP.
+func clientIP(r *http.Request) net.IP {
+	if ip, ok := r.Context().Value(ClientIPContextKey).(net.IP); ok {
+		return ip
+	}
+	return remoteIP(r)
+}
+
+// remoteIP returns the address of the other end of the connection.
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// extractAPIKey returns the request's API key and whether it came from the query
+// string.
+func extractAPIKey(r *http.Request) (string, bool) {
+	if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		if token = strings.TrimSpace(token); token != "" {
+			return token, false
+		}
+	}
+
+	if apiKey := strings.TrimSpace(r.Header.Get("X-API-Key")); apiKey != "" {
+		return apiKey, false
+	}
+
+	apiKey := r.URL.Query().Get("api_key")
+	return apiKey, apiKey != ""
+}
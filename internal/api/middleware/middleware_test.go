@@ -2,12 +2,17 @@ package middleware_test
 
 import (
 	"context"
+	"encoding/json"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/josephburgess/breeze/internal/api/middleware"
 	"github.com/josephburgess/breeze/internal/models"
+	"github.com/josephburgess/breeze/internal/services/store"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -195,3 +200,478 @@ func TestApiKeyAuth_InvalidKey(t *testing.T) {
 	// Verify expectations
 	mockStore.AssertExpectations(t)
 }
+
+func setupAuthStore(t *testing.T) (*store.UserStore, string) {
+	userStore, err := store.NewUserStore(filepath.Join(t.TempDir(), "test.db"), "test-pepper")
+	require.NoError(t, err)
+	t.Cleanup(func() { userStore.Close() })
+
+	require.NoError(t, userStore.SaveUser(&models.User{GithubID: 12345, Login: "testuser", Token: "token"}))
+	credential, err := userStore.IssueAPIKey(12345, store.DefaultKeyName)
+	require.NoError(t, err)
+
+	return userStore, credential.ApiKey
+}
+
+func TestApiKeyAuth_KeySources(t *testing.T) {
+	userStore, apiKey := setupAuthStore(t)
+
+	var authenticated *models.User
+	handler := middleware.ApiKeyAuth(userStore, middleware.QueryKeysDeprecate)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authenticated, _ = r.Context().Value(middleware.UserContextKey).(*models.User)
+	}))
+
+	tests := []struct {
+		name        string
+		query       string
+		headers     map[string]string
+		wantStatus  int
+		wantWarning bool
+	}{
+		{"bearer header", "", map[string]string{"Authorization": "Bearer " + apiKey}, http.StatusOK, false},
+		{"lowercase scheme", "", map[string]string{"Authorization": "bearer " + apiKey}, http.StatusOK, false},
+		{"x-api-key header", "", map[string]string{"X-API-Key": apiKey}, http.StatusOK, false},
+		{"query parameter", "?api_key=" + apiKey, nil, http.StatusOK, true},
+		{"bearer wins over x-api-key", "", map[string]string{"Authorization": "Bearer " + apiKey, "X-API-Key": "gust_wrong"}, http.StatusOK, false},
+		{"header wins over query", "?api_key=gust_wrong", map[string]string{"X-API-Key": apiKey}, http.StatusOK, false},
+		{"invalid bearer", "", map[string]string{"Authorization": "Bearer gust_wrong"}, http.StatusUnauthorized, false},
+		{"basic auth is ignored", "", map[string]string{"Authorization": "Basic " + apiKey}, http.StatusUnauthorized, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authenticated = nil
+
+			req := httptest.NewRequest("GET", "/api/test"+tt.query, nil)
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.wantStatus, rr.Code)
+			assert.Equal(t, tt.wantWarning, rr.Header().Get("Warning") != "")
+			if tt.wantStatus == http.StatusOK {
+				require.NotNil(t, authenticated)
+				assert.Equal(t, "testuser", authenticated.Login)
+			}
+		})
+	}
+}
+
+func TestApiKeyAuth_QueryKeyPolicy(t *testing.T) {
+	userStore, apiKey := setupAuthStore(t)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	t.Run("allow", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		middleware.ApiKeyAuth(userStore, middleware.QueryKeysAllow)(next).ServeHTTP(rr, httptest.NewRequest("GET", "/api/test?api_key="+apiKey, nil))
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Empty(t, rr.Header().Get("Deprecation"))
+	})
+
+	t.Run("deprecate", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		middleware.ApiKeyAuth(userStore, middleware.QueryKeysDeprecate)(next).ServeHTTP(rr, httptest.NewRequest("GET", "/api/test?api_key="+apiKey, nil))
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "true", rr.Header().Get("Deprecation"))
+		assert.Contains(t, rr.Header().Get("Warning"), "Authorization: Bearer")
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		middleware.ApiKeyAuth(userStore, middleware.QueryKeysReject)(next).ServeHTTP(rr, httptest.NewRequest("GET", "/api/test?api_key="+apiKey, nil))
+
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		assert.Contains(t, rr.Body.String(), "not accepted in the query string")
+
+		req := httptest.NewRequest("GET", "/api/test", nil)
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		rr = httptest.NewRecorder()
+		middleware.ApiKeyAuth(userStore, middleware.QueryKeysReject)(next).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+	})
+}
+
+func TestApiKeyAuth_RevokedAndExpiredKeys(t *testing.T) {
+	userStore, apiKey := setupAuthStore(t)
+	handler := middleware.ApiKeyAuth(userStore, middleware.QueryKeysAllow)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/test", nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	credentials, err := userStore.ListAPICredentials(12345)
+	require.NoError(t, err)
+	require.Len(t, credentials, 1)
+
+	rotated, err := userStore.RotateAPIKey(12345, credentials[0].ID, 0)
+	require.NoError(t, err)
+
+	rr := request(apiKey)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.JSONEq(t, `{"error":"api_key_expired","message":"This API key has expired, please re-authenticate"}`, rr.Body.String())
+
+	require.NoError(t, userStore.RevokeAPIKey(12345, rotated.ID))
+
+	rr = request(rotated.ApiKey)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.JSONEq(t, `{"error":"api_key_revoked","message":"This API key has been revoked, please re-authenticate"}`, rr.Body.String())
+
+	rr = request("gust_unknown")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Invalid API key")
+}
+
+func TestRequireScopes(t *testing.T) {
+	handler := middleware.RequireScopes(models.ScopeWeatherRead, models.ScopeUserRead)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name       string
+		credential *models.ApiCredential
+		wantStatus int
+		wantScope  string
+	}{
+		{"all scopes", &models.ApiCredential{Scopes: models.AllScopes}, http.StatusOK, ""},
+		{"key from before scopes", &models.ApiCredential{}, http.StatusOK, ""},
+		{"custom openweather key", nil, http.StatusOK, ""},
+		{"weather only widget key", &models.ApiCredential{Scopes: []string{models.ScopeWeatherRead}}, http.StatusForbidden, models.ScopeUserRead},
+		{"account only key", &models.ApiCredential{Scopes: []string{models.ScopeUserRead}}, http.StatusForbidden, models.ScopeWeatherRead},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/weather/saved", nil)
+			if tt.credential != nil {
+				req = req.WithContext(context.WithValue(req.Context(), middleware.CredentialContextKey, tt.credential))
+			}
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.wantStatus, rr.Code)
+			if tt.wantScope != "" {
+				var body map[string]string
+				require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+				assert.Equal(t, "insufficient_scope", body["error"])
+				assert.Equal(t, tt.wantScope, body["scope"])
+			}
+		})
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	handler := middleware.RequireAdmin(middleware.Admins{
+		GithubIDs:  []int64{123, -1},
+		Identities: []string{"keycloak:f3b5c9e2"},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name       string
+		user       *models.User
+		wantStatus int
+	}{
+		{"github admin", &models.User{GithubID: 123, Provider: models.ProviderGitHub, Subject: "123"}, http.StatusOK},
+		{"github user", &models.User{GithubID: 456, Provider: models.ProviderGitHub, Subject: "456"}, http.StatusForbidden},
+		{"oidc admin", &models.User{GithubID: -2, Provider: "keycloak", Subject: "f3b5c9e2"}, http.StatusOK},
+		{"same subject at another provider", &models.User{GithubID: -3, Provider: "authentik", Subject: "f3b5c9e2"}, http.StatusForbidden},
+		{"oidc account id isn't enough", &models.User{GithubID: -1, Provider: "keycloak", Subject: "someone"}, http.StatusForbidden},
+		{"custom openweather key", &models.User{Login: "custom-api-user"}, http.StatusForbidden},
+		{"no user", nil, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/admin/plans", nil)
+			if tt.user != nil {
+				req = req.WithContext(context.WithValue(req.Context(), middleware.UserContextKey, tt.user))
+			}
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.wantStatus, rr.Code)
+		})
+	}
+}
+
+func TestApiKeyAuth_KeyRestrictions(t *testing.T) {
+	userStore, apiKey := setupAuthStore(t)
+	handler := middleware.ApiKeyAuth(userStore, middleware.QueryKeysAllow)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	credentials, err := userStore.ListAPICredentials(12345)
+	require.NoError(t, err)
+
+	restrictions := models.KeyRestrictions{
+		AllowedOrigins: []string{"https://joeburgess.dev/", "https://*.example.com", "http://localhost:3000"},
+		AllowedCIDRs:   []string{"192.0.2.0/24", "2001:db8::1"},
+	}
+	require.NoError(t, restrictions.Normalize())
+	assert.Equal(t, []string{"192.0.2.0/24", "2001:db8::1/128"}, restrictions.AllowedCIDRs)
+	_, err = userStore.SetKeyRestrictions(12345, credentials[0].ID, restrictions)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		wantStatus int
+		wantError  string
+	}{
+		{"allowed origin", "192.0.2.10:1234", map[string]string{"Origin": "https://joeburgess.dev"}, http.StatusOK, ""},
+		{"wildcard subdomain", "192.0.2.10:1234", map[string]string{"Origin": "https://www.example.com"}, http.StatusOK, ""},
+		{"wildcard doesn't match apex", "192.0.2.10:1234", map[string]string{"Origin": "https://example.com"}, http.StatusForbidden, "origin_not_allowed"},
+		{"port must match", "192.0.2.10:1234", map[string]string{"Origin": "http://localhost:8080"}, http.StatusForbidden, "origin_not_allowed"},
+		{"scheme must match", "192.0.2.10:1234", map[string]string{"Origin": "http://joeburgess.dev"}, http.StatusForbidden, "origin_not_allowed"},
+		{"referer fallback", "192.0.2.10:1234", map[string]string{"Referer": "https://joeburgess.dev/weather?x=1"}, http.StatusOK, ""},
+		{"lookalike domain", "192.0.2.10:1234", map[string]string{"Origin": "https://joeburgess.dev.evil.com"}, http.StatusForbidden, "origin_not_allowed"},
+		{"no origin", "192.0.2.10:1234", nil, http.StatusForbidden, "origin_not_allowed"},
+		{"ipv6 address", "[2001:db8::1]:1234", map[string]string{"Origin": "https://joeburgess.dev"}, http.StatusOK, ""},
+		{"ip outside ranges", "198.51.100.1:1234", map[string]string{"Origin": "https://joeburgess.dev"}, http.StatusForbidden, "ip_not_allowed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/test", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("X-API-Key", apiKey)
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.wantStatus, rr.Code)
+			if tt.wantError != "" {
+				var body map[string]string
+				require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+				assert.Equal(t, tt.wantError, body["error"])
+			}
+		})
+	}
+
+	// only the requests the restrictions allowed are counted and recorded
+	allowed := 0
+	for _, tt := range tests {
+		if tt.wantStatus == http.StatusOK {
+			allowed++
+		}
+	}
+	credential, err := userStore.LookupAPIKey(apiKey)
+	require.NoError(t, err)
+	used, _, _, err := userStore.UsageToday(credential)
+	require.NoError(t, err)
+	assert.Equal(t, allowed, used)
+	records, err := userStore.ListUsage(12345, time.Now().UTC().Add(-24*time.Hour), time.Now().UTC().Add(24*time.Hour))
+	require.NoError(t, err)
+	recorded := 0
+	for _, record := range records {
+		recorded += record.Count
+	}
+	assert.Equal(t, allowed, recorded)
+
+	invalid := models.KeyRestrictions{AllowedOrigins: []string{"joeburgess.dev"}}
+	assert.Error(t, invalid.Normalize())
+	invalid = models.KeyRestrictions{AllowedOrigins: []string{"https://joe*.dev"}}
+	assert.Error(t, invalid.Normalize())
+	invalid = models.KeyRestrictions{AllowedCIDRs: []string{"10.0.0.0/33"}}
+	assert.Error(t, invalid.Normalize())
+}
+
+func TestApiKeyAuth_PlanEndpoints(t *testing.T) {
+	userStore, apiKey := setupAuthStore(t)
+	handler := middleware.ApiKeyAuth(userStore, middleware.QueryKeysAllow)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	free, err := userStore.GetPlan(models.PlanFree)
+	require.NoError(t, err)
+	free.AllowedEndpoints = []string{"/api/weather"}
+	require.NoError(t, userStore.UpdatePlan(free))
+
+	tests := []struct {
+		path       string
+		wantStatus int
+	}{
+		{"/api/weather", http.StatusOK},
+		{"/api/weather/London", http.StatusOK},
+		{"/api/weatherman", http.StatusForbidden},
+		{"/api/user", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			req.Header.Set("X-API-Key", apiKey)
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.wantStatus, rr.Code)
+			if tt.wantStatus == http.StatusForbidden {
+				assert.Contains(t, rr.Body.String(), "endpoint_not_in_plan")
+			}
+		})
+	}
+
+	credential, err := userStore.LookupAPIKey(apiKey)
+	require.NoError(t, err)
+	used, _, _, err := userStore.UsageToday(credential)
+	require.NoError(t, err)
+	assert.Equal(t, 2, used, "requests for endpoints outside the plan aren't counted")
+}
+
+func TestApiKeyAuth_RateLimitHeaders(t *testing.T) {
+	userStore, apiKey := setupAuthStore(t)
+	handler := middleware.ApiKeyAuth(userStore, middleware.QueryKeysAllow)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	free, err := userStore.GetPlan(models.PlanFree)
+	require.NoError(t, err)
+	free.BurstLimit = 1
+	require.NoError(t, userStore.UpdatePlan(free))
+
+	request := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/test", nil)
+		req.Header.Set("X-API-Key", apiKey)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := request()
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "50", rr.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "49", rr.Header().Get("X-RateLimit-Remaining"))
+	reset, err := time.Parse(time.RFC3339, rr.Header().Get("X-RateLimit-Reset"))
+	require.NoError(t, err)
+	assert.Equal(t, time.Now().UTC().Truncate(24*time.Hour).Add(24*time.Hour), reset.UTC())
+	assert.Empty(t, rr.Header().Get("Retry-After"))
+
+	rr = request()
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "1", rr.Header().Get("Retry-After"))
+	assert.Equal(t, "49", rr.Header().Get("X-RateLimit-Remaining"))
+}
+
+func TestRealIP(t *testing.T) {
+	_, proxies, err := net.ParseCIDR("10.0.0.0/8")
+	require.NoError(t, err)
+
+	var got net.IP
+	handler := middleware.RealIP([]*net.IPNet{proxies})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = r.Context().Value(middleware.ClientIPContextKey).(net.IP)
+	}))
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"direct client", "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"untrusted client can't spoof", "203.0.113.7:5000", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted proxy", "10.0.0.2:5000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"spoofed hop before the real client", "10.0.0.2:5000", []string{"1.2.3.4, 198.51.100.1"}, "198.51.100.1"},
+		{"chain of trusted proxies", "10.0.0.2:5000", []string{"198.51.100.1, 10.0.0.3", "10.0.0.4"}, "198.51.100.1"},
+		{"garbage header", "10.0.0.2:5000", []string{"not-an-ip"}, "10.0.0.2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/cities/search", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			assert.Equal(t, tt.want, got.String())
+		})
+	}
+}
+
+func TestIPRateLimit(t *testing.T) {
+	_, proxies, err := net.ParseCIDR("10.0.0.0/8")
+	require.NoError(t, err)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	auth := middleware.RealIP([]*net.IPNet{proxies})(middleware.IPRateLimit("auth", middleware.IPRateLimitConfig{Burst: 2, Refill: time.Minute})(next))
+	search := middleware.RealIP([]*net.IPNet{proxies})(middleware.IPRateLimit("search", middleware.IPRateLimitConfig{Burst: 1, Refill: time.Minute})(next))
+
+	request := func(handler http.Handler, remoteAddr, forwarded string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		if forwarded != "" {
+			req.Header.Set("X-Forwarded-For", forwarded)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	assert.Equal(t, http.StatusOK, request(auth, "203.0.113.7:1", "").Code)
+	assert.Equal(t, http.StatusOK, request(auth, "203.0.113.7:2", "").Code)
+
+	rr := request(auth, "203.0.113.7:3", "")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "60", rr.Header().Get("Retry-After"))
+
+	assert.Equal(t, http.StatusOK, request(search, "203.0.113.7:4", "").Code, "route groups have separate budgets")
+	assert.Equal(t, http.StatusOK, request(auth, "10.0.0.2:1", "198.51.100.1").Code, "clients behind a proxy are limited separately")
+	assert.Equal(t, http.StatusTooManyRequests, request(auth, "10.0.0.2:1", "203.0.113.7").Code, "and by their own address")
+
+	assert.Equal(t, http.StatusOK, request(search, "[2001:db8::1]:1", "").Code)
+	assert.Equal(t, http.StatusTooManyRequests, request(search, "[2001:db8::2]:1", "").Code, "IPv6 clients are limited per /64")
+}
+
+// BenchmarkApiKeyAuth compares writing usage on every request with caching it in
+// memory and flushing it in the background.
+func BenchmarkApiKeyAuth(b *testing.B) {
+	for _, cached := range []bool{false, true} {
+		name := "write-through"
+		if cached {
+			name = "write-behind"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			userStore, err := store.NewUserStore(filepath.Join(b.TempDir(), "bench.db"), "test-pepper")
+			require.NoError(b, err)
+			defer userStore.Close()
+			if cached {
+				userStore.EnableUsageCache(time.Minute)
+			}
+
+			require.NoError(b, userStore.SaveUser(&models.User{GithubID: 12345, Login: "bench", Token: "token"}))
+			credential, err := userStore.IssueAPIKey(12345, store.DefaultKeyName)
+			require.NoError(b, err)
+			require.NoError(b, userStore.SetCredentialPlan(credential.ID, models.PlanUnlimited))
+
+			handler := middleware.ApiKeyAuth(userStore, middleware.QueryKeysAllow)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					req := httptest.NewRequest("GET", "/api/weather/London", nil)
+					req.Header.Set("X-API-Key", credential.ApiKey)
+					rr := httptest.NewRecorder()
+					handler.ServeHTTP(rr, req)
+					if rr.Code != http.StatusOK {
+						b.Fatalf("unexpected status %d", rr.Code)
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/josephburgess/breeze/internal/logging"
+	"github.com/josephburgess/breeze/internal/services/ratelimit"
+)
+
+const ClientIPContextKey contextKey = "client-ip"
+
+// IPRateLimitConfig is a per-client budget for a group of public routes: Burst
+// requests at once, then one more every Refill.
+type IPRateLimitConfig struct {
+	Burst  int
+	Refill time.Duration
+}
+
+// RealIP works out the client's IP address for the rest of the chain. X-Forwarded-For
+// is only believed when the request comes from one of trustedProxies, and is read
+// from the right, skipping further trusted proxies, so clients can't spoof it.
+func RealIP(trustedProxies []*net.IPNet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := remoteIP(r)
+
+			if trusted(ip, trustedProxies) {
+				forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+				for _, hop := range slices.Backward(forwarded) {
+					hopIP := net.ParseIP(strings.TrimSpace(hop))
+					if hopIP == nil {
+						break
+					}
+					ip = hopIP
+					if !trusted(ip, trustedProxies) {
+						break
+					}
+				}
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ClientIPContextKey, ip)))
+		})
+	}
+}
+
+// IPRateLimit limits how often each client IP can use the routes it wraps. IPv6
+// clients are limited per /64, since one host usually has a whole prefix.
+func IPRateLimit(group string, config IPRateLimitConfig) func(http.Handler) http.Handler {
+	limiter := ratelimit.NewLimiter(config.Refill)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if config.Burst <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ip := clientIP(r)
+			key := ip.String()
+			if ip.To4() == nil && ip != nil {
+				key = ip.Mask(net.CIDRMask(64, 128)).String()
+			}
+
+			if ok, _, retryAfter := limiter.Allow(key, config.Burst, time.Now()); !ok {
+				logging.Warn("Rate limited %s on %s routes: %s %s", key, group, r.Method, r.URL.Path)
+				w.Header().Set("Retry-After", retryAfterSeconds(retryAfter))
+				http.Error(w, "Too many requests, please slow down", http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func trusted(ip net.IP, proxies []*net.IPNet) bool {
+	for _, proxy := range proxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
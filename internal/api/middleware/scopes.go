@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/josephburgess/breeze/internal/logging"
+	"github.com/josephburgess/breeze/internal/models"
+)
+
+// RequireScopes rejects requests whose API key lacks any of scopes. It must run after
+// ApiKeyAuth. Custom OpenWeather keys have no breeze credential and are let through,
+// since they can only reach weather data.
+func RequireScopes(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			credential, ok := r.Context().Value(CredentialContextKey).(*models.ApiCredential)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			for _, scope := range scopes {
+				if !credential.HasScope(scope) {
+					logging.Warn("API key %s... missing scope %s for %s %s", credential.KeyPrefix, scope, r.Method, r.URL.Path)
+
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusForbidden)
+					json.NewEncoder(w).Encode(map[string]string{
+						"error":   "insufficient_scope",
+						"scope":   scope,
+						"message": fmt.Sprintf("This API key does not have the %s scope", scope),
+					})
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -1,33 +1,100 @@
 package api
 
 import (
+	"net"
+	"net/http"
+
 	"github.com/gorilla/mux"
 	"github.com/josephburgess/breeze/internal/api/handlers"
 	"github.com/josephburgess/breeze/internal/api/middleware"
+	"github.com/josephburgess/breeze/internal/models"
 	"github.com/josephburgess/breeze/internal/services/auth"
+	"github.com/josephburgess/breeze/internal/services/idlekeys"
 	"github.com/josephburgess/breeze/internal/services/store"
 	"github.com/josephburgess/breeze/internal/services/weather"
 )
 
-func NewRouter(weatherClient *weather.Client, userStore *store.UserStore, githubOAuth *auth.GitHubOAuth) *mux.Router {
+func NewRouter(weatherClient *weather.Client, userStore *store.UserStore, oauth *auth.OAuth, queryAPIKeys string, idleKeys *idlekeys.Cleaner, admins middleware.Admins, trustedProxies []*net.IPNet, authLimit, searchLimit middleware.IPRateLimitConfig, deviceFlow handlers.DeviceFlowConfig) *mux.Router {
 	router := mux.NewRouter()
+	router.Use(middleware.RealIP(trustedProxies))
 
 	// create handlers
-	authHandler := handlers.NewAuthHandler(githubOAuth, userStore)
-	userHandler := handlers.NewUserHandler()
-	weatherHandler := handlers.NewWeatherHandler(weatherClient)
+	authHandler := handlers.NewAuthHandler(oauth, userStore, deviceFlow)
+	userHandler := handlers.NewUserHandler(userStore)
+	weatherHandler := handlers.NewWeatherHandler(weatherClient, userStore)
+	astronomyHandler := handlers.NewAstronomyHandler()
+	alertsHandler := handlers.NewAlertsHandler(weatherClient)
+	webhookHandler := handlers.NewWebhookHandler(userStore)
+	triggerHandler := handlers.NewTriggerHandler(userStore)
+	locationHandler := handlers.NewLocationHandler(userStore)
+	keyHandler := handlers.NewKeyHandler(userStore)
+	adminHandler := handlers.NewAdminHandler(userStore, idleKeys)
+
+	// public routes, limited per client IP with a budget for each group
+	authRateLimit := middleware.IPRateLimit("auth", authLimit)
+	searchRateLimit := middleware.IPRateLimit("search", searchLimit)
 
 	// auth routes (public)
-	router.HandleFunc("/api/auth/request", authHandler.RequestAuth).Methods("GET")
+	router.HandleFunc("/api/auth/providers", authHandler.Providers).Methods("GET")
+	router.Handle("/api/auth/request", authRateLimit(http.HandlerFunc(authHandler.RequestAuth))).Methods("GET")
 	router.HandleFunc("/api/auth/callback", authHandler.Callback).Methods("GET")
-	router.HandleFunc("/api/auth/exchange", authHandler.ExchangeToken).Methods("POST")
-	router.HandleFunc("/api/cities/search", weatherHandler.SearchCities).Methods("GET")
+	router.Handle("/api/auth/exchange", authRateLimit(http.HandlerFunc(authHandler.ExchangeToken))).Methods("POST")
+	router.Handle("/api/auth/device", authRateLimit(http.HandlerFunc(authHandler.DeviceAuthorization))).Methods("POST")
+	router.Handle("/api/auth/device/verify", authRateLimit(http.HandlerFunc(authHandler.DeviceVerify))).Methods("GET", "POST")
+	router.Handle("/api/auth/device/approve", authRateLimit(http.HandlerFunc(authHandler.DeviceApprove))).Methods("POST")
+	// polling is paced per device code with slow_down rather than per IP
+	router.HandleFunc("/api/auth/device/token", authHandler.DeviceToken).Methods("POST")
+	router.Handle("/api/cities/search", searchRateLimit(http.HandlerFunc(weatherHandler.SearchCities))).Methods("GET")
 
 	// auth'ed routes (needs key)
 	apiRouter := router.PathPrefix("/api").Subrouter()
-	apiRouter.Use(middleware.ApiKeyAuth(userStore))
-	apiRouter.HandleFunc("/user", userHandler.GetUser).Methods("GET")
-	apiRouter.HandleFunc("/weather/{city}", weatherHandler.GetWeather).Methods("GET")
+	apiRouter.Use(middleware.ApiKeyAuth(userStore, queryAPIKeys))
+
+	// every authenticated route declares the scopes a key needs to use it
+	handle := func(path, method string, handler http.HandlerFunc, scopes ...string) {
+		apiRouter.Handle(path, middleware.RequireScopes(scopes...)(handler)).Methods(method)
+	}
+
+	handle("/user", "GET", userHandler.GetUser, models.ScopeUserRead)
+	handle("/user/preferences", "GET", userHandler.GetPreferences, models.ScopeUserRead)
+	handle("/user/preferences", "PUT", userHandler.UpdatePreferences, models.ScopeUserWrite)
+	handle("/user/usage", "GET", userHandler.GetUsage, models.ScopeUserRead)
+	handle("/keys", "GET", keyHandler.ListKeys, models.ScopeUserRead)
+	handle("/keys", "POST", keyHandler.CreateKey, models.ScopeUserWrite)
+	handle("/keys/rotate", "POST", keyHandler.RotateKey, models.ScopeUserWrite)
+	handle("/keys/{id}/restrictions", "PUT", keyHandler.SetKeyRestrictions, models.ScopeUserWrite)
+	handle("/keys/{id}", "DELETE", keyHandler.RevokeKey, models.ScopeUserWrite)
+	handle("/weather", "GET", weatherHandler.GetWeather, models.ScopeWeatherRead, models.ScopeUserRead)
+	handle("/weather/saved", "GET", weatherHandler.GetSavedWeather, models.ScopeWeatherRead, models.ScopeUserRead)
+	handle("/weather/{city}", "GET", weatherHandler.GetWeather, models.ScopeWeatherRead)
+	handle("/astronomy", "GET", astronomyHandler.GetAstronomy, models.ScopeWeatherRead)
+	handle("/alerts", "GET", alertsHandler.GetAlertsByCoordinates, models.ScopeWeatherRead)
+	handle("/alerts/{city}", "GET", alertsHandler.GetAlerts, models.ScopeWeatherRead)
+	handle("/webhooks", "GET", webhookHandler.ListSubscriptions, models.ScopeAlertsSubscribe)
+	handle("/webhooks", "POST", webhookHandler.CreateSubscription, models.ScopeAlertsSubscribe)
+	handle("/webhooks/{id}", "DELETE", webhookHandler.DeleteSubscription, models.ScopeAlertsSubscribe)
+	handle("/webhooks/{id}/deliveries", "GET", webhookHandler.ListDeliveries, models.ScopeAlertsSubscribe)
+	handle("/triggers", "GET", triggerHandler.ListRules, models.ScopeAlertsSubscribe)
+	handle("/triggers", "POST", triggerHandler.CreateRule, models.ScopeAlertsSubscribe)
+	handle("/triggers/firings", "GET", triggerHandler.ListFirings, models.ScopeAlertsSubscribe)
+	handle("/triggers/{id}", "DELETE", triggerHandler.DeleteRule, models.ScopeAlertsSubscribe)
+	handle("/locations", "GET", locationHandler.ListLocations, models.ScopeUserRead)
+	handle("/locations", "POST", locationHandler.CreateLocation, models.ScopeLocationsWrite)
+	handle("/locations/{id}", "GET", locationHandler.GetLocation, models.ScopeUserRead)
+	handle("/locations/{id}", "PUT", locationHandler.UpdateLocation, models.ScopeLocationsWrite)
+	handle("/locations/{id}", "DELETE", locationHandler.DeleteLocation, models.ScopeLocationsWrite)
+
+	// admin routes, for the users in ADMIN_GITHUB_IDS and ADMIN_USERS
+	requireAdmin := middleware.RequireAdmin(admins)
+	handleAdmin := func(path, method string, handler http.HandlerFunc) {
+		handle(path, method, requireAdmin(handler).ServeHTTP, models.ScopeUserRead)
+	}
+
+	handleAdmin("/admin/idle-keys", "GET", adminHandler.IdleKeysReport)
+	handleAdmin("/admin/plans", "GET", adminHandler.ListPlans)
+	handleAdmin("/admin/plans/{name}", "PUT", adminHandler.UpdatePlan)
+	handleAdmin("/admin/keys/{id}/plan", "PUT", adminHandler.SetKeyPlan)
+	handleAdmin("/admin/usage", "GET", adminHandler.EndpointUsage)
 
 	return router
 }
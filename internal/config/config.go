@@ -1,7 +1,13 @@
 package config
 
 import (
+	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/josephburgess/breeze/internal/logging"
@@ -14,7 +20,34 @@ type Config struct {
 	GithubClientID     string
 	GithubClientSecret string
 	GithubRedirectURI  string
+	OIDCName           string
+	OIDCIssuer         string
+	OIDCClientID       string
+	OIDCClientSecret   string
+	OIDCScopes         string
 	JWTSecret          string
+	APIKeyPepper       string
+	AlertPollInterval  time.Duration
+	TriggerInterval    time.Duration
+	ForecastCacheTTL   time.Duration
+	QueryAPIKeys       string
+	RateLimitScope     string
+	IdleKeyFlagDays    int
+	IdleKeyDisableDays int
+	IdleKeyInterval    time.Duration
+	AdminGithubIDs     []int64
+	AdminUsers         []string
+	TrustedProxies     []*net.IPNet
+	AuthIPBurst        int
+	AuthIPRefill       time.Duration
+	SearchIPBurst      int
+	SearchIPRefill     time.Duration
+	UsageFlushInterval time.Duration
+	OAuthStateStore    string
+	OAuthStateTTL      time.Duration
+	PublicURL          string
+	DeviceCodeTTL      time.Duration
+	DevicePollInterval time.Duration
 }
 
 func Load() *Config {
@@ -28,23 +61,130 @@ func Load() *Config {
 	githubClientID := getEnv("GITHUB_CLIENT_ID", "")
 	githubClientSecret := getEnv("GITHUB_CLIENT_SECRET", "")
 	githubRedirectURI := getEnv("GITHUB_REDIRECT_URI", "http://localhost:8080/api/auth/callback")
+	oidcName := getEnv("OIDC_NAME", "oidc")
+	oidcIssuer := getEnv("OIDC_ISSUER", "")
+	oidcClientID := getEnv("OIDC_CLIENT_ID", "")
+	oidcClientSecret := getEnv("OIDC_CLIENT_SECRET", "")
+	oidcScopes := getEnv("OIDC_SCOPES", "openid profile email")
 	jwtSecret := getEnv("JWT_SECRET", "")
+	apiKeyPepper := getEnv("API_KEY_PEPPER", "")
+	alertPollInterval := getEnvDuration("ALERT_POLL_INTERVAL", 15*time.Minute)
+	triggerInterval := getEnvDuration("TRIGGER_INTERVAL", 10*time.Minute)
+	forecastCacheTTL := getEnvDuration("FORECAST_CACHE_TTL", 10*time.Minute)
+	queryAPIKeys := getEnv("QUERY_API_KEYS", "deprecate")
+	rateLimitScope := getEnv("RATE_LIMIT_SCOPE", "key")
+	idleKeyFlagDays := getEnvInt("IDLE_KEY_FLAG_DAYS", 60)
+	idleKeyDisableDays := getEnvInt("IDLE_KEY_DISABLE_DAYS", 0)
+	idleKeyInterval := getEnvDuration("IDLE_KEY_INTERVAL", 24*time.Hour)
+	adminGithubIDs := getEnv("ADMIN_GITHUB_IDS", "")
+	adminUsers := getEnv("ADMIN_USERS", "")
+	trustedProxies := getEnv("TRUSTED_PROXIES", "")
+	authIPBurst := getEnvInt("AUTH_IP_BURST", 10)
+	authIPRefill := getEnvDuration("AUTH_IP_REFILL", 30*time.Second)
+	searchIPBurst := getEnvInt("SEARCH_IP_BURST", 30)
+	searchIPRefill := getEnvDuration("SEARCH_IP_REFILL", 2*time.Second)
+	usageFlushInterval := getEnvDuration("USAGE_FLUSH_INTERVAL", 10*time.Second)
+	oauthStateStore := getEnv("OAUTH_STATE_STORE", "memory")
+	oauthStateTTL := getEnvDuration("OAUTH_STATE_TTL", 10*time.Minute)
+	publicURL := getEnv("PUBLIC_URL", "")
+	deviceCodeTTL := getEnvDuration("DEVICE_CODE_TTL", 15*time.Minute)
+	devicePollInterval := getEnvDuration("DEVICE_POLL_INTERVAL", 5*time.Second)
 
 	if openWeatherAPIKey == "" {
 		logging.Error("Missing required environment variable: OPENWEATHER_API_KEY", nil)
 		os.Exit(1)
 	}
 
-	if githubClientID == "" || githubClientSecret == "" {
+	// GitHub sign-in is optional when an OpenID Connect provider is configured
+	if (githubClientID == "") != (githubClientSecret == "") || (githubClientID == "" && oidcIssuer == "") {
 		logging.Error("Missing required environment variables: GITHUB_CLIENT_ID and/or GITHUB_CLIENT_SECRET", nil)
 		os.Exit(1)
 	}
 
+	if oidcIssuer != "" && oidcClientID == "" {
+		logging.Error("OIDC_CLIENT_ID must be set when OIDC_ISSUER is", nil)
+		os.Exit(1)
+	}
+
+	if oidcName == "" || oidcName == "github" {
+		logging.Error("OIDC_NAME must be set, and can't be github", nil)
+		os.Exit(1)
+	}
+
 	if jwtSecret == "" {
 		logging.Error("Missing required environment variable: JWT_SECRET", nil)
 		os.Exit(1)
 	}
 
+	if queryAPIKeys != "allow" && queryAPIKeys != "deprecate" && queryAPIKeys != "reject" {
+		logging.Error("QUERY_API_KEYS must be one of allow, deprecate or reject", nil)
+		os.Exit(1)
+	}
+
+	if rateLimitScope != "key" && rateLimitScope != "user" {
+		logging.Error("RATE_LIMIT_SCOPE must be key or user", nil)
+		os.Exit(1)
+	}
+
+	if idleKeyFlagDays < 0 || idleKeyDisableDays < 0 {
+		logging.Error("IDLE_KEY_FLAG_DAYS and IDLE_KEY_DISABLE_DAYS can't be negative", nil)
+		os.Exit(1)
+	}
+
+	admins, err := parseGithubIDs(adminGithubIDs)
+	if err != nil {
+		logging.Error("ADMIN_GITHUB_IDS must be a comma separated list of GitHub user ids", err)
+		os.Exit(1)
+	}
+
+	adminIdentities, err := parseIdentities(adminUsers)
+	if err != nil {
+		logging.Error("ADMIN_USERS must be a comma separated list of provider:subject pairs", err)
+		os.Exit(1)
+	}
+
+	proxies, err := parseCIDRs(trustedProxies)
+	if err != nil {
+		logging.Error("TRUSTED_PROXIES must be a comma separated list of IP addresses or CIDR ranges", err)
+		os.Exit(1)
+	}
+
+	if authIPRefill <= 0 || searchIPRefill <= 0 {
+		logging.Error("AUTH_IP_REFILL and SEARCH_IP_REFILL must be positive", nil)
+		os.Exit(1)
+	}
+
+	if oauthStateStore != "memory" && oauthStateStore != "sqlite" {
+		logging.Error("OAUTH_STATE_STORE must be memory or sqlite", nil)
+		os.Exit(1)
+	}
+
+	if oauthStateTTL <= 0 {
+		logging.Error("OAUTH_STATE_TTL must be positive", nil)
+		os.Exit(1)
+	}
+
+	if deviceCodeTTL <= 0 || devicePollInterval < time.Second {
+		logging.Error("DEVICE_CODE_TTL must be positive and DEVICE_POLL_INTERVAL at least 1s", nil)
+		os.Exit(1)
+	}
+
+	// breeze is usually served from wherever GitHub redirects back to
+	if publicURL == "" {
+		redirect, err := url.Parse(githubRedirectURI)
+		if err != nil || redirect.Scheme == "" || redirect.Host == "" {
+			logging.Error("PUBLIC_URL must be set when GITHUB_REDIRECT_URI isn't an absolute URL", err)
+			os.Exit(1)
+		}
+		publicURL = redirect.Scheme + "://" + redirect.Host
+	}
+	publicURL = strings.TrimSuffix(publicURL, "/")
+
+	if apiKeyPepper == "" {
+		logging.Warn("API_KEY_PEPPER not set, hashing API keys with JWT_SECRET")
+		apiKeyPepper = jwtSecret
+	}
+
 	logging.Info("Configuration loaded successfully")
 
 	return &Config{
@@ -54,7 +194,34 @@ func Load() *Config {
 		GithubClientID:     githubClientID,
 		GithubClientSecret: githubClientSecret,
 		GithubRedirectURI:  githubRedirectURI,
+		OIDCName:           oidcName,
+		OIDCIssuer:         oidcIssuer,
+		OIDCClientID:       oidcClientID,
+		OIDCClientSecret:   oidcClientSecret,
+		OIDCScopes:         oidcScopes,
 		JWTSecret:          jwtSecret,
+		APIKeyPepper:       apiKeyPepper,
+		AlertPollInterval:  alertPollInterval,
+		TriggerInterval:    triggerInterval,
+		ForecastCacheTTL:   forecastCacheTTL,
+		QueryAPIKeys:       queryAPIKeys,
+		RateLimitScope:     rateLimitScope,
+		IdleKeyFlagDays:    idleKeyFlagDays,
+		IdleKeyDisableDays: idleKeyDisableDays,
+		IdleKeyInterval:    idleKeyInterval,
+		AdminGithubIDs:     admins,
+		AdminUsers:         adminIdentities,
+		TrustedProxies:     proxies,
+		AuthIPBurst:        authIPBurst,
+		AuthIPRefill:       authIPRefill,
+		SearchIPBurst:      searchIPBurst,
+		SearchIPRefill:     searchIPRefill,
+		UsageFlushInterval: usageFlushInterval,
+		OAuthStateStore:    oauthStateStore,
+		OAuthStateTTL:      oauthStateTTL,
+		PublicURL:          publicURL,
+		DeviceCodeTTL:      deviceCodeTTL,
+		DevicePollInterval: devicePollInterval,
 	}
 }
 
@@ -64,3 +231,87 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		logging.Warn("Invalid duration for %s: %s, using default %s", key, value, fallback)
+		return fallback
+	}
+	return duration
+}
+
+func getEnvInt(key string, fallback int) int {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		logging.Warn("Invalid number for %s: %s, using default %d", key, value, fallback)
+		return fallback
+	}
+	return number
+}
+
+func parseGithubIDs(value string) ([]int64, error) {
+	var ids []int64
+	for _, field := range strings.Split(value, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// parseIdentities parses a comma separated list of provider:subject pairs, such as
+// keycloak:f3b5c9e2.
+func parseIdentities(value string) ([]string, error) {
+	var identities []string
+	for _, field := range strings.Split(value, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		provider, subject, ok := strings.Cut(field, ":")
+		if !ok || provider == "" || subject == "" {
+			return nil, fmt.Errorf("invalid identity %q", field)
+		}
+		identities = append(identities, field)
+	}
+	return identities, nil
+}
+
+// parseCIDRs parses a comma separated list of CIDR ranges, where single addresses
+// are taken as /32 or /128.
+func parseCIDRs(value string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, field := range strings.Split(value, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		if !strings.Contains(field, "/") {
+			if ip := net.ParseIP(field); ip != nil && ip.To4() != nil {
+				field += "/32"
+			} else {
+				field += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(field)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
@@ -2,6 +2,7 @@ package logging
 
 import (
 	"net/http"
+	"net/url"
 	"time"
 )
 
@@ -15,8 +16,21 @@ func Middleware(next http.Handler) http.Handler {
 			"HTTP Request",
 			"remote_addr", r.RemoteAddr,
 			"method", r.Method,
-			"uri", r.RequestURI,
+			"uri", redactURI(r.URL),
 			"duration", duration,
 		)
 	})
 }
+
+// redactURI hides api keys still sent by older clients in the query string.
+func redactURI(u *url.URL) string {
+	query := u.Query()
+	if !query.Has("api_key") {
+		return u.RequestURI()
+	}
+
+	query.Set("api_key", "REDACTED")
+	redacted := *u
+	redacted.RawQuery = query.Encode()
+	return redacted.RequestURI()
+}
@@ -0,0 +1,34 @@
+package models
+
+type TimeRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+type Twilight struct {
+	Dawn int64 `json:"dawn"`
+	Dusk int64 `json:"dusk"`
+}
+
+type LightPeriods struct {
+	Morning TimeRange `json:"morning"`
+	Evening TimeRange `json:"evening"`
+}
+
+type Astronomy struct {
+	Date                 string       `json:"date"`
+	Lat                  float64      `json:"lat"`
+	Lon                  float64      `json:"lon"`
+	Sunrise              int64        `json:"sunrise"`
+	Sunset               int64        `json:"sunset"`
+	SolarNoon            int64        `json:"solar_noon"`
+	DayLength            int64        `json:"day_length"`
+	CivilTwilight        Twilight     `json:"civil_twilight"`
+	NauticalTwilight     Twilight     `json:"nautical_twilight"`
+	AstronomicalTwilight Twilight     `json:"astronomical_twilight"`
+	GoldenHour           LightPeriods `json:"golden_hour"`
+	BlueHour             LightPeriods `json:"blue_hour"`
+	MoonPhase            float64      `json:"moon_phase"`
+	MoonPhaseName        string       `json:"moon_phase_name"`
+	MoonIllumination     float64      `json:"moon_illumination"`
+}
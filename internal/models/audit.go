@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// audit actions
+const (
+	AuditKeyFlaggedIdle  = "key.flagged_idle"
+	AuditKeyDisabledIdle = "key.disabled_idle"
+)
+
+// AuditEntry records a change made to an account by breeze itself rather than by
+// the user, such as idle keys being flagged or disabled.
+type AuditEntry struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	GithubUserID int64     `gorm:"not null;index" json:"github_user_id"`
+	CredentialID string    `gorm:"index" json:"credential_id,omitempty"`
+	Action       string    `gorm:"not null" json:"action"`
+	Detail       string    `json:"detail,omitempty"`
+	CreatedAt    time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
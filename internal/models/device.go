@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// DeviceAuthorization is a sign-in for a device that can't open a browser, such as
+// gust over SSH. The device polls with its device code while the user enters the
+// user code in a browser elsewhere and signs in with GitHub.
+type DeviceAuthorization struct {
+	// the device code is a secret held by the device, so only its hash is stored
+	DeviceCodeHash string `gorm:"primaryKey" json:"-"`
+	// what the user types in, e.g. BDWP-HQLT
+	UserCode string `gorm:"not null;uniqueIndex" json:"user_code"`
+	// name of the key issued to the device
+	KeyName string `gorm:"not null" json:"key_name"`
+	// who approved the device, 0 while pending
+	GithubUserID int64 `gorm:"not null;default:0;index" json:"github_user_id"`
+	// who signed in to approve the device, and the hash of their browser session,
+	// which has to confirm the approval
+	SignedInUserID int64  `gorm:"not null;default:0" json:"-"`
+	SessionHash    string `json:"-"`
+	// seconds the device must wait between polls
+	Interval     int        `gorm:"not null" json:"interval"`
+	LastPolledAt *time.Time `json:"last_polled_at,omitempty"`
+	ExpiresAt    time.Time  `gorm:"not null;index" json:"expires_at"`
+	CreatedAt    time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
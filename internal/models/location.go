@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+type SavedLocation struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	GithubUserID int64     `gorm:"not null;index" json:"github_user_id"`
+	Name         string    `gorm:"not null" json:"name"`
+	Lat          float64   `gorm:"not null" json:"lat"`
+	Lon          float64   `gorm:"not null" json:"lon"`
+	IsDefault    bool      `json:"is_default"`
+	Position     int       `json:"position"`
+	CreatedAt    time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt    time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+	User         User      `gorm:"foreignKey:GithubUserID;references:GithubID" json:"-"`
+}
+
+type SavedLocationWeather struct {
+	Location  *SavedLocation   `json:"location"`
+	Weather   *OneCallResponse `json:"weather,omitempty"`
+	Astronomy *Astronomy       `json:"astronomy,omitempty"`
+	Error     string           `json:"error,omitempty"`
+}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// OAuthState is a sign-in in progress, from the state sent to the identity provider
+// until the code it returns with it is exchanged. Each state can be used once.
+type OAuthState struct {
+	State string `gorm:"primaryKey" json:"state"`
+	// name of the identity provider the user signs in with
+	Provider string `gorm:"not null;default:github" json:"provider"`
+	// where the provider was asked to send the user back to, which the code
+	// exchange must repeat
+	RedirectURI string `gorm:"not null" json:"redirect_uri"`
+	// OpenID Connect nonce the provider's ID token must carry
+	Nonce string `json:"-"`
+	// PKCE verifier for the challenge sent to the provider
+	CodeVerifier string `gorm:"not null" json:"-"`
+	// S256 PKCE challenge from the client, which must send the matching verifier
+	// to exchange the code
+	CodeChallenge string `json:"-"`
+	// user code of the device this sign-in approves, for the device flow
+	DeviceUserCode string `json:"-"`
+	// hash of the browser session that started a device sign-in, which must be the
+	// one to finish it
+	DeviceSession string    `json:"-"`
+	ExpiresAt     time.Time `gorm:"not null;index" json:"expires_at"`
+	CreatedAt     time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
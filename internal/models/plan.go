@@ -0,0 +1,51 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	PlanFree      = "free"
+	PlanSupporter = "supporter"
+	PlanAdmin     = "admin"
+	PlanUnlimited = "unlimited"
+)
+
+// Plan sets the limits for every credential on it, so changing a plan changes them
+// for all of its users at once.
+type Plan struct {
+	Name string `gorm:"primaryKey" json:"name"`
+	// requests per day, 0 for no limit
+	DailyLimit int `gorm:"not null;default:0" json:"daily_limit"`
+	// requests that can be made in quick succession, 0 for no limit
+	BurstLimit int `gorm:"not null;default:0" json:"burst_limit"`
+	// path prefixes such as /api/weather the plan can use, empty for all endpoints
+	AllowedEndpoints []string  `gorm:"serializer:json" json:"allowed_endpoints"`
+	UpdatedAt        time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// DefaultPlans are created on startup if missing. Edits to them in the database are
+// kept. Free comes first, and is also used for credentials whose plan is missing.
+var DefaultPlans = []Plan{
+	{Name: PlanFree, DailyLimit: 50, BurstLimit: 10},
+	{Name: PlanSupporter, DailyLimit: 500, BurstLimit: 30},
+	{Name: PlanAdmin, DailyLimit: 5000, BurstLimit: 100},
+	{Name: PlanUnlimited},
+}
+
+// AllowsEndpoint reports whether path is, or is under, one of the plan's allowed
+// endpoints.
+func (p *Plan) AllowsEndpoint(path string) bool {
+	if len(p.AllowedEndpoints) == 0 {
+		return true
+	}
+
+	for _, endpoint := range p.AllowedEndpoints {
+		endpoint = strings.TrimSuffix(endpoint, "/")
+		if path == endpoint || strings.HasPrefix(path, endpoint+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// WeatherSections are the parts of a weather response a user can choose to receive.
+var WeatherSections = []string{"current", "minutely", "hourly", "daily", "alerts", "astronomy"}
+
+type UserPreferences struct {
+	GithubUserID      int64     `gorm:"primaryKey;autoIncrement:false" json:"-"`
+	Units             string    `json:"units"`
+	Language          string    `json:"language"`
+	TimeFormat        string    `json:"time_format"`
+	Timezone          string    `json:"timezone"`
+	Sections          []string  `gorm:"serializer:json" json:"sections"`
+	DefaultLocationID *uint     `gorm:"-" json:"default_location_id"`
+	UpdatedAt         time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+	User              User      `gorm:"foreignKey:GithubUserID;references:GithubID" json:"-"`
+}
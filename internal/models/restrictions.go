@@ -0,0 +1,184 @@
+package models
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// KeyRestrictions limit where a key can be used from, for keys that end up in client
+// side code such as the website widget. Empty lists mean no restriction.
+type KeyRestrictions struct {
+	// origins such as https://example.com, with an optional *. wildcard for subdomains
+	// (https://*.example.com)
+	AllowedOrigins []string `gorm:"serializer:json" json:"allowed_origins,omitempty"`
+	// CIDR ranges the client IP must fall in, single IPs are stored as /32 or /128
+	AllowedCIDRs []string `gorm:"column:allowed_cidrs;serializer:json" json:"allowed_cidrs,omitempty"`
+}
+
+// Normalize validates the restrictions and rewrites them in canonical form.
+func (k *KeyRestrictions) Normalize() error {
+	origins := make([]string, 0, len(k.AllowedOrigins))
+	for _, pattern := range k.AllowedOrigins {
+		origin, err := normalizeOriginPattern(pattern)
+		if err != nil {
+			return err
+		}
+		origins = append(origins, origin)
+	}
+
+	cidrs := make([]string, 0, len(k.AllowedCIDRs))
+	for _, cidr := range k.AllowedCIDRs {
+		cidr = strings.TrimSpace(cidr)
+		if ip := net.ParseIP(cidr); ip != nil {
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			cidr = (&net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}).String()
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid CIDR %q", cidr)
+		}
+		cidrs = append(cidrs, network.String())
+	}
+
+	k.AllowedOrigins = slices.Compact(slices.Sorted(slices.Values(origins)))
+	k.AllowedCIDRs = slices.Compact(slices.Sorted(slices.Values(cidrs)))
+	return nil
+}
+
+// Within checks that k allows nothing parent doesn't, filling in any list k leaves
+// empty with parent's. Both must be normalized. It's used so that a restricted key
+// can't hand out a key that works from more places than it does.
+func (k *KeyRestrictions) Within(parent KeyRestrictions) error {
+	if len(k.AllowedOrigins) == 0 {
+		k.AllowedOrigins = parent.AllowedOrigins
+	}
+	if len(k.AllowedCIDRs) == 0 {
+		k.AllowedCIDRs = parent.AllowedCIDRs
+	}
+
+	if len(parent.AllowedOrigins) > 0 {
+		for _, origin := range k.AllowedOrigins {
+			if !parent.coversOriginPattern(origin) {
+				return fmt.Errorf("origin %q is not allowed for this API key", origin)
+			}
+		}
+	}
+
+	if len(parent.AllowedCIDRs) > 0 {
+		for _, cidr := range k.AllowedCIDRs {
+			if !parent.coversCIDR(cidr) {
+				return fmt.Errorf("CIDR %q is not allowed for this API key", cidr)
+			}
+		}
+	}
+	return nil
+}
+
+// coversOriginPattern reports whether every origin matching pattern is allowed.
+func (k *KeyRestrictions) coversOriginPattern(pattern string) bool {
+	if slices.Contains(k.AllowedOrigins, pattern) {
+		return true
+	}
+
+	// a wildcard only matches subdomains of its host, so it's covered by a wildcard
+	// for that host or one of its parent domains, as is a plain origin
+	scheme, host, _ := strings.Cut(pattern, "://")
+	host = strings.TrimPrefix(host, "*.")
+	for _, allowed := range k.AllowedOrigins {
+		allowedScheme, allowedHost, _ := strings.Cut(allowed, "://")
+		if suffix, ok := strings.CutPrefix(allowedHost, "*."); ok && allowedScheme == scheme && strings.HasSuffix(host, "."+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// coversCIDR reports whether the whole of cidr falls in one of the allowed ranges.
+func (k *KeyRestrictions) coversCIDR(cidr string) bool {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return false
+	}
+	ones, bits := network.Mask.Size()
+
+	for _, allowed := range k.AllowedCIDRs {
+		_, allowedNetwork, err := net.ParseCIDR(allowed)
+		if err != nil {
+			continue
+		}
+		allowedOnes, allowedBits := allowedNetwork.Mask.Size()
+		if allowedBits == bits && allowedOnes <= ones && allowedNetwork.Contains(network.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeOriginPattern(pattern string) (string, error) {
+	u, err := url.Parse(strings.ToLower(strings.TrimSpace(pattern)))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.Trim(u.Path, "/") != "" || u.RawQuery != "" {
+		return "", fmt.Errorf("invalid origin %q, expected e.g. https://example.com or https://*.example.com", pattern)
+	}
+
+	host := strings.TrimPrefix(u.Host, "*.")
+	if strings.Contains(host, "*") || host == "" {
+		return "", fmt.Errorf("invalid origin %q, wildcards are only allowed as a leading *.", pattern)
+	}
+
+	return u.Scheme + "://" + u.Host, nil
+}
+
+// AllowsOrigin reports whether a request with the given Origin, or failing that
+// Referer, header may use the key. Keys with allowed origins can't be used by
+// requests that send neither.
+func (k *KeyRestrictions) AllowsOrigin(origin, referer string) bool {
+	if len(k.AllowedOrigins) == 0 {
+		return true
+	}
+
+	if origin == "" || origin == "null" {
+		origin = referer
+	}
+	u, err := url.Parse(strings.ToLower(origin))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return false
+	}
+
+	for _, pattern := range k.AllowedOrigins {
+		scheme, host, _ := strings.Cut(pattern, "://")
+		if scheme != u.Scheme {
+			continue
+		}
+		if suffix, ok := strings.CutPrefix(host, "*."); ok {
+			if strings.HasSuffix(u.Host, "."+suffix) {
+				return true
+			}
+		} else if host == u.Host {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsIP reports whether a request from ip may use the key.
+func (k *KeyRestrictions) AllowsIP(ip net.IP) bool {
+	if len(k.AllowedCIDRs) == 0 {
+		return true
+	}
+	if ip == nil {
+		return false
+	}
+
+	for _, cidr := range k.AllowedCIDRs {
+		if _, network, err := net.ParseCIDR(cidr); err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,26 @@
+package models
+
+import "slices"
+
+const (
+	ScopeWeatherRead     = "weather:read"
+	ScopeUserRead        = "user:read"
+	ScopeUserWrite       = "user:write"
+	ScopeLocationsWrite  = "locations:write"
+	ScopeAlertsSubscribe = "alerts:subscribe"
+)
+
+// AllScopes is what keys get unless they are created with a narrower set.
+var AllScopes = []string{
+	ScopeWeatherRead,
+	ScopeUserRead,
+	ScopeUserWrite,
+	ScopeLocationsWrite,
+	ScopeAlertsSubscribe,
+}
+
+// HasScope reports whether the credential grants scope. Credentials from before
+// scopes were introduced have none recorded and keep full access.
+func (a *ApiCredential) HasScope(scope string) bool {
+	return len(a.Scopes) == 0 || slices.Contains(a.Scopes, scope)
+}
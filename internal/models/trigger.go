@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+type TriggerRule struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	CredentialID string    `gorm:"not null;index" json:"-"`
+	GithubUserID int64     `gorm:"not null;index" json:"github_user_id"`
+	Name         string    `json:"name"`
+	LocationName string    `json:"location_name"`
+	Lat          float64   `gorm:"not null" json:"lat"`
+	Lon          float64   `gorm:"not null" json:"lon"`
+	Units        string    `gorm:"default:metric" json:"units"`
+	Expression   string    `gorm:"not null" json:"expression"`
+	WindowHours  int       `gorm:"default:24" json:"window_hours"`
+	QuietStart   string    `json:"quiet_start,omitempty"`
+	QuietEnd     string    `json:"quiet_end,omitempty"`
+	WebhookURL   string    `json:"webhook_url,omitempty"`
+	Secret       string    `json:"-"`
+	Enabled      bool      `gorm:"default:true" json:"enabled"`
+	CreatedAt    time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+type TriggerFiring struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	RuleID    uint      `gorm:"not null;uniqueIndex:idx_trigger_firing_dedup" json:"rule_id"`
+	DedupKey  string    `gorm:"not null;uniqueIndex:idx_trigger_firing_dedup" json:"-"`
+	MatchedAt int64     `json:"matched_at"`
+	Summary   string    `json:"summary"`
+	Delivered bool      `json:"delivered"`
+	Error     string    `json:"error,omitempty"`
+	FiredAt   time.Time `gorm:"autoCreateTime" json:"fired_at"`
+}
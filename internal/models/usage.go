@@ -0,0 +1,22 @@
+package models
+
+// UsageRecord counts the requests a key made to one endpoint on one day, so usage
+// history survives the daily reset.
+type UsageRecord struct {
+	ID           uint   `gorm:"primaryKey" json:"-"`
+	CredentialID string `gorm:"not null;uniqueIndex:idx_usage_records_key" json:"-"`
+	GithubUserID int64  `gorm:"not null;index" json:"-"`
+	// UTC date, YYYY-MM-DD
+	Day string `gorm:"not null;uniqueIndex:idx_usage_records_key;index" json:"day"`
+	// route template rather than the request path, e.g. /api/weather/{city}
+	Endpoint string `gorm:"not null;uniqueIndex:idx_usage_records_key" json:"endpoint"`
+	Count    int    `gorm:"not null;default:0" json:"count"`
+}
+
+// EndpointUsage is the total use of one endpoint across all keys.
+type EndpointUsage struct {
+	Endpoint string `json:"endpoint"`
+	Requests int    `json:"requests"`
+	Keys     int    `json:"keys"`
+	Users    int    `json:"users"`
+}
@@ -6,9 +6,19 @@ import (
 	"gorm.io/gorm"
 )
 
+// ProviderGitHub is the identity provider GitHub users sign in with.
+const ProviderGitHub = "github"
+
+// User is an account, linked to the identity provider the user signs in with by
+// Provider and Subject. GithubID is the account's ID, which everything else
+// belongs to: the GitHub user ID for GitHub accounts, and negative for accounts
+// from other providers so the two never clash.
 type User struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	GithubID  int64     `gorm:"unique;not null" json:"github_id"`
+	ID       uint   `gorm:"primaryKey" json:"id"`
+	GithubID int64  `gorm:"unique;not null" json:"github_id"`
+	Provider string `gorm:"not null;default:github;uniqueIndex:idx_users_identity" json:"provider"`
+	// the provider's ID for the user, which for GitHub is their user ID
+	Subject   string    `gorm:"uniqueIndex:idx_users_identity" json:"subject"`
 	Login     string    `gorm:"not null" json:"login"`
 	Name      *string   `json:"name,omitempty"`
 	Email     *string   `json:"email,omitempty"`
@@ -17,17 +27,35 @@ type User struct {
 	LastLogin time.Time `gorm:"autoUpdateTime" json:"last_login"`
 }
 
+// AccountSequence hands out the account IDs of users from providers other than
+// GitHub, counting down from -1. Next is the ID the next new account gets.
+type AccountSequence struct {
+	Name string `gorm:"primaryKey"`
+	Next int64  `gorm:"not null"`
+}
+
 type ApiCredential struct {
 	ID                string     `gorm:"primaryKey" json:"id"`
-	GithubUserID      int64      `gorm:"not null;unique;index" json:"github_user_id"`
-	ApiKey            string     `gorm:"unique;not null" json:"api_key"`
+	GithubUserID      int64      `gorm:"not null;index;uniqueIndex:idx_api_credentials_user_name" json:"github_user_id"`
+	Name              string     `gorm:"not null;default:default;uniqueIndex:idx_api_credentials_user_name" json:"name"`
+	KeyHash           string     `gorm:"uniqueIndex" json:"-"`
+	KeyPrefix         string     `json:"key_prefix"`
+	ApiKey            string     `gorm:"-" json:"api_key,omitempty"` // plaintext, only set when a key is issued
+	PreviousKeyHash   string     `gorm:"index" json:"-"`
+	PreviousExpiresAt *time.Time `json:"previous_key_expires_at,omitempty"`
+	RevokedAt         *time.Time `json:"revoked_at,omitempty"`
+	ExpiresAt         *time.Time `json:"expires_at,omitempty"`
+	Scopes            []string   `gorm:"serializer:json" json:"scopes"`
+	KeyRestrictions   `gorm:"embedded"`
 	LastUsed          *time.Time `json:"last_used,omitempty"`
+	IdleFlaggedAt     *time.Time `json:"idle_flagged_at,omitempty"`
 	CreatedAt         time.Time  `gorm:"autoCreateTime" json:"created_at"`
 	RequestCount      int        `gorm:"default:0" json:"request_count"`
 	User              User       `gorm:"foreignKey:GithubUserID;references:GithubID" json:"-"`
-	DailyRequestCount int        `gorm:"default:0"`
-	RateLimitPerDay   int        `gorm:"default:40"`
-	DailyResetAt      time.Time
+	DailyRequestCount int        `gorm:"default:0" json:"daily_request_count"`
+	PlanName          string     `gorm:"not null;default:free;index" json:"plan"`
+	Plan              Plan       `gorm:"foreignKey:PlanName;references:Name" json:"-"`
+	DailyResetAt      time.Time  `json:"daily_reset_at"`
 }
 
 func (a *ApiCredential) BeforeCreate(tx *gorm.DB) error {
@@ -1,5 +1,7 @@
 package models
 
+import "slices"
+
 type City struct {
 	Name    string  `json:"name"`
 	Lat     float64 `json:"lat"`
@@ -116,6 +118,26 @@ type Alert struct {
 	Tags        []string `json:"tags"`
 }
 
+type WeatherAlert struct {
+	ID          string   `json:"id"`
+	Event       string   `json:"event"`
+	SenderName  string   `json:"sender_name"`
+	Severity    string   `json:"severity"`
+	Category    string   `json:"category"`
+	Start       string   `json:"start"`
+	End         string   `json:"end,omitempty"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+}
+
+type AlertsResponse struct {
+	City     *City          `json:"city,omitempty"`
+	Lat      float64        `json:"lat"`
+	Lon      float64        `json:"lon"`
+	Timezone string         `json:"timezone"`
+	Alerts   []WeatherAlert `json:"alerts"`
+}
+
 type OneCallResponse struct {
 	Lat            float64        `json:"lat"`
 	Lon            float64        `json:"lon"`
@@ -128,7 +150,61 @@ type OneCallResponse struct {
 	Alerts         []Alert        `json:"alerts"`
 }
 
+// Clone returns a deep copy of the forecast, so a shared forecast can be changed
+// without affecting anyone else holding it.
+func (o *OneCallResponse) Clone() *OneCallResponse {
+	clone := *o
+	clone.Current = o.Current.clone()
+	clone.Minutely = slices.Clone(o.Minutely)
+
+	if o.Hourly != nil {
+		clone.Hourly = make([]HourData, len(o.Hourly))
+		for i, hour := range o.Hourly {
+			clone.Hourly[i] = hour.clone()
+		}
+	}
+	if o.Daily != nil {
+		clone.Daily = make([]DayData, len(o.Daily))
+		for i, day := range o.Daily {
+			day.Weather = slices.Clone(day.Weather)
+			clone.Daily[i] = day
+		}
+	}
+	if o.Alerts != nil {
+		clone.Alerts = make([]Alert, len(o.Alerts))
+		for i, alert := range o.Alerts {
+			alert.Tags = slices.Clone(alert.Tags)
+			clone.Alerts[i] = alert
+		}
+	}
+
+	return &clone
+}
+
+func (c CurrentWeather) clone() CurrentWeather {
+	c.Rain = clonePtr(c.Rain)
+	c.Snow = clonePtr(c.Snow)
+	c.Weather = slices.Clone(c.Weather)
+	return c
+}
+
+func (h HourData) clone() HourData {
+	h.Rain = clonePtr(h.Rain)
+	h.Snow = clonePtr(h.Snow)
+	h.Weather = slices.Clone(h.Weather)
+	return h
+}
+
+func clonePtr[T any](p *T) *T {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
+
 type WeatherResponse struct {
-	City    *City            `json:"city"`
-	Weather *OneCallResponse `json:"weather"`
+	City      *City            `json:"city"`
+	Weather   *OneCallResponse `json:"weather"`
+	Astronomy *Astronomy       `json:"astronomy,omitempty"`
 }
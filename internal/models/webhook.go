@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+type WebhookSubscription struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	GithubUserID int64     `gorm:"not null;index" json:"github_user_id"`
+	Name         string    `json:"name"`
+	Lat          float64   `gorm:"not null" json:"lat"`
+	Lon          float64   `gorm:"not null" json:"lon"`
+	URL          string    `gorm:"not null" json:"url"`
+	Secret       string    `gorm:"not null" json:"-"`
+	CreatedAt    time.Time `gorm:"autoCreateTime" json:"created_at"`
+	User         User      `gorm:"foreignKey:GithubUserID;references:GithubID" json:"-"`
+}
+
+type WebhookDelivery struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	SubscriptionID uint      `gorm:"not null;index:idx_webhook_delivery_alert" json:"subscription_id"`
+	AlertID        string    `gorm:"not null;index:idx_webhook_delivery_alert" json:"alert_id"`
+	Event          string    `json:"event"`
+	Attempt        int       `json:"attempt"`
+	StatusCode     int       `json:"status_code"`
+	Success        bool      `json:"success"`
+	Error          string    `json:"error,omitempty"`
+	CreatedAt      time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
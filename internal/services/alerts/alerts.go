@@ -0,0 +1,129 @@
+package alerts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/josephburgess/breeze/internal/models"
+)
+
+const (
+	SeverityExtreme  = "extreme"
+	SeveritySevere   = "severe"
+	SeverityModerate = "moderate"
+	SeverityMinor    = "minor"
+	SeverityUnknown  = "unknown"
+)
+
+type rule struct {
+	keywords []string
+	value    string
+}
+
+// severity rules are checked in order; colour levels used by european agencies win
+// over the product type (warning/watch/advisory) used by the NWS.
+var severityRules = []rule{
+	{[]string{"red flag"}, SeveritySevere},
+	{[]string{"extreme", "emergency", "red"}, SeverityExtreme},
+	{[]string{"amber", "orange"}, SeveritySevere},
+	{[]string{"yellow"}, SeverityModerate},
+	{[]string{"warning*"}, SeveritySevere},
+	{[]string{"watch*"}, SeverityModerate},
+	{[]string{"advisory", "advisories", "statement*", "minor", "green"}, SeverityMinor},
+	{[]string{"severe"}, SeveritySevere},
+	{[]string{"moderate"}, SeverityModerate},
+}
+
+var categoryRules = []rule{
+	{[]string{"tornado*"}, "tornado"},
+	{[]string{"thunder*", "lightning"}, "thunderstorm"},
+	{[]string{"red flag", "fire*", "wildfire*"}, "fire"},
+	{[]string{"snow*", "ice", "icy", "blizzard*", "frost*", "freez*", "winter*"}, "snow_ice"},
+	{[]string{"flood*", "rain*"}, "flood"},
+	{[]string{"tsunami*", "coastal", "surf", "tide*", "marine", "rip current*"}, "coastal"},
+	{[]string{"wind*", "gale*", "hurricane*", "typhoon*", "cyclone*", "storm*"}, "wind"},
+	{[]string{"heat*", "high temperature*"}, "heat"},
+	{[]string{"cold", "chill", "low temperature*"}, "cold"},
+	{[]string{"fog*", "visibility"}, "fog"},
+	{[]string{"air quality", "smoke", "dust*"}, "air_quality"},
+}
+
+// Normalize converts upstream alerts into a consistent shape, dropping any that have
+// already ended. Times are rendered in loc.
+func Normalize(upstream []models.Alert, loc *time.Location, now time.Time) []models.WeatherAlert {
+	normalized := make([]models.WeatherAlert, 0, len(upstream))
+
+	for _, alert := range upstream {
+		if alert.End != 0 && alert.End <= now.Unix() {
+			continue
+		}
+
+		text := strings.ToLower(alert.Event + " " + strings.Join(alert.Tags, " "))
+
+		normalized = append(normalized, models.WeatherAlert{
+			ID:          ID(alert),
+			Event:       alert.Event,
+			SenderName:  alert.SenderName,
+			Severity:    match(severityRules, text, SeverityUnknown),
+			Category:    match(categoryRules, text, "other"),
+			Start:       formatTime(alert.Start, loc),
+			End:         formatTime(alert.End, loc),
+			Description: alert.Description,
+			Tags:        alert.Tags,
+		})
+	}
+
+	return normalized
+}
+
+// ID returns a stable identifier for an alert. The description is left out because
+// agencies often amend the text of an alert that is still in force.
+func ID(alert models.Alert) string {
+	sum := sha256.Sum256(fmt.Appendf(nil, "%s|%s|%d|%d", alert.SenderName, alert.Event, alert.Start, alert.End))
+	return hex.EncodeToString(sum[:8])
+}
+
+func match(rules []rule, text, fallback string) string {
+	for _, r := range rules {
+		for _, keyword := range r.keywords {
+			if containsWord(text, keyword) {
+				return r.value
+			}
+		}
+	}
+	return fallback
+}
+
+// containsWord matches keyword on word boundaries. A trailing * matches any word
+// ending, so "flood*" matches "flooding" but "red" doesn't match "reduced".
+func containsWord(text, keyword string) bool {
+	prefix := strings.HasSuffix(keyword, "*")
+	keyword = strings.TrimSuffix(keyword, "*")
+
+	for i := 0; ; {
+		idx := strings.Index(text[i:], keyword)
+		if idx < 0 {
+			return false
+		}
+		start := i + idx
+		end := start + len(keyword)
+		if (start == 0 || !isLetter(text[start-1])) && (prefix || end == len(text) || !isLetter(text[end])) {
+			return true
+		}
+		i = start + 1
+	}
+}
+
+func isLetter(b byte) bool {
+	return b >= 'a' && b <= 'z'
+}
+
+func formatTime(unix int64, loc *time.Location) string {
+	if unix == 0 {
+		return ""
+	}
+	return time.Unix(unix, 0).In(loc).Format(time.RFC3339)
+}
@@ -0,0 +1,90 @@
+package alerts_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/josephburgess/breeze/internal/models"
+	"github.com/josephburgess/breeze/internal/services/alerts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalize(t *testing.T) {
+	now := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+	loc, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	upstream := []models.Alert{
+		{
+			SenderName: "NWS Tulsa",
+			Event:      "Severe Thunderstorm Watch",
+			Start:      now.Add(-time.Hour).Unix(),
+			End:        now.Add(3 * time.Hour).Unix(),
+			Tags:       []string{"Thunderstorm"},
+		},
+		{
+			SenderName: "Met Office",
+			Event:      "Yellow warning",
+			Start:      now.Unix(),
+			End:        now.Add(time.Hour).Unix(),
+			Tags:       []string{"Rain"},
+		},
+		{
+			SenderName: "NWS Boise",
+			Event:      "Red Flag Warning",
+			Start:      now.Unix(),
+			End:        now.Add(time.Hour).Unix(),
+			Tags:       []string{"Fire warning"},
+		},
+		{
+			SenderName: "NWS Denver",
+			Event:      "Dense Fog Advisory",
+			Start:      now.Unix(),
+			Tags:       []string{"Fog"},
+		},
+		{
+			SenderName: "Expired",
+			Event:      "Heat Warning",
+			Start:      now.Add(-5 * time.Hour).Unix(),
+			End:        now.Add(-time.Hour).Unix(),
+		},
+	}
+
+	normalized := alerts.Normalize(upstream, loc, now)
+	require.Len(t, normalized, 4)
+
+	assert.Equal(t, alerts.SeverityModerate, normalized[0].Severity)
+	assert.Equal(t, "thunderstorm", normalized[0].Category)
+	assert.Equal(t, "2024-07-01T07:00:00-04:00", normalized[0].Start)
+	assert.Equal(t, "2024-07-01T11:00:00-04:00", normalized[0].End)
+
+	assert.Equal(t, alerts.SeverityModerate, normalized[1].Severity)
+	assert.Equal(t, "flood", normalized[1].Category)
+
+	assert.Equal(t, alerts.SeveritySevere, normalized[2].Severity)
+	assert.Equal(t, "fire", normalized[2].Category)
+
+	assert.Equal(t, alerts.SeverityMinor, normalized[3].Severity)
+	assert.Equal(t, "fog", normalized[3].Category)
+	assert.Empty(t, normalized[3].End)
+}
+
+func TestNormalize_UnknownSeverity(t *testing.T) {
+	normalized := alerts.Normalize([]models.Alert{{Event: "Reduced visibility notice"}}, time.UTC, time.Now())
+	require.Len(t, normalized, 1)
+	assert.Equal(t, alerts.SeverityUnknown, normalized[0].Severity)
+	assert.Equal(t, "fog", normalized[0].Category)
+}
+
+func TestID(t *testing.T) {
+	alert := models.Alert{SenderName: "NWS", Event: "Flood Warning", Start: 100, End: 200, Description: "v1"}
+	amended := alert
+	amended.Description = "v2"
+	other := alert
+	other.End = 300
+
+	assert.Equal(t, alerts.ID(alert), alerts.ID(amended))
+	assert.NotEqual(t, alerts.ID(alert), alerts.ID(other))
+	assert.Len(t, alerts.ID(alert), 16)
+}
@@ -0,0 +1,255 @@
+package astronomy
+
+import (
+	"math"
+	"time"
+
+	"github.com/josephburgess/breeze/internal/models"
+)
+
+// sun elevations (degrees) that mark the start/end of each period
+const (
+	sunriseElevation      = -0.833
+	civilElevation        = -6.0
+	nauticalElevation     = -12.0
+	astronomicalElevation = -18.0
+	blueHourElevation     = -4.0
+	goldenHourElevation   = 6.0
+
+	synodicMonth = 29.530588853
+	// julian date of a known new moon (2000-01-06 18:14 UTC)
+	knownNewMoon = 2451550.1
+)
+
+// Calculate computes sun and moon events for the calendar day of date at the given
+// coordinates. Times are unix seconds; events that don't happen on that day (polar
+// day/night) are returned as 0.
+func Calculate(lat, lon float64, date time.Time) *models.Astronomy {
+	day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+
+	noon := solarNoon(day, lon)
+
+	result := &models.Astronomy{
+		Date:      day.Format("2006-01-02"),
+		Lat:       lat,
+		Lon:       lon,
+		SolarNoon: noon.Unix(),
+	}
+
+	result.Sunrise, result.Sunset = eventPair(noon, lat, sunriseElevation)
+	result.CivilTwilight.Dawn, result.CivilTwilight.Dusk = eventPair(noon, lat, civilElevation)
+	result.NauticalTwilight.Dawn, result.NauticalTwilight.Dusk = eventPair(noon, lat, nauticalElevation)
+	result.AstronomicalTwilight.Dawn, result.AstronomicalTwilight.Dusk = eventPair(noon, lat, astronomicalElevation)
+
+	blueStart, blueEnd := eventPair(noon, lat, blueHourElevation)
+	goldenStart, goldenEnd := eventPair(noon, lat, goldenHourElevation)
+
+	result.BlueHour.Morning = models.TimeRange{Start: result.CivilTwilight.Dawn, End: blueStart}
+	result.BlueHour.Evening = models.TimeRange{Start: blueEnd, End: result.CivilTwilight.Dusk}
+	result.GoldenHour.Morning = models.TimeRange{Start: blueStart, End: goldenStart}
+	result.GoldenHour.Evening = models.TimeRange{Start: goldenEnd, End: blueEnd}
+
+	result.DayLength = dayLength(noon, lat, result.Sunrise, result.Sunset)
+
+	result.MoonPhase = MoonPhase(noon)
+	result.MoonIllumination = round((1-math.Cos(2*math.Pi*result.MoonPhase))/2, 3)
+	result.MoonPhaseName = moonPhaseName(result.MoonPhase)
+
+	return result
+}
+
+// Merge fills in sun and moon fields the upstream provider left empty.
+func Merge(weather *models.OneCallResponse) {
+	if weather == nil {
+		return
+	}
+
+	if weather.Current.Sunrise == 0 && weather.Current.Sunset == 0 {
+		ref := time.Now().UTC()
+		if weather.Current.Dt != 0 {
+			ref = time.Unix(weather.Current.Dt, 0).UTC()
+		}
+		today := Calculate(weather.Lat, weather.Lon, localDate(ref, weather.Lon))
+		weather.Current.Sunrise = today.Sunrise
+		weather.Current.Sunset = today.Sunset
+	}
+
+	for i := range weather.Daily {
+		day := &weather.Daily[i]
+		if day.Dt == 0 {
+			continue
+		}
+
+		missingSun := day.Sunrise == 0 && day.Sunset == 0
+		missingMoon := day.Moonrise == 0 && day.Moonset == 0 && day.MoonPhase == 0
+		if !missingSun && !missingMoon {
+			continue
+		}
+
+		calc := Calculate(weather.Lat, weather.Lon, localDate(time.Unix(day.Dt, 0).UTC(), weather.Lon))
+		if missingSun {
+			day.Sunrise = calc.Sunrise
+			day.Sunset = calc.Sunset
+		}
+		if missingMoon {
+			day.MoonPhase = round(calc.MoonPhase, 2)
+		}
+	}
+}
+
+// MoonPhase returns the lunar phase at t in the same 0-1 form OpenWeather uses:
+// 0 and 1 are new moon, 0.5 is full moon.
+func MoonPhase(t time.Time) float64 {
+	age := (julianDay(t) - knownNewMoon) / synodicMonth
+	phase := age - math.Floor(age)
+	return round(phase, 4)
+}
+
+// localDate approximates the calendar date at lon from its solar time offset.
+func localDate(t time.Time, lon float64) time.Time {
+	return t.Add(time.Duration(lon / 15 * float64(time.Hour)))
+}
+
+func eventPair(noon time.Time, lat, elevation float64) (int64, int64) {
+	rise, ok := eventTime(noon, lat, elevation, true)
+	if !ok {
+		return 0, 0
+	}
+	set, ok := eventTime(noon, lat, elevation, false)
+	if !ok {
+		return 0, 0
+	}
+	return rise.Unix(), set.Unix()
+}
+
+// eventTime finds when the sun crosses elevation before (rising) or after solar
+// noon. The estimate is refined once with the sun's position at the first guess.
+func eventTime(noon time.Time, lat, elevation float64, rising bool) (time.Time, bool) {
+	guess := noon
+	for range 2 {
+		decl, _ := sunPosition(julianDay(guess))
+		ha, ok := hourAngle(lat, decl, elevation)
+		if !ok {
+			return time.Time{}, false
+		}
+		offset := time.Duration(ha * 4 * float64(time.Minute))
+		if rising {
+			offset = -offset
+		}
+		guess = noon.Add(offset)
+	}
+	return guess.Truncate(time.Second), true
+}
+
+func dayLength(noon time.Time, lat float64, sunrise, sunset int64) int64 {
+	if sunrise != 0 && sunset != 0 {
+		return sunset - sunrise
+	}
+	decl, _ := sunPosition(julianDay(noon))
+	cosHA := hourAngleCosine(lat, decl, sunriseElevation)
+	if cosHA < -1 {
+		return 24 * 60 * 60
+	}
+	return 0
+}
+
+// solarNoon returns the UTC time of solar noon on the UTC calendar day of t.
+func solarNoon(t time.Time, lon float64) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+
+	// first pass with the equation of time at noon UTC, second at the estimate
+	estimate := day.Add(12 * time.Hour)
+	for range 2 {
+		_, eqTime := sunPosition(julianDay(estimate))
+		minutes := 720 - 4*lon - eqTime
+		estimate = day.Add(time.Duration(minutes * float64(time.Minute)))
+	}
+	return estimate.Truncate(time.Second)
+}
+
+func hourAngle(lat, decl, elevation float64) (float64, bool) {
+	cosHA := hourAngleCosine(lat, decl, elevation)
+	if cosHA < -1 || cosHA > 1 {
+		return 0, false
+	}
+	return degrees(math.Acos(cosHA)), true
+}
+
+func hourAngleCosine(lat, decl, elevation float64) float64 {
+	latRad := radians(lat)
+	declRad := radians(decl)
+	return (math.Sin(radians(elevation)) - math.Sin(latRad)*math.Sin(declRad)) /
+		(math.Cos(latRad) * math.Cos(declRad))
+}
+
+// sunPosition returns the solar declination (degrees) and the equation of time
+// (minutes) using the NOAA solar calculator approximations.
+func sunPosition(jd float64) (float64, float64) {
+	jc := (jd - 2451545) / 36525
+
+	meanLong := math.Mod(280.46646+jc*(36000.76983+jc*0.0003032), 360)
+	meanAnom := 357.52911 + jc*(35999.05029-0.0001537*jc)
+	eccent := 0.016708634 - jc*(0.000042037+0.0000001267*jc)
+
+	eqCenter := math.Sin(radians(meanAnom))*(1.914602-jc*(0.004817+0.000014*jc)) +
+		math.Sin(radians(2*meanAnom))*(0.019993-0.000101*jc) +
+		math.Sin(radians(3*meanAnom))*0.000289
+
+	trueLong := meanLong + eqCenter
+	omega := 125.04 - 1934.136*jc
+	appLong := trueLong - 0.00569 - 0.00478*math.Sin(radians(omega))
+
+	meanObliq := 23 + (26+(21.448-jc*(46.815+jc*(0.00059-jc*0.001813)))/60)/60
+	obliq := meanObliq + 0.00256*math.Cos(radians(omega))
+
+	decl := degrees(math.Asin(math.Sin(radians(obliq)) * math.Sin(radians(appLong))))
+
+	y := math.Pow(math.Tan(radians(obliq/2)), 2)
+	l0 := radians(meanLong)
+	m := radians(meanAnom)
+	eqTime := 4 * degrees(y*math.Sin(2*l0)-
+		2*eccent*math.Sin(m)+
+		4*eccent*y*math.Sin(m)*math.Cos(2*l0)-
+		0.5*y*y*math.Sin(4*l0)-
+		1.25*eccent*eccent*math.Sin(2*m))
+
+	return decl, eqTime
+}
+
+func moonPhaseName(phase float64) string {
+	switch {
+	case phase < 0.0339 || phase >= 0.9661:
+		return "new moon"
+	case phase < 0.2161:
+		return "waxing crescent"
+	case phase < 0.2839:
+		return "first quarter"
+	case phase < 0.4661:
+		return "waxing gibbous"
+	case phase < 0.5339:
+		return "full moon"
+	case phase < 0.7161:
+		return "waning gibbous"
+	case phase < 0.7839:
+		return "last quarter"
+	default:
+		return "waning crescent"
+	}
+}
+
+func julianDay(t time.Time) float64 {
+	return float64(t.UTC().Unix())/86400 + 2440587.5
+}
+
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+func degrees(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
+
+func round(v float64, places int) float64 {
+	p := math.Pow(10, float64(places))
+	return math.Round(v*p) / p
+}
@@ -0,0 +1,112 @@
+package astronomy_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/josephburgess/breeze/internal/models"
+	"github.com/josephburgess/breeze/internal/services/astronomy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func assertNear(t *testing.T, expected time.Time, actual int64, tolerance time.Duration) {
+	t.Helper()
+	diff := time.Unix(actual, 0).Sub(expected)
+	assert.LessOrEqual(t, diff.Abs(), tolerance, "expected %s, got %s", expected, time.Unix(actual, 0).UTC())
+}
+
+func TestCalculate_LondonSummerSolstice(t *testing.T) {
+	result := astronomy.Calculate(51.5074, -0.1278, time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC))
+
+	assert.Equal(t, "2024-06-21", result.Date)
+	assertNear(t, time.Date(2024, 6, 21, 3, 43, 0, 0, time.UTC), result.Sunrise, 3*time.Minute)
+	assertNear(t, time.Date(2024, 6, 21, 20, 21, 0, 0, time.UTC), result.Sunset, 3*time.Minute)
+	assertNear(t, time.Date(2024, 6, 21, 12, 2, 0, 0, time.UTC), result.SolarNoon, 2*time.Minute)
+	assertNear(t, time.Date(2024, 6, 21, 2, 56, 0, 0, time.UTC), result.CivilTwilight.Dawn, 4*time.Minute)
+	assertNear(t, time.Date(2024, 6, 21, 21, 7, 0, 0, time.UTC), result.CivilTwilight.Dusk, 4*time.Minute)
+
+	// the sun never gets below -18° in London in June
+	assert.Zero(t, result.AstronomicalTwilight.Dawn)
+	assert.Zero(t, result.AstronomicalTwilight.Dusk)
+
+	assert.InDelta(t, 16*3600+38*60, result.DayLength, 300)
+
+	assert.Less(t, result.CivilTwilight.Dawn, result.BlueHour.Morning.End)
+	assert.Equal(t, result.BlueHour.Morning.End, result.GoldenHour.Morning.Start)
+	assert.Less(t, result.GoldenHour.Morning.Start, result.Sunrise)
+	assert.Greater(t, result.GoldenHour.Morning.End, result.Sunrise)
+	assert.Less(t, result.GoldenHour.Evening.Start, result.Sunset)
+	assert.Equal(t, result.GoldenHour.Evening.End, result.BlueHour.Evening.Start)
+}
+
+func TestCalculate_EasternLongitude(t *testing.T) {
+	// sydney sunrise happens on the previous UTC day
+	result := astronomy.Calculate(-33.8688, 151.2093, time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC))
+
+	assertNear(t, time.Date(2024, 1, 14, 19, 0, 0, 0, time.UTC), result.Sunrise, 5*time.Minute)
+	assertNear(t, time.Date(2024, 1, 15, 9, 8, 0, 0, time.UTC), result.Sunset, 5*time.Minute)
+}
+
+func TestCalculate_PolarNight(t *testing.T) {
+	result := astronomy.Calculate(69.6492, 18.9553, time.Date(2024, 12, 21, 0, 0, 0, 0, time.UTC))
+
+	assert.Zero(t, result.Sunrise)
+	assert.Zero(t, result.Sunset)
+	assert.Zero(t, result.DayLength)
+	assert.NotZero(t, result.CivilTwilight.Dawn)
+	assert.NotZero(t, result.SolarNoon)
+}
+
+func TestCalculate_MidnightSun(t *testing.T) {
+	result := astronomy.Calculate(69.6492, 18.9553, time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC))
+
+	assert.Zero(t, result.Sunrise)
+	assert.Equal(t, int64(24*60*60), result.DayLength)
+}
+
+func TestMoonPhase(t *testing.T) {
+	newMoon := time.Date(2024, 1, 11, 11, 57, 0, 0, time.UTC)
+	fullMoon := time.Date(2024, 1, 25, 17, 54, 0, 0, time.UTC)
+
+	phase := astronomy.MoonPhase(newMoon)
+	assert.True(t, phase < 0.02 || phase > 0.98, "expected new moon, got phase %f", phase)
+	assert.InDelta(t, 0.5, astronomy.MoonPhase(fullMoon), 0.02)
+
+	result := astronomy.Calculate(51.5074, -0.1278, fullMoon)
+	assert.Equal(t, "full moon", result.MoonPhaseName)
+	assert.Greater(t, result.MoonIllumination, 0.98)
+}
+
+func TestMerge(t *testing.T) {
+	weather := &models.OneCallResponse{
+		Lat: 51.5074,
+		Lon: -0.1278,
+		Current: models.CurrentWeather{
+			Dt: time.Date(2024, 6, 21, 9, 0, 0, 0, time.UTC).Unix(),
+		},
+		Daily: []models.DayData{
+			{
+				Dt:        time.Date(2024, 6, 21, 12, 0, 0, 0, time.UTC).Unix(),
+				Sunrise:   111,
+				Sunset:    222,
+				Moonrise:  333,
+				MoonPhase: 0.5,
+			},
+			{
+				Dt: time.Date(2024, 6, 22, 12, 0, 0, 0, time.UTC).Unix(),
+			},
+		},
+	}
+
+	astronomy.Merge(weather)
+
+	assertNear(t, time.Date(2024, 6, 21, 3, 43, 0, 0, time.UTC), weather.Current.Sunrise, 3*time.Minute)
+
+	require.Len(t, weather.Daily, 2)
+	assert.Equal(t, int64(111), weather.Daily[0].Sunrise, "upstream values are kept")
+	assert.Equal(t, 0.5, weather.Daily[0].MoonPhase)
+
+	assertNear(t, time.Date(2024, 6, 22, 3, 43, 0, 0, time.UTC), weather.Daily[1].Sunrise, 3*time.Minute)
+	assert.NotZero(t, weather.Daily[1].MoonPhase)
+}
@@ -4,30 +4,56 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/josephburgess/breeze/internal/models"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-func TestGitHubOAuth_GetAuthURL(t *testing.T) {
+func TestOAuth_GetAuthURL(t *testing.T) {
 	clientID := "test-client-id"
 	clientSecret := "test-client-secret"
 	redirectURI := "http://localhost:8080/callback"
 
-	githubOAuth := NewGitHubOAuth(clientID, clientSecret, redirectURI)
+	states := NewMemoryStateStore()
+	oauth := NewOAuth(redirectURI, states, NewGitHubOAuth(clientID, clientSecret))
 
-	url, state := githubOAuth.GetAuthURL()
+	url, state, err := oauth.GetAuthURL("", "", "")
+	require.NoError(t, err)
 
 	assert.Contains(t, url, "https://github.com/login/oauth/authorize")
 	assert.Contains(t, url, "client_id="+clientID)
 	assert.Contains(t, url, "redirect_uri=")
 	assert.Contains(t, url, "state="+state)
 	assert.Contains(t, url, "scope=user:email,public_repo")
-	assert.True(t, githubOAuth.States[state])
+	assert.Contains(t, url, "code_challenge_method=S256")
+
+	saved, err := states.TakeOAuthState(state)
+	require.NoError(t, err)
+	require.NotNil(t, saved)
+	assert.Equal(t, redirectURI, saved.RedirectURI)
+	assert.Equal(t, models.ProviderGitHub, saved.Provider)
+	assert.Contains(t, url, "code_challenge="+CodeChallengeS256(saved.CodeVerifier))
+
+	url, state, err = oauth.GetAuthURL(models.ProviderGitHub, "http://localhost:5000/callback", "")
+	require.NoError(t, err)
+	assert.Contains(t, url, "redirect_uri=http%3A%2F%2Flocalhost%3A5000%2Fcallback")
+	saved, err = oauth.GetState(state)
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:5000/callback", saved.RedirectURI)
+	assert.Equal(t, redirectURI, oauth.RedirectURI)
+
+	_, _, err = oauth.GetAuthURL("gitlab", "", "")
+	assert.ErrorIs(t, err, ErrUnknownProvider)
 }
 
-func TestGitHubOAuth_ExchangeCodeForToken(t *testing.T) {
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+func TestOAuth_SignIn(t *testing.T) {
+	states := NewMemoryStateStore()
+
+	var sentVerifier string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login/oauth/access_token", func(w http.ResponseWriter, r *http.Request) {
 		assert.Equal(t, http.MethodPost, r.Method)
 		assert.Equal(t, "application/x-www-form-urlencoded", r.Header.Get("Content-Type"))
 
@@ -36,21 +62,99 @@ func TestGitHubOAuth_ExchangeCodeForToken(t *testing.T) {
 		assert.Equal(t, "test-client-id", r.FormValue("client_id"))
 		assert.Equal(t, "test-client-secret", r.FormValue("client_secret"))
 		assert.Equal(t, "test-code", r.FormValue("code"))
-		assert.Equal(t, "http://localhost:8080/callback", r.FormValue("redirect_uri"))
+		assert.Equal(t, "http://localhost:5000/callback", r.FormValue("redirect_uri"))
+		sentVerifier = r.FormValue("code_verifier")
 
 		w.Header().Set("Content-Type", "application/x-www-form-urlencoded")
 		w.Write([]byte("access_token=test-access-token&token_type=bearer&scope=user"))
-	}))
+	})
+	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "token test-access-token", r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": 12345, "login": "testuser"}`))
+	})
+	server := httptest.NewServer(mux)
 	defer server.Close()
 
 	clientID := "test-client-id"
 	clientSecret := "test-client-secret"
 	redirectURI := "http://localhost:8080/callback"
 
-	githubOAuth := NewGitHubOAuth(clientID, clientSecret, redirectURI)
+	githubOAuth := NewGitHubOAuth(clientID, clientSecret)
+	githubOAuth.GitHubURL = server.URL
+	githubOAuth.APIURL = server.URL
+	oauth := NewOAuth(redirectURI, states, githubOAuth)
+
+	clientVerifier := NewCodeVerifier()
 
-	testState := "test-state"
-	githubOAuth.States[testState] = true
+	t.Run("matching verifier", func(t *testing.T) {
+		authURL, state, err := oauth.GetAuthURL("", "http://localhost:5000/callback", CodeChallengeS256(clientVerifier))
+		require.NoError(t, err)
+		assert.Contains(t, authURL, server.URL+"/login/oauth/authorize")
+
+		saved, err := states.GetOAuthState(state)
+		require.NoError(t, err)
+
+		user, err := oauth.SignIn("test-code", state, clientVerifier)
+		require.NoError(t, err)
+		assert.Equal(t, "test-access-token", user.Token)
+		assert.Equal(t, int64(12345), user.GithubID)
+		assert.Equal(t, models.ProviderGitHub, user.Provider)
+		assert.Equal(t, "12345", user.Subject)
+		assert.Equal(t, saved.CodeVerifier, sentVerifier, "GitHub gets breeze's own verifier")
+		assert.NotEqual(t, clientVerifier, sentVerifier)
+
+		_, err = oauth.SignIn("test-code", state, clientVerifier)
+		assert.ErrorIs(t, err, ErrInvalidState, "a code can only be exchanged once")
+	})
+
+	for name, verifier := range map[string]string{
+		"missing verifier": "",
+		"wrong verifier":   NewCodeVerifier(),
+		"the challenge":    CodeChallengeS256(clientVerifier),
+	} {
+		t.Run(name, func(t *testing.T) {
+			sentVerifier = ""
+			_, state, err := oauth.GetAuthURL("", "http://localhost:5000/callback", CodeChallengeS256(clientVerifier))
+			require.NoError(t, err)
+
+			_, err = oauth.SignIn("test-code", state, verifier)
+			assert.ErrorIs(t, err, ErrInvalidCodeVerifier)
+			assert.Empty(t, sentVerifier, "GitHub is never asked")
+
+			_, err = oauth.SignIn("test-code", state, clientVerifier)
+			assert.ErrorIs(t, err, ErrInvalidState, "a failed attempt uses up the state")
+		})
+	}
+
+	t.Run("verifier for a sign-in without a challenge", func(t *testing.T) {
+		_, state, err := oauth.GetAuthURL("", "http://localhost:5000/callback", "")
+		require.NoError(t, err)
+
+		_, err = oauth.SignIn("test-code", state, clientVerifier)
+		assert.ErrorIs(t, err, ErrInvalidCodeVerifier)
+	})
+}
+
+func TestOAuth_SignIn_InvalidState(t *testing.T) {
+	states := NewMemoryStateStore()
+	oauth := NewOAuth("", states, NewGitHubOAuth("client-id", "client-secret"))
+
+	_, err := oauth.SignIn("test-code", "unknown-state", "")
+	assert.ErrorIs(t, err, ErrInvalidState)
+
+	_, err = oauth.SignIn("test-code", "", "")
+	assert.ErrorIs(t, err, ErrInvalidState)
+
+	_, state, err := oauth.GetAuthURL("", "", "")
+	require.NoError(t, err)
+	oauth.now = func() time.Time { return time.Now().Add(DefaultStateTTL) }
+	_, err = oauth.SignIn("test-code", state, "")
+	assert.ErrorIs(t, err, ErrInvalidState, "expired")
+
+	oauth.now = time.Now
+	_, err = oauth.SignIn("test-code", state, "")
+	assert.ErrorIs(t, err, ErrInvalidState, "an expired state is used up too")
 }
 
 func TestGitHubOAuth_GetUserInfo(t *testing.T) {
@@ -67,25 +171,46 @@ func TestGitHubOAuth_GetUserInfo(t *testing.T) {
 			"email": "test@example.com"
 		}`))
 	}))
-	server.Close()
+	defer server.Close()
+
+	githubOAuth := NewGitHubOAuth("client-id", "client-secret")
+	githubOAuth.APIURL = server.URL
+
+	user, err := githubOAuth.GetUserInfo("test-token")
+	require.NoError(t, err)
+	assert.Equal(t, int64(12345), user.GithubID)
+	assert.Equal(t, "testuser", user.Login)
+	assert.Equal(t, "test-token", user.Token)
+}
+
+func TestPKCE(t *testing.T) {
+	// the example from RFC 7636 appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	assert.Equal(t, challenge, CodeChallengeS256(verifier))
+	assert.True(t, VerifyCodeChallenge(verifier, challenge))
+	assert.False(t, VerifyCodeChallenge(verifier, ""))
+	assert.False(t, VerifyCodeChallenge("too-short", CodeChallengeS256("too-short")))
+	assert.True(t, ValidCodeChallenge(challenge))
+	assert.False(t, ValidCodeChallenge(challenge+"="))
+	assert.True(t, ValidCodeVerifier(NewCodeVerifier()))
 }
 
-func TestNewGitHubOAuth(t *testing.T) {
-	clientID := "client-id"
-	clientSecret := "client-secret"
+func TestNewOAuth(t *testing.T) {
 	redirectURI := "http://custom-redirect.com/callback"
+	github := NewGitHubOAuth("client-id", "client-secret")
 
-	oauth := NewGitHubOAuth(clientID, clientSecret, redirectURI)
+	oauth := NewOAuth(redirectURI, NewMemoryStateStore(), github)
 
-	assert.Equal(t, clientID, oauth.ClientID)
-	assert.Equal(t, clientSecret, oauth.ClientSecret)
+	assert.Equal(t, "client-id", github.ClientID)
+	assert.Equal(t, "client-secret", github.ClientSecret)
 	assert.Equal(t, redirectURI, oauth.RedirectURI)
+	assert.Equal(t, []string{models.ProviderGitHub}, oauth.Providers())
 	assert.NotNil(t, oauth.States)
 
-	oauth = NewGitHubOAuth(clientID, clientSecret, "")
+	oauth = NewOAuth("", NewMemoryStateStore(), github)
 
-	assert.Equal(t, clientID, oauth.ClientID)
-	assert.Equal(t, clientSecret, oauth.ClientSecret)
 	assert.Equal(t, "http://localhost:8080/api/auth/callback", oauth.RedirectURI)
 	assert.NotNil(t, oauth.States)
 }
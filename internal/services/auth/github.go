@@ -6,63 +6,72 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 
-	"github.com/google/uuid"
 	"github.com/josephburgess/breeze/internal/logging"
 	"github.com/josephburgess/breeze/internal/models"
 )
 
+// GitHub's endpoints, which tests replace with a stub server
+const (
+	defaultGitHubURL    = "https://github.com"
+	defaultGitHubAPIURL = "https://api.github.com"
+)
+
+// GitHubOAuth signs users in with GitHub.
 type GitHubOAuth struct {
 	ClientID     string
 	ClientSecret string
-	RedirectURI  string
-	States       map[string]bool
+	// base URLs for sign-in pages and the REST API
+	GitHubURL string
+	APIURL    string
 }
 
-func NewGitHubOAuth(clientID, clientSecret, redirectURI string) *GitHubOAuth {
-	if redirectURI == "" {
-		redirectURI = "http://localhost:8080/api/auth/callback"
-	}
-
+func NewGitHubOAuth(clientID, clientSecret string) *GitHubOAuth {
 	return &GitHubOAuth{
 		ClientID:     clientID,
 		ClientSecret: clientSecret,
-		RedirectURI:  redirectURI,
-		States:       make(map[string]bool),
+		GitHubURL:    defaultGitHubURL,
+		APIURL:       defaultGitHubAPIURL,
 	}
 }
 
-func (g *GitHubOAuth) GetAuthURL() (string, string) {
-	state := uuid.New().String()
-	g.States[state] = true
+func (g *GitHubOAuth) Name() string {
+	return models.ProviderGitHub
+}
 
-	authURL := fmt.Sprintf(
-		"https://github.com/login/oauth/authorize?client_id=%s&redirect_uri=%s&state=%s&scope=user:email,public_repo",
+func (g *GitHubOAuth) AuthCodeURL(state *models.OAuthState) string {
+	return fmt.Sprintf(
+		"%s/login/oauth/authorize?client_id=%s&redirect_uri=%s&state=%s&scope=user:email,public_repo&code_challenge=%s&code_challenge_method=S256",
+		g.GitHubURL,
 		g.ClientID,
-		url.QueryEscape(g.RedirectURI),
-		state,
+		url.QueryEscape(state.RedirectURI),
+		state.State,
+		CodeChallengeS256(state.CodeVerifier),
 	)
-
-	return authURL, state
 }
 
-func (g *GitHubOAuth) ExchangeCodeForToken(code, state string) (string, error) {
-	if state != "" && !g.States[state] {
-		logging.Warn("Invalid state parameter received: %s", state)
-		return "", fmt.Errorf("invalid state parameter")
-	}
-
-	if state != "" {
-		delete(g.States, state)
+// Exchange trades the code GitHub returned for an access token, and looks up the
+// GitHub user it belongs to.
+func (g *GitHubOAuth) Exchange(code string, state *models.OAuthState) (*models.User, error) {
+	token, err := g.ExchangeCodeForToken(code, state.RedirectURI, state.CodeVerifier)
+	if err != nil {
+		return nil, err
 	}
+	return g.GetUserInfo(token)
+}
 
+// ExchangeCodeForToken trades the code GitHub returned for a sign-in for an access
+// token, repeating the sign-in's redirect URI and proving it holds the PKCE
+// verifier.
+func (g *GitHubOAuth) ExchangeCodeForToken(code, redirectURI, codeVerifier string) (string, error) {
 	logging.Info("Exchanging code for token with GitHub")
-	tokenURL := "https://github.com/login/oauth/access_token"
-	resp, err := http.PostForm(tokenURL, url.Values{
+	resp, err := http.PostForm(g.GitHubURL+"/login/oauth/access_token", url.Values{
 		"client_id":     {g.ClientID},
 		"client_secret": {g.ClientSecret},
 		"code":          {code},
-		"redirect_uri":  {g.RedirectURI},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {codeVerifier},
 	})
 	if err != nil {
 		logging.Error("Token exchange request failed", err)
@@ -96,7 +105,7 @@ func (g *GitHubOAuth) ExchangeCodeForToken(code, state string) (string, error) {
 }
 
 func (g *GitHubOAuth) GetUserInfo(token string) (*models.User, error) {
-	req, err := http.NewRequest("GET", "https://api.github.com/user", nil)
+	req, err := http.NewRequest("GET", g.APIURL+"/user", nil)
 	if err != nil {
 		logging.Error("Failed to create request for user info", err)
 		return nil, fmt.Errorf("failed to create request: %w", err)
@@ -132,6 +141,8 @@ func (g *GitHubOAuth) GetUserInfo(token string) (*models.User, error) {
 
 	user := &models.User{
 		GithubID: githubResponse.ID,
+		Provider: models.ProviderGitHub,
+		Subject:  strconv.FormatInt(githubResponse.ID, 10),
 		Login:    githubResponse.Login,
 		Name:     githubResponse.Name,
 		Email:    githubResponse.Email,
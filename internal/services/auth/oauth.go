@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/josephburgess/breeze/internal/logging"
+	"github.com/josephburgess/breeze/internal/models"
+)
+
+// ErrUnknownProvider is returned for sign-ins with a provider that isn't configured.
+var ErrUnknownProvider = errors.New("unknown identity provider")
+
+// Provider is an identity provider users can sign in with.
+type Provider interface {
+	// Name identifies the provider, and is stored with the users who sign in
+	// with it.
+	Name() string
+	// AuthCodeURL returns the URL that starts the sign-in with state at the
+	// provider.
+	AuthCodeURL(state *models.OAuthState) string
+	// Exchange trades the code the provider returned for a sign-in for the user
+	// who signed in. The user's Provider and Subject identify them, and GitHub
+	// users have their GithubID set too.
+	Exchange(code string, state *models.OAuthState) (*models.User, error)
+}
+
+// OAuth runs sign-ins with the configured identity providers, which all send users
+// back to RedirectURI unless a sign-in asks otherwise.
+type OAuth struct {
+	RedirectURI string
+	States      StateStore
+	StateTTL    time.Duration
+
+	providers []Provider
+	now       func() time.Time
+}
+
+// NewOAuth returns an OAuth signing users in with providers, the first of which is
+// used when a sign-in doesn't name one.
+func NewOAuth(redirectURI string, states StateStore, providers ...Provider) *OAuth {
+	if redirectURI == "" {
+		redirectURI = "http://localhost:8080/api/auth/callback"
+	}
+
+	return &OAuth{
+		RedirectURI: redirectURI,
+		States:      states,
+		StateTTL:    DefaultStateTTL,
+		providers:   providers,
+		now:         time.Now,
+	}
+}
+
+// Providers returns the names of the configured providers, the default first.
+func (o *OAuth) Providers() []string {
+	names := make([]string, len(o.providers))
+	for i, provider := range o.providers {
+		names[i] = provider.Name()
+	}
+	return names
+}
+
+// provider returns the provider called name, or the default for an empty name.
+func (o *OAuth) provider(name string) (Provider, error) {
+	for _, provider := range o.providers {
+		if name == "" || provider.Name() == name {
+			return provider, nil
+		}
+	}
+	return nil, ErrUnknownProvider
+}
+
+// GetAuthURL starts a sign-in with the named provider, returning the URL to send the
+// user to and the sign-in's state. The provider sends the user back to redirectURI,
+// or RedirectURI if it is empty. The state remembers the redirect URI, nonce and the
+// PKCE verifier needed to exchange the code the provider returns, so concurrent
+// sign-ins don't share any of them. codeChallenge is the client's own S256 PKCE
+// challenge, if it exchanges the code itself, which the exchange must then prove it
+// holds the verifier for.
+func (o *OAuth) GetAuthURL(provider, redirectURI, codeChallenge string) (string, string, error) {
+	if redirectURI == "" {
+		redirectURI = o.RedirectURI
+	}
+
+	return o.startSignIn(provider, &models.OAuthState{
+		RedirectURI:   redirectURI,
+		CodeChallenge: codeChallenge,
+	})
+}
+
+// GetDeviceAuthURL starts a sign-in with the named provider that approves the
+// device with userCode, which the provider sends back to RedirectURI. session
+// identifies the browser the sign-in was started from, which must finish it.
+func (o *OAuth) GetDeviceAuthURL(provider, userCode, session string) (string, string, error) {
+	return o.startSignIn(provider, &models.OAuthState{
+		RedirectURI:    o.RedirectURI,
+		DeviceUserCode: userCode,
+		DeviceSession:  session,
+	})
+}
+
+func (o *OAuth) startSignIn(name string, state *models.OAuthState) (string, string, error) {
+	provider, err := o.provider(name)
+	if err != nil {
+		logging.Warn("Sign-in requested with unknown provider: %s", name)
+		return "", "", err
+	}
+
+	state.State = uuid.New().String()
+	state.Provider = provider.Name()
+	state.Nonce = uuid.New().String()
+	state.CodeVerifier = NewCodeVerifier()
+	state.ExpiresAt = o.now().Add(o.StateTTL)
+
+	if err := o.States.SaveOAuthState(state); err != nil {
+		logging.Error("Failed to save OAuth state", err)
+		return "", "", fmt.Errorf("failed to save state: %w", err)
+	}
+
+	return provider.AuthCodeURL(state), state.State, nil
+}
+
+// GetState returns a sign-in's state without using it up. ErrInvalidState is
+// returned for unknown or expired states.
+func (o *OAuth) GetState(state string) (*models.OAuthState, error) {
+	saved, err := o.States.GetOAuthState(state)
+	if err != nil {
+		logging.Error("Failed to look up OAuth state", err)
+		return nil, fmt.Errorf("failed to look up state: %w", err)
+	}
+	if saved == nil || !o.now().Before(saved.ExpiresAt) {
+		logging.Warn("Invalid state parameter received: %s", state)
+		return nil, ErrInvalidState
+	}
+	return saved, nil
+}
+
+// SignIn trades the code the provider returned for a sign-in for the user who
+// signed in. The sign-in's state is used up, whether or not the exchange succeeds,
+// and ErrInvalidState is returned for unknown, expired or already used states. If
+// the sign-in was started with a code challenge, or a codeVerifier is given,
+// ErrInvalidCodeVerifier is returned unless the two match.
+func (o *OAuth) SignIn(code, state, codeVerifier string) (*models.User, error) {
+	saved, err := o.States.TakeOAuthState(state)
+	if err != nil {
+		logging.Error("Failed to look up OAuth state", err)
+		return nil, fmt.Errorf("failed to look up state: %w", err)
+	}
+	if saved == nil || !o.now().Before(saved.ExpiresAt) {
+		logging.Warn("Invalid state parameter received: %s", state)
+		return nil, ErrInvalidState
+	}
+
+	if (saved.CodeChallenge != "" || codeVerifier != "") && !VerifyCodeChallenge(codeVerifier, saved.CodeChallenge) {
+		logging.Warn("Code verifier doesn't match the challenge for state: %s", state)
+		return nil, ErrInvalidCodeVerifier
+	}
+
+	// a provider removed from the config since the sign-in started
+	provider, err := o.provider(saved.Provider)
+	if err != nil || saved.Provider == "" {
+		logging.Warn("Sign-in finished with unknown provider: %s", saved.Provider)
+		return nil, ErrUnknownProvider
+	}
+
+	return provider.Exchange(code, saved)
+}
@@ -0,0 +1,493 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/josephburgess/breeze/internal/logging"
+	"github.com/josephburgess/breeze/internal/models"
+)
+
+// ErrInvalidIDToken is returned when the ID token from an OpenID Connect provider
+// fails verification.
+var ErrInvalidIDToken = errors.New("invalid ID token")
+
+const (
+	// DefaultOIDCScopes are requested from OpenID Connect providers unless
+	// configured otherwise.
+	DefaultOIDCScopes = "openid profile email"
+
+	// allowance for clocks that disagree when checking ID token expiry
+	idTokenLeeway = time.Minute
+	// the soonest the JWKS is fetched again for a key ID it doesn't have, so
+	// tokens with made up key IDs can't hammer the provider
+	jwksRefetchInterval = time.Minute
+)
+
+// OIDCProvider signs users in with an OpenID Connect provider, such as Keycloak or
+// Authentik, whose endpoints are found by discovery from its issuer URL.
+type OIDCProvider struct {
+	ClientID     string
+	ClientSecret string
+	Scopes       string
+	// from the provider's discovery document
+	Issuer                string
+	AuthorizationEndpoint string
+	TokenEndpoint         string
+	JWKSURI               string
+
+	name   string
+	client *http.Client
+	now    func() time.Time
+
+	mu            sync.Mutex
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+}
+
+// NewOIDCProvider discovers the OpenID Connect provider at issuer, which users who
+// sign in with it are stored under name.
+func NewOIDCProvider(name, issuer, clientID, clientSecret string) (*OIDCProvider, error) {
+	p := &OIDCProvider{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Scopes:       DefaultOIDCScopes,
+		name:         name,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		now:          time.Now,
+	}
+
+	if err := p.discover(issuer); err != nil {
+		logging.Error("OpenID Connect discovery failed", err)
+		return nil, fmt.Errorf("discovery failed for %s: %w", issuer, err)
+	}
+
+	logging.Info("Discovered OpenID Connect provider %s at %s", name, p.Issuer)
+	return p, nil
+}
+
+func (p *OIDCProvider) discover(issuer string) error {
+	var document struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	if err := p.getJSON(strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", &document); err != nil {
+		return err
+	}
+
+	// some providers' issuers end in a slash and some don't, but the document
+	// must be for the issuer asked for
+	if strings.TrimSuffix(document.Issuer, "/") != strings.TrimSuffix(issuer, "/") {
+		return fmt.Errorf("discovery document is for issuer %q", document.Issuer)
+	}
+	if document.AuthorizationEndpoint == "" || document.TokenEndpoint == "" || document.JWKSURI == "" {
+		return errors.New("discovery document is missing authorization_endpoint, token_endpoint or jwks_uri")
+	}
+
+	p.Issuer = document.Issuer
+	p.AuthorizationEndpoint = document.AuthorizationEndpoint
+	p.TokenEndpoint = document.TokenEndpoint
+	p.JWKSURI = document.JWKSURI
+	return nil
+}
+
+func (p *OIDCProvider) Name() string {
+	return p.name
+}
+
+func (p *OIDCProvider) AuthCodeURL(state *models.OAuthState) string {
+	separator := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return p.AuthorizationEndpoint + separator + url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {state.RedirectURI},
+		"scope":                 {p.Scopes},
+		"state":                 {state.State},
+		"nonce":                 {state.Nonce},
+		"code_challenge":        {CodeChallengeS256(state.CodeVerifier)},
+		"code_challenge_method": {"S256"},
+	}.Encode()
+}
+
+// Exchange trades the code the provider returned for its tokens, and identifies the
+// user from the ID token once its signature, issuer, audience, expiry and nonce are
+// verified.
+func (p *OIDCProvider) Exchange(code string, state *models.OAuthState) (*models.User, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {state.RedirectURI},
+		"code_verifier": {state.CodeVerifier},
+		"client_id":     {p.ClientID},
+	}
+	req, err := http.NewRequest(http.MethodPost, p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		logging.Error("Failed to create token request", err)
+		return nil, fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	logging.Info("Exchanging code for token with %s", p.name)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		logging.Error("Token exchange request failed", err)
+		return nil, fmt.Errorf("token exchange request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var tokens struct {
+		AccessToken      string `json:"access_token"`
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		logging.Error("Failed to decode token response", err)
+		return nil, fmt.Errorf("failed to decode token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || tokens.IDToken == "" {
+		errorMsg := tokens.ErrorDescription
+		if errorMsg == "" {
+			errorMsg = tokens.Error
+		}
+		if errorMsg == "" {
+			errorMsg = fmt.Sprintf("no ID token received (status %d)", resp.StatusCode)
+		}
+		logging.Warn("%s OAuth error: %s", p.name, errorMsg)
+		return nil, fmt.Errorf("%s oauth error: %s", p.name, errorMsg)
+	}
+
+	claims, err := p.verifyIDToken(tokens.IDToken, state.Nonce)
+	if err != nil {
+		logging.Error("ID token verification failed", err)
+		return nil, err
+	}
+
+	user := &models.User{
+		Provider: p.name,
+		Subject:  claims.Subject,
+		Login:    claims.PreferredUsername,
+		Token:    tokens.AccessToken,
+	}
+	if user.Login == "" {
+		user.Login = claims.Email
+	}
+	if user.Login == "" {
+		user.Login = claims.Subject
+	}
+	if claims.Name != "" {
+		user.Name = &claims.Name
+	}
+	if claims.Email != "" {
+		user.Email = &claims.Email
+	}
+
+	logging.Info("Successfully verified %s user: %s (subject: %s)", p.name, user.Login, user.Subject)
+	return user, nil
+}
+
+type idTokenClaims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	AuthorizedParty   string   `json:"azp"`
+	Expiry            int64    `json:"exp"`
+	Nonce             string   `json:"nonce"`
+	PreferredUsername string   `json:"preferred_username"`
+	Name              string   `json:"name"`
+	Email             string   `json:"email"`
+}
+
+// audience is an ID token's aud claim, which is either one string or a list.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// verifyIDToken checks the ID token is signed by one of the provider's keys, was
+// issued by it to this client for the sign-in with nonce, and hasn't expired.
+func (p *OIDCProvider) verifyIDToken(raw, nonce string) (*idTokenClaims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidIDToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: malformed header: %v", ErrInvalidIDToken, err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrInvalidIDToken)
+	}
+
+	key, err := p.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	var claims idTokenClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: malformed claims: %v", ErrInvalidIDToken, err)
+	}
+
+	switch {
+	case claims.Issuer != p.Issuer:
+		return nil, fmt.Errorf("%w: issued by %q", ErrInvalidIDToken, claims.Issuer)
+	case !claims.hasAudience(p.ClientID):
+		return nil, fmt.Errorf("%w: not issued to this client", ErrInvalidIDToken)
+	case !p.now().Before(time.Unix(claims.Expiry, 0).Add(idTokenLeeway)):
+		return nil, fmt.Errorf("%w: expired", ErrInvalidIDToken)
+	case nonce == "" || claims.Nonce != nonce:
+		return nil, fmt.Errorf("%w: nonce doesn't match the sign-in", ErrInvalidIDToken)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidIDToken)
+	}
+
+	return &claims, nil
+}
+
+// hasAudience reports whether the token was issued to clientID. Tokens for several
+// audiences must name clientID as the party they were issued to.
+func (c *idTokenClaims) hasAudience(clientID string) bool {
+	found := false
+	for _, aud := range c.Audience {
+		if aud == clientID {
+			found = true
+		}
+	}
+	if len(c.Audience) > 1 || c.AuthorizedParty != "" {
+		return found && c.AuthorizedParty == clientID
+	}
+	return found
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// verifySignature checks a JWS signature made with RS256 or ES256, the algorithms
+// OpenID Connect providers sign ID tokens with by default.
+func verifySignature(alg string, key crypto.PublicKey, signingInput string, signature []byte) error {
+	hash := sha256.Sum256([]byte(signingInput))
+
+	switch alg {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("RS256 token signed with a non-RSA key")
+		}
+		if err := rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, hash[:], signature); err != nil {
+			return errors.New("bad signature")
+		}
+		return nil
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || ecKey.Curve != elliptic.P256() {
+			return errors.New("ES256 token signed with a non-P-256 key")
+		}
+		if len(signature) != 64 {
+			return errors.New("bad signature")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ecKey, hash[:], r, s) {
+			return errors.New("bad signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+}
+
+// key returns the provider's signing key with kid, fetching the JWKS again for a
+// key it doesn't have yet in case the provider has rotated its keys. Tokens without
+// a kid can only be verified while the provider publishes a single key.
+func (p *OIDCProvider) key(kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+
+	if p.keys == nil || p.now().Sub(p.keysFetchedAt) >= jwksRefetchInterval {
+		keys, err := p.fetchKeys()
+		if err != nil {
+			logging.Error("Failed to fetch JWKS", err)
+			return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+		}
+		p.keys = keys
+		p.keysFetchedAt = p.now()
+
+		if key, ok := p.lookupKey(kid); ok {
+			return key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidIDToken, kid)
+}
+
+func (p *OIDCProvider) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok && kid != ""
+}
+
+func (p *OIDCProvider) fetchKeys() (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(p.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		var key crypto.PublicKey
+		var err error
+		switch jwk.Kty {
+		case "RSA":
+			key, err = parseRSAKey(jwk.N, jwk.E)
+		case "EC":
+			if jwk.Crv != "P-256" {
+				continue
+			}
+			key, err = parseP256Key(jwk.X, jwk.Y)
+		default:
+			continue
+		}
+		if err != nil {
+			logging.Warn("Skipping unusable JWKS key %s: %v", jwk.Kid, err)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+func parseRSAKey(n, e string) (*rsa.PublicKey, error) {
+	modulus, err := base64.RawURLEncoding.DecodeString(n)
+	if err != nil {
+		return nil, err
+	}
+	exponent, err := base64.RawURLEncoding.DecodeString(e)
+	if err != nil {
+		return nil, err
+	}
+	if len(exponent) == 0 || len(exponent) > 4 {
+		return nil, errors.New("bad exponent")
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(modulus),
+		E: int(new(big.Int).SetBytes(exponent).Int64()),
+	}, nil
+}
+
+func parseP256Key(x, y string) (*ecdsa.PublicKey, error) {
+	xBytes, err := base64.RawURLEncoding.DecodeString(x)
+	if err != nil {
+		return nil, err
+	}
+	yBytes, err := base64.RawURLEncoding.DecodeString(y)
+	if err != nil {
+		return nil, err
+	}
+	if len(xBytes) != 32 || len(yBytes) != 32 {
+		return nil, errors.New("bad coordinates")
+	}
+
+	// checks the point is on the curve
+	point := append([]byte{4}, append(xBytes, yBytes...)...)
+	if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+		return nil, err
+	}
+
+	return &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(xBytes),
+		Y:     new(big.Int).SetBytes(yBytes),
+	}, nil
+}
+
+func (p *OIDCProvider) getJSON(target string, v any) error {
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %d", target, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
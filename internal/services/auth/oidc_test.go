@@ -0,0 +1,398 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubOIDC is a local OpenID Connect provider that issues IDToken from its token
+// endpoint and publishes the public halves of Keys as its JWKS.
+type stubOIDC struct {
+	*httptest.Server
+	Keys        map[string]crypto.Signer
+	IDToken     string
+	TokenForm   url.Values
+	JWKSFetches int
+}
+
+func newStubOIDC(t *testing.T) *stubOIDC {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	stub := &stubOIDC{Keys: map[string]crypto.Signer{"rsa-1": rsaKey}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 stub.URL,
+			"authorization_endpoint": stub.URL + "/authorize",
+			"token_endpoint":         stub.URL + "/token",
+			"jwks_uri":               stub.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		clientID, secret, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "breeze", clientID)
+		assert.Equal(t, "test-secret", secret)
+		require.NoError(t, r.ParseForm())
+		stub.TokenForm = r.PostForm
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "test-access-token",
+			"token_type":   "Bearer",
+			"id_token":     stub.IDToken,
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		stub.JWKSFetches++
+
+		keys := []map[string]string{}
+		for kid, key := range stub.Keys {
+			switch public := key.Public().(type) {
+			case *rsa.PublicKey:
+				keys = append(keys, map[string]string{
+					"kty": "RSA",
+					"kid": kid,
+					"use": "sig",
+					"n":   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+					"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+				})
+			case *ecdsa.PublicKey:
+				keys = append(keys, map[string]string{
+					"kty": "EC",
+					"kid": kid,
+					"crv": "P-256",
+					"x":   base64.RawURLEncoding.EncodeToString(public.X.FillBytes(make([]byte, 32))),
+					"y":   base64.RawURLEncoding.EncodeToString(public.Y.FillBytes(make([]byte, 32))),
+				})
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	})
+	stub.Server = httptest.NewServer(mux)
+	t.Cleanup(stub.Close)
+
+	return stub
+}
+
+// claims returns valid ID token claims for a sign-in with nonce.
+func (s *stubOIDC) claims(nonce string) map[string]any {
+	return map[string]any{
+		"iss":                s.URL,
+		"sub":                "f3b5c9e2-subject",
+		"aud":                "breeze",
+		"azp":                "breeze",
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"iat":                time.Now().Unix(),
+		"nonce":              nonce,
+		"preferred_username": "alice",
+		"name":               "Alice",
+		"email":              "alice@example.com",
+	}
+}
+
+// signToken makes a JWS with alg, signed by key, or unsigned for a nil key.
+func signToken(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]any) string {
+	t.Helper()
+
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	if key == nil {
+		return signingInput + "."
+	}
+
+	hash := sha256.Sum256([]byte(signingInput))
+	var signature []byte
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+		require.NoError(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+		require.NoError(t, err)
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func newStubOIDCSignIn(t *testing.T) (*stubOIDC, *OIDCProvider, *OAuth) {
+	t.Helper()
+
+	stub := newStubOIDC(t)
+	provider, err := NewOIDCProvider("keycloak", stub.URL+"/", "breeze", "test-secret")
+	require.NoError(t, err)
+	oauth := NewOAuth("https://breeze.example/api/auth/callback", NewMemoryStateStore(), NewGitHubOAuth("client-id", "client-secret"), provider)
+
+	return stub, provider, oauth
+}
+
+// startSignIn starts a sign-in with the stub, returning its state and nonce.
+func startSignIn(t *testing.T, oauth *OAuth) (string, string) {
+	t.Helper()
+
+	authURL, state, err := oauth.GetAuthURL("keycloak", "", "")
+	require.NoError(t, err)
+	parsed, err := url.Parse(authURL)
+	require.NoError(t, err)
+	return state, parsed.Query().Get("nonce")
+}
+
+func TestNewOIDCProvider(t *testing.T) {
+	stub := newStubOIDC(t)
+
+	provider, err := NewOIDCProvider("keycloak", stub.URL, "breeze", "test-secret")
+	require.NoError(t, err)
+	assert.Equal(t, "keycloak", provider.Name())
+	assert.Equal(t, stub.URL, provider.Issuer)
+	assert.Equal(t, stub.URL+"/authorize", provider.AuthorizationEndpoint)
+	assert.Equal(t, stub.URL+"/token", provider.TokenEndpoint)
+	assert.Equal(t, stub.URL+"/jwks", provider.JWKSURI)
+
+	_, err = NewOIDCProvider("keycloak", stub.URL+"/realms/other", "breeze", "test-secret")
+	assert.Error(t, err, "no discovery document there")
+
+	other := newStubOIDC(t)
+	mux := http.NewServeMux()
+	mux.Handle("/", other.Config.Handler)
+	impostor := httptest.NewServer(mux)
+	defer impostor.Close()
+	_, err = NewOIDCProvider("keycloak", impostor.URL, "breeze", "test-secret")
+	assert.ErrorContains(t, err, "discovery document is for issuer", "the document must be for the issuer asked for")
+}
+
+func TestOIDCProvider_SignIn(t *testing.T) {
+	stub, _, oauth := newStubOIDCSignIn(t)
+
+	authURL, state, err := oauth.GetAuthURL("keycloak", "http://localhost:5000/callback", "")
+	require.NoError(t, err)
+
+	parsed, err := url.Parse(authURL)
+	require.NoError(t, err)
+	query := parsed.Query()
+	assert.Equal(t, stub.URL+"/authorize", parsed.Scheme+"://"+parsed.Host+parsed.Path)
+	assert.Equal(t, "code", query.Get("response_type"))
+	assert.Equal(t, "breeze", query.Get("client_id"))
+	assert.Equal(t, "http://localhost:5000/callback", query.Get("redirect_uri"))
+	assert.Equal(t, DefaultOIDCScopes, query.Get("scope"))
+	assert.Equal(t, state, query.Get("state"))
+	assert.NotEmpty(t, query.Get("nonce"))
+	assert.Equal(t, "S256", query.Get("code_challenge_method"))
+
+	saved, err := oauth.GetState(state)
+	require.NoError(t, err)
+	assert.Equal(t, "keycloak", saved.Provider)
+	assert.Equal(t, saved.Nonce, query.Get("nonce"))
+	assert.Equal(t, CodeChallengeS256(saved.CodeVerifier), query.Get("code_challenge"))
+
+	stub.IDToken = signToken(t, "RS256", "rsa-1", stub.Keys["rsa-1"], stub.claims(saved.Nonce))
+
+	user, err := oauth.SignIn("test-code", state, "")
+	require.NoError(t, err)
+	assert.Equal(t, "keycloak", user.Provider)
+	assert.Equal(t, "f3b5c9e2-subject", user.Subject)
+	assert.Zero(t, user.GithubID)
+	assert.Equal(t, "alice", user.Login)
+	assert.Equal(t, "Alice", *user.Name)
+	assert.Equal(t, "alice@example.com", *user.Email)
+	assert.Equal(t, "test-access-token", user.Token)
+
+	assert.Equal(t, "authorization_code", stub.TokenForm.Get("grant_type"))
+	assert.Equal(t, "test-code", stub.TokenForm.Get("code"))
+	assert.Equal(t, "http://localhost:5000/callback", stub.TokenForm.Get("redirect_uri"))
+	assert.Equal(t, saved.CodeVerifier, stub.TokenForm.Get("code_verifier"))
+
+	_, err = oauth.SignIn("test-code", state, "")
+	assert.ErrorIs(t, err, ErrInvalidState, "a code can only be exchanged once")
+}
+
+func TestOIDCProvider_SignIn_ES256(t *testing.T) {
+	stub, _, oauth := newStubOIDCSignIn(t)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	stub.Keys = map[string]crypto.Signer{"ec-1": ecKey}
+
+	state, nonce := startSignIn(t, oauth)
+	stub.IDToken = signToken(t, "ES256", "ec-1", ecKey, stub.claims(nonce))
+
+	user, err := oauth.SignIn("test-code", state, "")
+	require.NoError(t, err)
+	assert.Equal(t, "f3b5c9e2-subject", user.Subject)
+}
+
+func TestOIDCProvider_SignIn_InvalidIDToken(t *testing.T) {
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		token func(stub *stubOIDC, nonce string) string
+	}{
+		{
+			name: "wrong nonce",
+			token: func(stub *stubOIDC, nonce string) string {
+				return signToken(t, "RS256", "rsa-1", stub.Keys["rsa-1"], stub.claims("another-sign-in"))
+			},
+		},
+		{
+			name: "no nonce",
+			token: func(stub *stubOIDC, nonce string) string {
+				claims := stub.claims(nonce)
+				delete(claims, "nonce")
+				return signToken(t, "RS256", "rsa-1", stub.Keys["rsa-1"], claims)
+			},
+		},
+		{
+			name: "another client",
+			token: func(stub *stubOIDC, nonce string) string {
+				claims := stub.claims(nonce)
+				claims["aud"] = "another-client"
+				claims["azp"] = "another-client"
+				return signToken(t, "RS256", "rsa-1", stub.Keys["rsa-1"], claims)
+			},
+		},
+		{
+			name: "several audiences issued to another client",
+			token: func(stub *stubOIDC, nonce string) string {
+				claims := stub.claims(nonce)
+				claims["aud"] = []string{"breeze", "another-client"}
+				claims["azp"] = "another-client"
+				return signToken(t, "RS256", "rsa-1", stub.Keys["rsa-1"], claims)
+			},
+		},
+		{
+			name: "another issuer",
+			token: func(stub *stubOIDC, nonce string) string {
+				claims := stub.claims(nonce)
+				claims["iss"] = "https://evil.example"
+				return signToken(t, "RS256", "rsa-1", stub.Keys["rsa-1"], claims)
+			},
+		},
+		{
+			name: "expired",
+			token: func(stub *stubOIDC, nonce string) string {
+				claims := stub.claims(nonce)
+				claims["exp"] = time.Now().Add(-2 * idTokenLeeway).Unix()
+				return signToken(t, "RS256", "rsa-1", stub.Keys["rsa-1"], claims)
+			},
+		},
+		{
+			name: "no subject",
+			token: func(stub *stubOIDC, nonce string) string {
+				claims := stub.claims(nonce)
+				delete(claims, "sub")
+				return signToken(t, "RS256", "rsa-1", stub.Keys["rsa-1"], claims)
+			},
+		},
+		{
+			name: "signed with another key",
+			token: func(stub *stubOIDC, nonce string) string {
+				return signToken(t, "RS256", "rsa-1", otherKey, stub.claims(nonce))
+			},
+		},
+		{
+			name: "unknown key",
+			token: func(stub *stubOIDC, nonce string) string {
+				return signToken(t, "RS256", "rsa-2", otherKey, stub.claims(nonce))
+			},
+		},
+		{
+			name: "unsigned",
+			token: func(stub *stubOIDC, nonce string) string {
+				return signToken(t, "none", "rsa-1", nil, stub.claims(nonce))
+			},
+		},
+		{
+			name: "tampered claims",
+			token: func(stub *stubOIDC, nonce string) string {
+				token := signToken(t, "RS256", "rsa-1", stub.Keys["rsa-1"], stub.claims(nonce))
+				claims := stub.claims(nonce)
+				claims["sub"] = "admin"
+				payload, err := json.Marshal(claims)
+				require.NoError(t, err)
+				parts := strings.Split(token, ".")
+				return parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+			},
+		},
+		{
+			name: "malformed",
+			token: func(stub *stubOIDC, nonce string) string {
+				return "not-a-jwt"
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub, _, oauth := newStubOIDCSignIn(t)
+
+			state, nonce := startSignIn(t, oauth)
+			stub.IDToken = tt.token(stub, nonce)
+
+			user, err := oauth.SignIn("test-code", state, "")
+			assert.ErrorIs(t, err, ErrInvalidIDToken)
+			assert.Nil(t, user)
+		})
+	}
+}
+
+func TestOIDCProvider_KeyRotation(t *testing.T) {
+	stub, provider, oauth := newStubOIDCSignIn(t)
+
+	state, nonce := startSignIn(t, oauth)
+	stub.IDToken = signToken(t, "RS256", "rsa-1", stub.Keys["rsa-1"], stub.claims(nonce))
+	_, err := oauth.SignIn("test-code", state, "")
+	require.NoError(t, err)
+	assert.Equal(t, 1, stub.JWKSFetches)
+
+	state, nonce = startSignIn(t, oauth)
+	stub.IDToken = signToken(t, "RS256", "rsa-1", stub.Keys["rsa-1"], stub.claims(nonce))
+	_, err = oauth.SignIn("test-code", state, "")
+	require.NoError(t, err)
+	assert.Equal(t, 1, stub.JWKSFetches, "keys are cached")
+
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	stub.Keys["rsa-2"] = newKey
+
+	state, nonce = startSignIn(t, oauth)
+	stub.IDToken = signToken(t, "RS256", "rsa-2", newKey, stub.claims(nonce))
+	_, err = oauth.SignIn("test-code", state, "")
+	assert.ErrorIs(t, err, ErrInvalidIDToken, "the JWKS was fetched too recently to fetch again")
+	assert.Equal(t, 1, stub.JWKSFetches)
+
+	provider.now = func() time.Time { return time.Now().Add(jwksRefetchInterval) }
+	state, nonce = startSignIn(t, oauth)
+	stub.IDToken = signToken(t, "RS256", "rsa-2", newKey, stub.claims(nonce))
+	_, err = oauth.SignIn("test-code", state, "")
+	require.NoError(t, err, "a new key is fetched")
+	assert.Equal(t, 2, stub.JWKSFetches)
+}
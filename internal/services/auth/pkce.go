@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"regexp"
+)
+
+var ErrInvalidCodeVerifier = errors.New("code verifier doesn't match the code challenge")
+
+// verifiers are 43 to 128 unreserved characters, and S256 challenges are the
+// unpadded base64url encoding of a SHA-256 hash
+var (
+	codeVerifierPattern  = regexp.MustCompile(`^[A-Za-z0-9._~-]{43,128}$`)
+	codeChallengePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{43}$`)
+)
+
+// NewCodeVerifier returns a random PKCE code verifier (RFC 7636).
+func NewCodeVerifier() string {
+	bytes := make([]byte, 32)
+	rand.Read(bytes)
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
+
+// CodeChallengeS256 returns the S256 code challenge for a verifier.
+func CodeChallengeS256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// ValidCodeVerifier reports whether verifier is a well formed PKCE code verifier.
+func ValidCodeVerifier(verifier string) bool {
+	return codeVerifierPattern.MatchString(verifier)
+}
+
+// ValidCodeChallenge reports whether challenge is a well formed S256 code challenge.
+func ValidCodeChallenge(challenge string) bool {
+	return codeChallengePattern.MatchString(challenge)
+}
+
+// VerifyCodeChallenge reports whether verifier is the one challenge was made from.
+func VerifyCodeChallenge(verifier, challenge string) bool {
+	if !ValidCodeVerifier(verifier) || challenge == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(CodeChallengeS256(verifier)), []byte(challenge)) == 1
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/josephburgess/breeze/internal/models"
+)
+
+// how long a user has to finish signing in with GitHub
+const DefaultStateTTL = 10 * time.Minute
+
+// expired states are swept at most this often
+const stateSweepInterval = time.Minute
+
+var ErrInvalidState = errors.New("invalid or expired state")
+
+// StateStore keeps the states of sign-ins in progress. store.UserStore keeps them in
+// the database, so they survive restarts and are shared by every process using it.
+type StateStore interface {
+	SaveOAuthState(state *models.OAuthState) error
+	// GetOAuthState returns a state without using it up, or nil if there is no such
+	// state.
+	GetOAuthState(state string) (*models.OAuthState, error)
+	// TakeOAuthState removes and returns a state, or returns nil if there is no such
+	// state, so each state can only be used once.
+	TakeOAuthState(state string) (*models.OAuthState, error)
+}
+
+// MemoryStateStore keeps states in memory, for a single process.
+type MemoryStateStore struct {
+	mu        sync.Mutex
+	states    map[string]models.OAuthState
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryStateStore() *MemoryStateStore {
+	return &MemoryStateStore{
+		states: make(map[string]models.OAuthState),
+		now:    time.Now,
+	}
+}
+
+func (m *MemoryStateStore) SaveOAuthState(state *models.OAuthState) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// abandoned sign-ins are dropped as new ones start, so the map can't grow
+	// without bound
+	if now := m.now(); now.Sub(m.lastSweep) >= stateSweepInterval {
+		for key, saved := range m.states {
+			if !now.Before(saved.ExpiresAt) {
+				delete(m.states, key)
+			}
+		}
+		m.lastSweep = now
+	}
+
+	m.states[state.State] = *state
+	return nil
+}
+
+func (m *MemoryStateStore) GetOAuthState(state string) (*models.OAuthState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	saved, ok := m.states[state]
+	if !ok {
+		return nil, nil
+	}
+	return &saved, nil
+}
+
+func (m *MemoryStateStore) TakeOAuthState(state string) (*models.OAuthState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	saved, ok := m.states[state]
+	if !ok {
+		return nil, nil
+	}
+	delete(m.states, state)
+	return &saved, nil
+}
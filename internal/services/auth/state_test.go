@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/josephburgess/breeze/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStateStore(t *testing.T) {
+	states := NewMemoryStateStore()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	states.now = func() time.Time { return now }
+
+	require.NoError(t, states.SaveOAuthState(&models.OAuthState{State: "abandoned", ExpiresAt: now.Add(time.Minute)}))
+	require.NoError(t, states.SaveOAuthState(&models.OAuthState{State: "used", RedirectURI: "http://localhost:9876/callback", CodeVerifier: "verifier", ExpiresAt: now.Add(time.Hour)}))
+
+	saved, err := states.TakeOAuthState("used")
+	require.NoError(t, err)
+	require.NotNil(t, saved)
+	assert.Equal(t, "http://localhost:9876/callback", saved.RedirectURI)
+	assert.Equal(t, "verifier", saved.CodeVerifier)
+
+	saved, err = states.TakeOAuthState("used")
+	require.NoError(t, err)
+	assert.Nil(t, saved, "states can only be taken once")
+
+	now = now.Add(2 * time.Minute)
+	require.NoError(t, states.SaveOAuthState(&models.OAuthState{State: "new", ExpiresAt: now.Add(time.Minute)}))
+	assert.NotContains(t, states.states, "abandoned", "expired states are swept")
+	assert.Contains(t, states.states, "new")
+}
+
+func TestMemoryStateStore_Concurrent(t *testing.T) {
+	states := NewMemoryStateStore()
+	require.NoError(t, states.SaveOAuthState(&models.OAuthState{State: "raced", ExpiresAt: time.Now().Add(time.Minute)}))
+
+	var taken atomic.Int32
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if i%2 == 0 {
+				states.SaveOAuthState(&models.OAuthState{State: "other", ExpiresAt: time.Now().Add(time.Minute)})
+			}
+			if saved, _ := states.TakeOAuthState("raced"); saved != nil {
+				taken.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), taken.Load())
+}
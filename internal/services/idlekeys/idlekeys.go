@@ -0,0 +1,171 @@
+package idlekeys
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/josephburgess/breeze/internal/logging"
+	"github.com/josephburgess/breeze/internal/models"
+)
+
+const day = 24 * time.Hour
+
+type KeyStore interface {
+	ListIdleAPICredentials(idleSince time.Time) ([]models.ApiCredential, error)
+	FlagIdleAPICredential(credential *models.ApiCredential, idleSince time.Time) (bool, error)
+	DisableIdleAPICredential(credential *models.ApiCredential, idleSince time.Time) (bool, error)
+}
+
+// IdleKey is a key the cleaner flagged or disabled, or would have in a dry run.
+type IdleKey struct {
+	ID           string     `json:"id"`
+	GithubUserID int64      `json:"github_user_id"`
+	Name         string     `json:"name"`
+	KeyPrefix    string     `json:"key_prefix"`
+	LastUsed     *time.Time `json:"last_used"`
+	IdleDays     int        `json:"idle_days"`
+}
+
+type Report struct {
+	DryRun           bool      `json:"dry_run"`
+	GeneratedAt      time.Time `json:"generated_at"`
+	FlagAfterDays    int       `json:"flag_after_days"`
+	DisableAfterDays int       `json:"disable_after_days"`
+	Flagged          []IdleKey `json:"flagged"`
+	Disabled         []IdleKey `json:"disabled"`
+}
+
+// Cleaner flags keys that haven't been used for flagAfterDays, so users can see them
+// in their key list, and revokes keys unused for disableAfterDays. Either step is
+// skipped when its number of days is 0.
+type Cleaner struct {
+	store            KeyStore
+	flagAfterDays    int
+	disableAfterDays int
+	interval         time.Duration
+	now              func() time.Time
+}
+
+func NewCleaner(store KeyStore, flagAfterDays, disableAfterDays int, interval time.Duration) *Cleaner {
+	return &Cleaner{
+		store:            store,
+		flagAfterDays:    flagAfterDays,
+		disableAfterDays: disableAfterDays,
+		interval:         interval,
+		now:              time.Now,
+	}
+}
+
+// Run sweeps for idle keys on every interval until ctx is cancelled.
+func (c *Cleaner) Run(ctx context.Context) {
+	if c.flagAfterDays == 0 && c.disableAfterDays == 0 {
+		logging.Info("Idle key cleanup disabled")
+		return
+	}
+
+	logging.Info("Starting idle key cleanup (interval: %s, flag after %d days, disable after %d days)", c.interval, c.flagAfterDays, c.disableAfterDays)
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := c.Sweep(ctx, false); err != nil {
+			logging.Error("Idle key cleanup failed", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			logging.Info("Stopping idle key cleanup")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep disables and then flags idle keys, returning what it changed. With dryRun
+// nothing is changed and the report lists what would be.
+func (c *Cleaner) Sweep(ctx context.Context, dryRun bool) (*Report, error) {
+	now := c.now().UTC()
+	report := &Report{
+		DryRun:           dryRun,
+		GeneratedAt:      now,
+		FlagAfterDays:    c.flagAfterDays,
+		DisableAfterDays: c.disableAfterDays,
+		Flagged:          []IdleKey{},
+		Disabled:         []IdleKey{},
+	}
+
+	disabled := map[string]bool{}
+	if c.disableAfterDays > 0 {
+		idleSince := now.Add(-time.Duration(c.disableAfterDays) * day)
+		keys, err := c.apply(ctx, idleSince, dryRun, now, c.store.DisableIdleAPICredential, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to disable idle keys: %w", err)
+		}
+		for _, key := range keys {
+			disabled[key.ID] = true
+		}
+		report.Disabled = keys
+	}
+
+	if c.flagAfterDays > 0 {
+		idleSince := now.Add(-time.Duration(c.flagAfterDays) * day)
+		skip := func(credential *models.ApiCredential) bool {
+			return credential.IdleFlaggedAt != nil || disabled[credential.ID]
+		}
+		keys, err := c.apply(ctx, idleSince, dryRun, now, c.store.FlagIdleAPICredential, skip)
+		if err != nil {
+			return nil, fmt.Errorf("failed to flag idle keys: %w", err)
+		}
+		report.Flagged = keys
+	}
+
+	if !dryRun && (len(report.Flagged) > 0 || len(report.Disabled) > 0) {
+		logging.Info("Idle key cleanup flagged %d and disabled %d keys", len(report.Flagged), len(report.Disabled))
+	}
+	return report, nil
+}
+
+func (c *Cleaner) apply(ctx context.Context, idleSince time.Time, dryRun bool, now time.Time, change func(*models.ApiCredential, time.Time) (bool, error), skip func(*models.ApiCredential) bool) ([]IdleKey, error) {
+	credentials, err := c.store.ListIdleAPICredentials(idleSince)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []IdleKey{}
+	for i := range credentials {
+		if ctx.Err() != nil {
+			return keys, ctx.Err()
+		}
+
+		credential := &credentials[i]
+		if skip != nil && skip(credential) {
+			continue
+		}
+
+		if !dryRun {
+			changed, err := change(credential, idleSince)
+			if err != nil {
+				return keys, err
+			}
+			if !changed {
+				continue
+			}
+		}
+
+		lastActive := credential.CreatedAt
+		if credential.LastUsed != nil {
+			lastActive = *credential.LastUsed
+		}
+		keys = append(keys, IdleKey{
+			ID:           credential.ID,
+			GithubUserID: credential.GithubUserID,
+			Name:         credential.Name,
+			KeyPrefix:    credential.KeyPrefix,
+			LastUsed:     credential.LastUsed,
+			IdleDays:     int(now.Sub(lastActive) / day),
+		})
+	}
+	return keys, nil
+}
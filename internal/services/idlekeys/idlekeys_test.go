@@ -0,0 +1,69 @@
+package idlekeys
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/josephburgess/breeze/internal/models"
+	"github.com/josephburgess/breeze/internal/services/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCleaner_Sweep(t *testing.T) {
+	userStore, err := store.NewUserStore(filepath.Join(t.TempDir(), "test.db"), "test-pepper")
+	require.NoError(t, err)
+	t.Cleanup(func() { userStore.Close() })
+
+	require.NoError(t, userStore.SaveUser(&models.User{GithubID: 1, Login: "idler", Token: "token"}))
+	credential, err := userStore.IssueAPIKey(1, store.DefaultKeyName)
+	require.NoError(t, err)
+
+	cleaner := NewCleaner(userStore, 30, 90, time.Hour)
+	ctx := context.Background()
+
+	cleaner.now = func() time.Time { return time.Now().Add(10 * day) }
+	report, err := cleaner.Sweep(ctx, false)
+	require.NoError(t, err)
+	assert.Empty(t, report.Flagged)
+	assert.Empty(t, report.Disabled)
+
+	cleaner.now = func() time.Time { return time.Now().Add(40 * day) }
+	for range 2 {
+		report, err = cleaner.Sweep(ctx, true)
+		require.NoError(t, err)
+		assert.True(t, report.DryRun)
+		require.Len(t, report.Flagged, 1, "dry runs don't change anything")
+		assert.Equal(t, credential.ID, report.Flagged[0].ID)
+		assert.Equal(t, 40, report.Flagged[0].IdleDays)
+	}
+
+	report, err = cleaner.Sweep(ctx, false)
+	require.NoError(t, err)
+	require.Len(t, report.Flagged, 1)
+	assert.Empty(t, report.Disabled)
+
+	report, err = cleaner.Sweep(ctx, false)
+	require.NoError(t, err)
+	assert.Empty(t, report.Flagged, "keys are only flagged once")
+
+	credentials, err := userStore.ListAPICredentials(1)
+	require.NoError(t, err)
+	assert.NotNil(t, credentials[0].IdleFlaggedAt)
+	assert.Nil(t, credentials[0].RevokedAt)
+
+	cleaner.now = func() time.Time { return time.Now().Add(100 * day) }
+	report, err = cleaner.Sweep(ctx, true)
+	require.NoError(t, err)
+	assert.Len(t, report.Disabled, 1)
+	assert.Empty(t, report.Flagged)
+
+	report, err = cleaner.Sweep(ctx, false)
+	require.NoError(t, err)
+	require.Len(t, report.Disabled, 1)
+
+	_, _, _, _, err = userStore.ValidateAPIKey(credential.ApiKey)
+	assert.ErrorIs(t, err, store.ErrAPIKeyRevoked)
+}
@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter is an in-memory token bucket limiter. Each key gets a bucket holding up to
+// capacity tokens that refills one token every refill interval, so a client can make
+// capacity requests at once and then one per interval.
+type Limiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	refill    time.Duration
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens   float64
+	capacity int
+	updated  time.Time
+}
+
+func NewLimiter(refill time.Duration) *Limiter {
+	return &Limiter{
+		buckets: make(map[string]*bucket),
+		refill:  refill,
+	}
+}
+
+// Allow takes a token from key's bucket at now. It returns whether the request is
+// allowed, the tokens left, and when refused, how long until a token is available.
+func (l *Limiter) Allow(key string, capacity int, now time.Time) (bool, int, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok || b.capacity != capacity {
+		b = &bucket{tokens: float64(capacity), capacity: capacity, updated: now}
+		l.buckets[key] = b
+	}
+	b.fill(now, l.refill)
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) * float64(l.refill))
+		return false, 0, wait
+	}
+
+	b.tokens--
+	return true, int(b.tokens), 0
+}
+
+func (b *bucket) fill(now time.Time, refill time.Duration) {
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = min(float64(b.capacity), b.tokens+float64(elapsed)/float64(refill))
+		b.updated = now
+	}
+}
+
+// sweep drops buckets that have refilled completely, as they are the same as new
+// ones, so keys that stop making requests don't use memory forever.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	for key, b := range l.buckets {
+		b.fill(now, l.refill)
+		if b.tokens >= float64(b.capacity) {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	limiter := NewLimiter(time.Second)
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := range 3 {
+		ok, remaining, _ := limiter.Allow("key", 3, now)
+		assert.True(t, ok)
+		assert.Equal(t, 2-i, remaining)
+	}
+
+	ok, remaining, retryAfter := limiter.Allow("key", 3, now)
+	assert.False(t, ok, "burst used up")
+	assert.Equal(t, 0, remaining)
+	assert.Equal(t, time.Second, retryAfter)
+
+	ok, _, retryAfter = limiter.Allow("key", 3, now.Add(400*time.Millisecond))
+	assert.False(t, ok)
+	assert.Equal(t, 600*time.Millisecond, retryAfter)
+
+	ok, remaining, _ = limiter.Allow("key", 3, now.Add(time.Second))
+	assert.True(t, ok, "one token refilled")
+	assert.Equal(t, 0, remaining)
+
+	ok, _, _ = limiter.Allow("other", 3, now.Add(time.Second))
+	assert.True(t, ok, "keys have separate buckets")
+
+	ok, remaining, _ = limiter.Allow("key", 3, now.Add(time.Hour))
+	assert.True(t, ok)
+	assert.Equal(t, 2, remaining, "refills up to capacity only")
+}
+
+func TestLimiter_CapacityChange(t *testing.T) {
+	limiter := NewLimiter(time.Second)
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	ok, _, _ := limiter.Allow("key", 1, now)
+	assert.True(t, ok)
+	ok, _, _ = limiter.Allow("key", 1, now)
+	assert.False(t, ok)
+
+	ok, remaining, _ := limiter.Allow("key", 5, now)
+	assert.True(t, ok, "a new capacity starts a new bucket")
+	assert.Equal(t, 4, remaining)
+}
+
+func TestLimiter_Sweep(t *testing.T) {
+	limiter := NewLimiter(time.Second)
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	limiter.Allow("idle", 5, now)
+	limiter.Allow("busy", 5, now.Add(2*time.Minute))
+	assert.NotContains(t, limiter.buckets, "idle")
+	assert.Contains(t, limiter.buckets, "busy")
+}
@@ -0,0 +1,80 @@
+package store
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/josephburgess/breeze/internal/logging"
+	"github.com/josephburgess/breeze/internal/models"
+)
+
+// idleSQL is when a credential was last active: its last use, or its creation if it
+// has never been used.
+const idleSQL = "COALESCE(last_used, created_at) < ?"
+
+// ListIdleAPICredentials returns active credentials that haven't been used since
+// idleSince, oldest activity first.
+func (s *UserStore) ListIdleAPICredentials(idleSince time.Time) ([]models.ApiCredential, error) {
+	var credentials []models.ApiCredential
+	if err := s.db.Where("revoked_at IS NULL AND "+idleSQL, idleSince).
+		Order("COALESCE(last_used, created_at)").
+		Find(&credentials).Error; err != nil {
+		logging.Error("Failed to list idle API credentials", err)
+		return nil, err
+	}
+	return credentials, nil
+}
+
+// FlagIdleAPICredential marks a credential as idle if it is still unused since
+// idleSince and not already flagged. It reports whether the credential was changed.
+func (s *UserStore) FlagIdleAPICredential(credential *models.ApiCredential, idleSince time.Time) (bool, error) {
+	return s.markIdleAPICredential(credential, idleSince, "idle_flagged_at", models.AuditKeyFlaggedIdle)
+}
+
+// DisableIdleAPICredential revokes a credential if it is still unused since
+// idleSince. It reports whether the credential was changed.
+func (s *UserStore) DisableIdleAPICredential(credential *models.ApiCredential, idleSince time.Time) (bool, error) {
+	return s.markIdleAPICredential(credential, idleSince, "revoked_at", models.AuditKeyDisabledIdle)
+}
+
+// markIdleAPICredential sets column to now and records action in the audit log, in
+// one transaction. The idle condition is checked again in the update so a key used
+// since it was listed is left alone.
+func (s *UserStore) markIdleAPICredential(credential *models.ApiCredential, idleSince time.Time, column, action string) (bool, error) {
+	changed := false
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.ApiCredential{}).
+			Where("id = ? AND revoked_at IS NULL AND "+column+" IS NULL AND "+idleSQL, credential.ID, idleSince).
+			Update(column, time.Now().UTC())
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		changed = true
+
+		return tx.Create(&models.AuditEntry{
+			GithubUserID: credential.GithubUserID,
+			CredentialID: credential.ID,
+			Action:       action,
+			Detail:       fmt.Sprintf("key %q (%s...) unused since %s", credential.Name, credential.KeyPrefix, lastActive(credential).Format(time.RFC3339)),
+		}).Error
+	})
+	if err != nil {
+		logging.Error("Failed to update idle API credential", err)
+		return false, err
+	}
+
+	if changed {
+		s.forgetCredentials()
+		logging.Info("API credential %s: %s", credential.ID, action)
+	}
+	return changed, nil
+}
+
+func lastActive(credential *models.ApiCredential) time.Time {
+	if credential.LastUsed != nil {
+		return *credential.LastUsed
+	}
+	return credential.CreatedAt
+}
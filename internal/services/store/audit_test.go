@@ -0,0 +1,75 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/josephburgess/breeze/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserStore_IdleAPICredentials(t *testing.T) {
+	store := setupTestDB(t)
+
+	user := &models.User{GithubID: 987, Login: "idler", Token: "token987"}
+	require.NoError(t, store.SaveUser(user))
+
+	credential, err := store.IssueAPIKey(user.GithubID, DefaultKeyName)
+	require.NoError(t, err)
+
+	longAgo := time.Now().UTC().Add(-100 * 24 * time.Hour)
+	idleSince := time.Now().UTC().Add(-30 * 24 * time.Hour)
+	setLastUsed := func() {
+		require.NoError(t, store.db.Model(&models.ApiCredential{}).Where("id = ?", credential.ID).Update("last_used", longAgo).Error)
+	}
+	auditActions := func() []string {
+		var entries []models.AuditEntry
+		require.NoError(t, store.db.Where("github_user_id = ?", user.GithubID).Order("id").Find(&entries).Error)
+		actions := []string{}
+		for _, entry := range entries {
+			assert.Equal(t, credential.ID, entry.CredentialID)
+			actions = append(actions, entry.Action)
+		}
+		return actions
+	}
+
+	idle, err := store.ListIdleAPICredentials(idleSince)
+	require.NoError(t, err)
+	assert.Empty(t, idle, "a new key isn't idle")
+
+	setLastUsed()
+	idle, err = store.ListIdleAPICredentials(idleSince)
+	require.NoError(t, err)
+	require.Len(t, idle, 1)
+
+	changed, err := store.FlagIdleAPICredential(&idle[0], idleSince)
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	changed, err = store.FlagIdleAPICredential(&idle[0], idleSince)
+	require.NoError(t, err)
+	assert.False(t, changed, "already flagged")
+	assert.Equal(t, []string{models.AuditKeyFlaggedIdle}, auditActions())
+
+	validated, _, _, _, err := store.ValidateAPIKey(credential.ApiKey)
+	require.NoError(t, err)
+	assert.Nil(t, validated.IdleFlaggedAt, "using the key clears the flag")
+
+	changed, err = store.DisableIdleAPICredential(&idle[0], idleSince)
+	require.NoError(t, err)
+	assert.False(t, changed, "used since it was listed")
+
+	setLastUsed()
+	changed, err = store.DisableIdleAPICredential(&idle[0], idleSince)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, []string{models.AuditKeyFlaggedIdle, models.AuditKeyDisabledIdle}, auditActions())
+
+	_, _, _, _, err = store.ValidateAPIKey(credential.ApiKey)
+	assert.ErrorIs(t, err, ErrAPIKeyRevoked)
+
+	idle, err = store.ListIdleAPICredentials(idleSince)
+	require.NoError(t, err)
+	assert.Empty(t, idle, "revoked keys aren't listed")
+}
@@ -0,0 +1,324 @@
+package store
+
+import (
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/josephburgess/breeze/internal/logging"
+	"github.com/josephburgess/breeze/internal/models"
+)
+
+// how long a cached credential is trusted before being read again, in case it was
+// changed outside of the store
+const credentialCacheTTL = 30 * time.Second
+
+// usage entries that have been flushed and not used for this long are dropped
+const usageIdleTimeout = time.Hour
+
+// usageCache keeps credentials and their usage in memory so authenticating a request
+// doesn't touch the database. The in-memory counts are authoritative while the
+// process runs, so rate limit decisions are made under mu, and the counts are written
+// back to the database every flush interval and on Close.
+type usageCache struct {
+	mu          sync.Mutex
+	credentials map[string]*cachedCredential // by key hash, current or previous
+	usage       map[string]*credentialUsage  // by credential ID
+	users       map[int64]*dailyCount        // per user totals, for per-user quotas
+	records     map[usageKey]int             // usage history not yet written
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type cachedCredential struct {
+	credential models.ApiCredential
+	loadedAt   time.Time
+}
+
+type credentialUsage struct {
+	dailyCount
+	pending  int // requests not yet added to request_count
+	lastUsed time.Time
+	dirty    bool
+}
+
+type dailyCount struct {
+	day   time.Time
+	count int
+}
+
+// rollover starts a new count if the count is from before today.
+func (d *dailyCount) rollover(today time.Time) {
+	if d.day.Before(today) {
+		d.day = today
+		d.count = 0
+	}
+}
+
+// EnableUsageCache keeps credentials and usage counts in memory, writing usage to the
+// database every flushInterval and when the store is closed. Only one process may
+// use the database while it is enabled.
+func (s *UserStore) EnableUsageCache(flushInterval time.Duration) {
+	s.cache = &usageCache{
+		credentials: make(map[string]*cachedCredential),
+		usage:       make(map[string]*credentialUsage),
+		users:       make(map[int64]*dailyCount),
+		records:     make(map[usageKey]int),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+
+	go func() {
+		defer close(s.cache.done)
+
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.cache.stop:
+				return
+			case <-ticker.C:
+				if err := s.flushUsage(); err != nil {
+					logging.Error("Failed to flush API usage", err)
+				}
+			}
+		}
+	}()
+
+	logging.Info("Caching API usage in memory (flush interval: %s)", flushInterval)
+}
+
+// findCredential loads the credential for a key hash, with its User and Plan.
+func (s *UserStore) findCredential(hash string) (models.ApiCredential, error) {
+	if s.cache != nil {
+		s.cache.mu.Lock()
+		cached, ok := s.cache.credentials[hash]
+		s.cache.mu.Unlock()
+
+		if ok && s.now().Sub(cached.loadedAt) < credentialCacheTTL {
+			return cached.credential, nil
+		}
+	}
+
+	var credential models.ApiCredential
+	if err := s.db.Preload("User").Preload("Plan").Where("key_hash = ? OR previous_key_hash = ?", hash, hash).First(&credential).Error; err != nil {
+		return credential, err
+	}
+
+	if s.cache != nil {
+		s.cache.mu.Lock()
+		s.cache.credentials[hash] = &cachedCredential{credential: credential, loadedAt: s.now()}
+		s.cache.mu.Unlock()
+	}
+	return credential, nil
+}
+
+// forgetCredentials drops cached credentials after keys, users or plans change, so
+// the next request sees the change. Usage counts are kept.
+func (s *UserStore) forgetCredentials() {
+	if s.cache == nil {
+		return
+	}
+
+	s.cache.mu.Lock()
+	defer s.cache.mu.Unlock()
+	clear(s.cache.credentials)
+}
+
+// credentialUsage returns the in-memory usage for a credential, starting from the
+// counts loaded with it. Must be called with mu held.
+func (c *usageCache) credentialUsage(credential *models.ApiCredential, today time.Time) *credentialUsage {
+	usage, ok := c.usage[credential.ID]
+	if !ok {
+		usage = &credentialUsage{dailyCount: dailyCount{day: credential.DailyResetAt, count: credential.DailyRequestCount}}
+		c.usage[credential.ID] = usage
+	}
+	usage.rollover(today)
+	return usage
+}
+
+// userUsage returns the in-memory total for all of a user's keys, reading it from the
+// database the first time. Must be called with mu held.
+func (s *UserStore) userUsage(credential *models.ApiCredential, today time.Time) (*dailyCount, error) {
+	usage, ok := s.cache.users[credential.GithubUserID]
+	if !ok {
+		var total int
+		if err := s.db.Model(&models.ApiCredential{}).
+			Where("github_user_id = ? AND daily_reset_at >= ?", credential.GithubUserID, today).
+			Select("COALESCE(SUM(daily_request_count), 0)").
+			Scan(&total).Error; err != nil {
+			logging.Error("Failed to count user's API usage", err)
+			return nil, err
+		}
+		usage = &dailyCount{day: today, count: total}
+		s.cache.users[credential.GithubUserID] = usage
+	}
+	usage.rollover(today)
+	return usage, nil
+}
+
+// cachedUsedToday is usedToday when usage is cached.
+func (s *UserStore) cachedUsedToday(credential *models.ApiCredential, today time.Time) (int, error) {
+	s.cache.mu.Lock()
+	defer s.cache.mu.Unlock()
+
+	if s.QuotaScope == QuotaPerUser {
+		usage, err := s.userUsage(credential, today)
+		if err != nil {
+			return 0, err
+		}
+		return usage.count, nil
+	}
+	return s.cache.credentialUsage(credential, today).count, nil
+}
+
+// cachedCountRequest is countRequest when usage is cached. The check and the
+// increment happen under one lock.
+func (s *UserStore) cachedCountRequest(credential *models.ApiCredential, today, now time.Time, limit int) (bool, error) {
+	s.cache.mu.Lock()
+	defer s.cache.mu.Unlock()
+
+	usage := s.cache.credentialUsage(credential, today)
+	used := &usage.dailyCount
+	if s.QuotaScope == QuotaPerUser {
+		var err error
+		if used, err = s.userUsage(credential, today); err != nil {
+			return false, err
+		}
+	}
+
+	if limit > 0 && used.count >= limit {
+		return false, nil
+	}
+
+	if used != &usage.dailyCount {
+		used.count++
+	}
+	usage.count++
+	usage.pending++
+	usage.lastUsed = now
+	usage.dirty = true
+
+	credential.DailyRequestCount = usage.count
+	credential.DailyResetAt = today
+	credential.RequestCount++
+	credential.LastUsed = &now
+	credential.IdleFlaggedAt = nil
+	return true, nil
+}
+
+// overlayUsage replaces database usage figures with any newer ones held in memory.
+func (s *UserStore) overlayUsage(credentials []models.ApiCredential) {
+	if s.cache == nil {
+		return
+	}
+
+	s.cache.mu.Lock()
+	defer s.cache.mu.Unlock()
+
+	for i := range credentials {
+		usage, ok := s.cache.usage[credentials[i].ID]
+		if !ok || !usage.dirty {
+			continue
+		}
+		lastUsed := usage.lastUsed
+		credentials[i].DailyRequestCount = usage.count
+		credentials[i].DailyResetAt = usage.day
+		credentials[i].RequestCount += usage.pending
+		credentials[i].LastUsed = &lastUsed
+		credentials[i].IdleFlaggedAt = nil
+	}
+}
+
+// flushUsage writes usage changed since the last flush to the database in one
+// transaction.
+func (s *UserStore) flushUsage() error {
+	type flush struct {
+		id       string
+		pending  int
+		count    int
+		day      time.Time
+		lastUsed time.Time
+	}
+
+	s.cache.mu.Lock()
+	var flushes []flush
+	now := s.now()
+	for id, usage := range s.cache.usage {
+		if !usage.dirty {
+			if now.Sub(usage.lastUsed) > usageIdleTimeout {
+				s.cache.dropUsage(id)
+			}
+			continue
+		}
+		flushes = append(flushes, flush{id, usage.pending, usage.count, usage.day, usage.lastUsed})
+		usage.pending = 0
+		usage.dirty = false
+	}
+	records := s.cache.records
+	s.cache.records = make(map[usageKey]int)
+	s.cache.mu.Unlock()
+
+	if len(flushes) == 0 && len(records) == 0 {
+		return nil
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		for _, f := range flushes {
+			if err := tx.Model(&models.ApiCredential{}).Where("id = ?", f.id).Updates(map[string]any{
+				"request_count":       gorm.Expr("request_count + ?", f.pending),
+				"daily_request_count": f.count,
+				"daily_reset_at":      f.day,
+				"last_used":           f.lastUsed,
+				"idle_flagged_at":     nil,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		return addUsage(tx, records)
+	})
+	if err != nil {
+		// keep the requests so the next flush writes them
+		s.cache.mu.Lock()
+		for _, f := range flushes {
+			if usage, ok := s.cache.usage[f.id]; ok {
+				usage.pending += f.pending
+				usage.dirty = true
+			}
+		}
+		for key, count := range records {
+			s.cache.records[key] += count
+		}
+		s.cache.mu.Unlock()
+		return err
+	}
+
+	logging.Info("Flushed API usage for %d credentials (%d usage records)", len(flushes), len(records))
+	return nil
+}
+
+// dropUsage forgets a credential's usage along with the cached credential, so it
+// is read again from the database, which is up to date after a flush. Must be called
+// with mu held.
+func (c *usageCache) dropUsage(id string) {
+	delete(c.usage, id)
+	for hash, cached := range c.credentials {
+		if cached.credential.ID == id {
+			delete(c.credentials, hash)
+		}
+	}
+}
+
+// closeCache stops the flush loop and writes any remaining usage.
+func (s *UserStore) closeCache() error {
+	if s.cache == nil {
+		return nil
+	}
+
+	close(s.cache.stop)
+	<-s.cache.done
+	return s.flushUsage()
+}
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/josephburgess/breeze/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserStore_UsageCache(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewUserStore(dbPath, "test-pepper")
+	require.NoError(t, err)
+	store.EnableUsageCache(time.Hour)
+
+	require.NoError(t, store.SaveUser(&models.User{GithubID: 4444, Login: "cached", Token: "token"}))
+	laptop, err := store.IssueAPIKey(4444, "laptop")
+	require.NoError(t, err)
+	dashboard, err := store.IssueAPIKey(4444, "dashboard")
+	require.NoError(t, err)
+
+	stored := func(id string) models.ApiCredential {
+		var credential models.ApiCredential
+		require.NoError(t, store.db.Where("id = ?", id).First(&credential).Error)
+		return credential
+	}
+
+	for range 3 {
+		_, _, _, _, err := store.ValidateAPIKey(laptop.ApiKey)
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, 0, stored(laptop.ID).DailyRequestCount, "usage isn't written on every request")
+	assert.Nil(t, stored(laptop.ID).LastUsed)
+
+	credentials, err := store.ListAPICredentials(4444)
+	require.NoError(t, err)
+	assert.Equal(t, 3, credentials[0].DailyRequestCount, "listed keys show usage not yet flushed")
+	assert.Equal(t, 3, credentials[0].RequestCount)
+
+	require.NoError(t, store.flushUsage())
+	assert.Equal(t, 3, stored(laptop.ID).DailyRequestCount)
+	assert.Equal(t, 3, stored(laptop.ID).RequestCount)
+	assert.NotNil(t, stored(laptop.ID).LastUsed)
+
+	t.Run("changes to keys and plans apply straight away", func(t *testing.T) {
+		require.NoError(t, store.SetCredentialPlan(laptop.ID, models.PlanSupporter))
+		_, limit, _, _, err := store.ValidateAPIKey(laptop.ApiKey)
+		require.NoError(t, err)
+		assert.Equal(t, 500, limit)
+
+		require.NoError(t, store.RevokeAPIKey(4444, laptop.ID))
+		_, _, _, _, err = store.ValidateAPIKey(laptop.ApiKey)
+		assert.ErrorIs(t, err, ErrAPIKeyRevoked)
+	})
+
+	t.Run("daily limit", func(t *testing.T) {
+		free, err := store.GetPlan(models.PlanFree)
+		require.NoError(t, err)
+		free.DailyLimit = 2
+		free.BurstLimit = 0
+		require.NoError(t, store.UpdatePlan(free))
+
+		for range 2 {
+			_, _, _, _, err := store.ValidateAPIKey(dashboard.ApiKey)
+			require.NoError(t, err)
+		}
+		_, _, used, _, err := store.ValidateAPIKey(dashboard.ApiKey)
+		var rateLimitErr *RateLimitError
+		assert.ErrorAs(t, err, &rateLimitErr)
+		assert.Equal(t, 2, used)
+	})
+
+	require.NoError(t, store.Close())
+
+	reopened, err := NewUserStore(dbPath, "test-pepper")
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	credentials, err = reopened.ListAPICredentials(4444)
+	require.NoError(t, err)
+	require.Len(t, credentials, 2)
+	assert.Equal(t, 4, credentials[0].RequestCount, "usage is flushed on close")
+	assert.Equal(t, 2, credentials[1].DailyRequestCount)
+}
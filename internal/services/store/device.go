@@ -0,0 +1,235 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/josephburgess/breeze/internal/logging"
+	"github.com/josephburgess/breeze/internal/models"
+)
+
+// device polling outcomes, named after the RFC 8628 error codes
+var (
+	ErrAuthorizationPending = errors.New("authorization pending")
+	ErrSlowDown             = errors.New("polling too often")
+	ErrDeviceCodeExpired    = errors.New("device code has expired")
+)
+
+// user codes avoid vowels, so they can't spell words, and characters that are easy
+// to confuse
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+// how much slower a device that polls too often has to poll
+const slowDownStep = 5 * time.Second
+
+// CreateDeviceAuthorization starts a device sign-in that issues a key called keyName
+// once approved, returning the device code and the authorization with its user code.
+// Expired authorizations are cleared out first.
+func (s *UserStore) CreateDeviceAuthorization(keyName string, ttl, interval time.Duration) (string, *models.DeviceAuthorization, error) {
+	now := s.now()
+	if err := s.db.Where("expires_at <= ?", now).Delete(&models.DeviceAuthorization{}).Error; err != nil {
+		logging.Error("Failed to delete expired device authorizations", err)
+		return "", nil, err
+	}
+
+	deviceCode := generateDeviceCode()
+	authorization := &models.DeviceAuthorization{
+		DeviceCodeHash: s.hashAPIKey(deviceCode),
+		KeyName:        keyName,
+		Interval:       int(interval / time.Second),
+		ExpiresAt:      now.Add(ttl),
+	}
+
+	// user codes are short, so retry the rare collision with one in use
+	for range 5 {
+		authorization.UserCode = generateUserCode()
+		result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(authorization)
+		if result.Error != nil {
+			logging.Error("Failed to create device authorization", result.Error)
+			return "", nil, result.Error
+		}
+		if result.RowsAffected == 1 {
+			return deviceCode, authorization, nil
+		}
+	}
+	return "", nil, fmt.Errorf("failed to generate a unique user code")
+}
+
+// GetPendingDeviceAuthorization returns the unexpired, unapproved authorization for
+// a user code, or nil if there is none. User codes are matched ignoring case and
+// separators.
+func (s *UserStore) GetPendingDeviceAuthorization(userCode string) (*models.DeviceAuthorization, error) {
+	var authorization models.DeviceAuthorization
+	err := s.db.Where("user_code = ? AND github_user_id = 0 AND expires_at > ?", NormalizeUserCode(userCode), s.now()).First(&authorization).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		logging.Error("Failed to fetch device authorization", err)
+		return nil, err
+	}
+	return &authorization, nil
+}
+
+// SignInDeviceAuthorization records that the user signed in, from the browser
+// session with sessionHash, to approve the device with the user code, returning the
+// authorization. The device isn't approved until ApproveDeviceAuthorization is
+// called from the same session. It returns ErrNotFound if the code is unknown,
+// expired or already approved.
+func (s *UserStore) SignInDeviceAuthorization(userCode string, githubUserID int64, sessionHash string) (*models.DeviceAuthorization, error) {
+	result := s.db.Model(&models.DeviceAuthorization{}).
+		Where("user_code = ? AND github_user_id = 0 AND expires_at > ?", NormalizeUserCode(userCode), s.now()).
+		Updates(map[string]any{"signed_in_user_id": githubUserID, "session_hash": sessionHash})
+	if result.Error != nil {
+		logging.Error("Failed to record device sign-in", result.Error)
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+
+	authorization, err := s.GetPendingDeviceAuthorization(userCode)
+	if err != nil {
+		return nil, err
+	}
+	if authorization == nil {
+		return nil, ErrNotFound
+	}
+	return authorization, nil
+}
+
+// ApproveDeviceAuthorization lets the device with the user code sign in as the user
+// who signed in to approve it, if the approval comes from the browser session they
+// signed in with, returning the approved authorization. It returns ErrNotFound if
+// the code is unknown, expired, already approved or wasn't signed in to from that
+// session.
+func (s *UserStore) ApproveDeviceAuthorization(userCode, sessionHash string) (*models.DeviceAuthorization, error) {
+	if sessionHash == "" {
+		return nil, ErrNotFound
+	}
+
+	var authorization models.DeviceAuthorization
+	result := s.db.Model(&authorization).
+		Clauses(clause.Returning{}).
+		Where("user_code = ? AND github_user_id = 0 AND signed_in_user_id <> 0 AND session_hash = ? AND expires_at > ?", NormalizeUserCode(userCode), sessionHash, s.now()).
+		Update("github_user_id", gorm.Expr("signed_in_user_id"))
+	if result.Error != nil {
+		logging.Error("Failed to approve device authorization", result.Error)
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+	return &authorization, nil
+}
+
+// PollDeviceAuthorization checks on a device sign-in. Once it has been approved the
+// authorization is returned and deleted, so the device code can be used for one key.
+// Until then it returns ErrAuthorizationPending, or ErrSlowDown if the device polled
+// again within its interval, which grows each time; the authorization is returned
+// with both so the device can be told its interval. Unknown device codes return
+// ErrNotFound and expired ones ErrDeviceCodeExpired.
+func (s *UserStore) PollDeviceAuthorization(deviceCode string) (*models.DeviceAuthorization, error) {
+	var authorization models.DeviceAuthorization
+	now := s.now()
+
+	// the poll is recorded even when the device has to keep waiting, so the outcome
+	// is kept apart from errors that roll the transaction back
+	var outcome error
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("device_code_hash = ?", s.hashAPIKey(deviceCode)).First(&authorization).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				outcome = ErrNotFound
+				return nil
+			}
+			return err
+		}
+
+		if !now.Before(authorization.ExpiresAt) {
+			outcome = ErrDeviceCodeExpired
+			return nil
+		}
+
+		tooSoon := authorization.LastPolledAt != nil && now.Sub(*authorization.LastPolledAt) < time.Duration(authorization.Interval)*time.Second
+		updates := map[string]any{"last_polled_at": now}
+		if tooSoon {
+			authorization.Interval += int(slowDownStep / time.Second)
+			updates["interval"] = authorization.Interval
+		}
+		if err := tx.Model(&authorization).Updates(updates).Error; err != nil {
+			return err
+		}
+
+		switch {
+		case tooSoon:
+			outcome = ErrSlowDown
+			return nil
+		case authorization.GithubUserID == 0:
+			outcome = ErrAuthorizationPending
+			return nil
+		}
+
+		result := tx.Where("device_code_hash = ? AND github_user_id <> 0", authorization.DeviceCodeHash).Delete(&models.DeviceAuthorization{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			outcome = ErrNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		logging.Error("Failed to poll device authorization", err)
+		return nil, err
+	}
+
+	switch {
+	case errors.Is(outcome, ErrSlowDown), errors.Is(outcome, ErrAuthorizationPending):
+		return &authorization, outcome
+	case outcome != nil:
+		return nil, outcome
+	}
+	return &authorization, nil
+}
+
+// NormalizeUserCode puts a user code as typed into its stored form, e.g. bdwphqlt
+// becomes BDWP-HQLT.
+func NormalizeUserCode(userCode string) string {
+	letters := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			r -= 'a' - 'A'
+		}
+		if r < 'A' || r > 'Z' {
+			return -1
+		}
+		return r
+	}, userCode)
+
+	if len(letters) != 8 {
+		return letters
+	}
+	return letters[:4] + "-" + letters[4:]
+}
+
+func generateDeviceCode() string {
+	bytes := make([]byte, 32)
+	rand.Read(bytes)
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
+
+func generateUserCode() string {
+	code := make([]byte, 8)
+	for i := range code {
+		n, _ := rand.Int(rand.Reader, big.NewInt(int64(len(userCodeAlphabet))))
+		code[i] = userCodeAlphabet[n.Int64()]
+	}
+	return NormalizeUserCode(string(code))
+}
@@ -0,0 +1,99 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/josephburgess/breeze/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserStore_DeviceAuthorization(t *testing.T) {
+	store, err := NewUserStore(filepath.Join(t.TempDir(), "test.db"), "test-pepper")
+	require.NoError(t, err)
+	defer store.Close()
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	require.NoError(t, store.SaveUser(&models.User{GithubID: 8888, Login: "headless", Token: "token"}))
+
+	deviceCode, authorization, err := store.CreateDeviceAuthorization("server", 15*time.Minute, 5*time.Second)
+	require.NoError(t, err)
+	assert.Regexp(t, `^[B-DF-HJ-NP-TV-XZ]{4}-[B-DF-HJ-NP-TV-XZ]{4}$`, authorization.UserCode)
+	assert.Equal(t, 5, authorization.Interval)
+
+	var stored models.DeviceAuthorization
+	require.NoError(t, store.db.First(&stored).Error)
+	assert.NotEqual(t, deviceCode, stored.DeviceCodeHash, "device codes are stored hashed")
+
+	_, err = store.PollDeviceAuthorization(deviceCode)
+	assert.ErrorIs(t, err, ErrAuthorizationPending)
+
+	now = now.Add(time.Second)
+	polled, err := store.PollDeviceAuthorization(deviceCode)
+	assert.ErrorIs(t, err, ErrSlowDown)
+	assert.Equal(t, 10, polled.Interval, "polling too soon adds 5 seconds to the interval")
+
+	now = now.Add(6 * time.Second)
+	_, err = store.PollDeviceAuthorization(deviceCode)
+	assert.ErrorIs(t, err, ErrSlowDown, "the longer interval applies from then on")
+
+	pending, err := store.GetPendingDeviceAuthorization(" " + authorization.UserCode[:4] + authorization.UserCode[5:] + " ")
+	require.NoError(t, err)
+	require.NotNil(t, pending, "user codes are matched without the separator and in any case")
+
+	_, err = store.ApproveDeviceAuthorization(authorization.UserCode, "session")
+	assert.ErrorIs(t, err, ErrNotFound, "devices are only approved after someone signs in")
+
+	signedIn, err := store.SignInDeviceAuthorization(authorization.UserCode, 8888, "session")
+	require.NoError(t, err)
+	assert.Equal(t, "server", signedIn.KeyName)
+	assert.Equal(t, int64(0), signedIn.GithubUserID, "signing in doesn't approve the device")
+
+	now = now.Add(time.Minute)
+	_, err = store.PollDeviceAuthorization(deviceCode)
+	assert.ErrorIs(t, err, ErrAuthorizationPending)
+
+	_, err = store.ApproveDeviceAuthorization(authorization.UserCode, "other-session")
+	assert.ErrorIs(t, err, ErrNotFound, "only the session that signed in can approve the device")
+
+	approvedNow, err := store.ApproveDeviceAuthorization(authorization.UserCode, "session")
+	require.NoError(t, err)
+	assert.Equal(t, int64(8888), approvedNow.GithubUserID)
+	_, err = store.ApproveDeviceAuthorization(authorization.UserCode, "session")
+	assert.ErrorIs(t, err, ErrNotFound, "a device can only be approved once")
+	_, err = store.SignInDeviceAuthorization(authorization.UserCode, 9999, "other-session")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	pending, err = store.GetPendingDeviceAuthorization(authorization.UserCode)
+	require.NoError(t, err)
+	assert.Nil(t, pending)
+
+	now = now.Add(time.Minute)
+	approved, err := store.PollDeviceAuthorization(deviceCode)
+	require.NoError(t, err)
+	assert.Equal(t, int64(8888), approved.GithubUserID)
+	assert.Equal(t, "server", approved.KeyName)
+
+	_, err = store.PollDeviceAuthorization(deviceCode)
+	assert.ErrorIs(t, err, ErrNotFound, "device codes can only be used for one key")
+
+	t.Run("expiry", func(t *testing.T) {
+		deviceCode, authorization, err := store.CreateDeviceAuthorization("server", 15*time.Minute, 5*time.Second)
+		require.NoError(t, err)
+
+		now = now.Add(15 * time.Minute)
+		_, err = store.PollDeviceAuthorization(deviceCode)
+		assert.ErrorIs(t, err, ErrDeviceCodeExpired)
+		_, err = store.SignInDeviceAuthorization(authorization.UserCode, 8888, "session")
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		_, _, err = store.CreateDeviceAuthorization("other", 15*time.Minute, 5*time.Second)
+		require.NoError(t, err)
+		_, err = store.PollDeviceAuthorization(deviceCode)
+		assert.ErrorIs(t, err, ErrNotFound, "expired authorizations are cleared out")
+	})
+}
@@ -0,0 +1,96 @@
+package store
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/josephburgess/breeze/internal/logging"
+	"github.com/josephburgess/breeze/internal/models"
+)
+
+func (s *UserStore) ListAPICredentials(githubUserID int64) ([]models.ApiCredential, error) {
+	var credentials []models.ApiCredential
+	if err := s.db.Where("github_user_id = ?", githubUserID).Order("created_at").Find(&credentials).Error; err != nil {
+		logging.Error("Failed to list API credentials", err)
+		return nil, err
+	}
+	s.overlayUsage(credentials)
+	return credentials, nil
+}
+
+// RotateAPIKey replaces a credential's key. The old key keeps working for the grace
+// period and is reported as expired after that.
+func (s *UserStore) RotateAPIKey(githubUserID int64, id string, grace time.Duration) (*models.ApiCredential, error) {
+	var credential models.ApiCredential
+	if err := s.db.Where("id = ? AND github_user_id = ? AND revoked_at IS NULL", id, githubUserID).First(&credential).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		logging.Error("Failed to fetch API credential", err)
+		return nil, err
+	}
+
+	apiKey := generateAPIKey()
+	previousExpiresAt := time.Now().UTC().Add(grace)
+
+	if err := s.db.Model(&credential).Updates(map[string]any{
+		"key_hash":            s.hashAPIKey(apiKey),
+		"key_prefix":          KeyPrefix(apiKey),
+		"previous_key_hash":   credential.KeyHash,
+		"previous_expires_at": previousExpiresAt,
+	}).Error; err != nil {
+		logging.Error("Failed to rotate API key", err)
+		return nil, err
+	}
+	s.forgetCredentials()
+
+	logging.Info("API key rotated for credential %s (grace period %s)", credential.ID, grace)
+
+	credential.ApiKey = apiKey
+	credential.KeyPrefix = KeyPrefix(apiKey)
+	credential.PreviousExpiresAt = &previousExpiresAt
+	return &credential, nil
+}
+
+// RevokeAPIKey disables a credential immediately, including any key still in its
+// rotation grace period. The row is kept so the key can be reported as revoked.
+func (s *UserStore) RevokeAPIKey(githubUserID int64, id string) error {
+	result := s.db.Model(&models.ApiCredential{}).
+		Where("id = ? AND github_user_id = ? AND revoked_at IS NULL", id, githubUserID).
+		Update("revoked_at", time.Now().UTC())
+	if result.Error != nil {
+		logging.Error("Failed to revoke API key", result.Error)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	s.forgetCredentials()
+
+	logging.Info("API credential %s revoked for user: %d", id, githubUserID)
+	return nil
+}
+
+// SetKeyRestrictions replaces the origins and CIDR ranges a credential can be used from.
+func (s *UserStore) SetKeyRestrictions(githubUserID int64, id string, restrictions models.KeyRestrictions) (*models.ApiCredential, error) {
+	var credential models.ApiCredential
+	if err := s.db.Where("id = ? AND github_user_id = ? AND revoked_at IS NULL", id, githubUserID).First(&credential).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		logging.Error("Failed to fetch API credential", err)
+		return nil, err
+	}
+
+	credential.KeyRestrictions = restrictions
+	if err := s.db.Model(&credential).Select("allowed_origins", "allowed_cidrs").Updates(&credential).Error; err != nil {
+		logging.Error("Failed to update API key restrictions", err)
+		return nil, err
+	}
+	s.forgetCredentials()
+
+	logging.Info("API key restrictions updated for credential %s", credential.ID)
+	return &credential, nil
+}
@@ -0,0 +1,124 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/josephburgess/breeze/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserStore_RotateAPIKey(t *testing.T) {
+	store := setupTestDB(t)
+
+	user := &models.User{GithubID: 321, Login: "rotator", Token: "token321"}
+	require.NoError(t, store.SaveUser(user))
+
+	t.Run("with grace period", func(t *testing.T) {
+		original, err := store.IssueAPIKey(user.GithubID, DefaultKeyName)
+		require.NoError(t, err)
+
+		rotated, err := store.RotateAPIKey(user.GithubID, original.ID, time.Hour)
+		require.NoError(t, err)
+		assert.Equal(t, original.ID, rotated.ID)
+		assert.NotEqual(t, original.ApiKey, rotated.ApiKey)
+		require.NotNil(t, rotated.PreviousExpiresAt)
+
+		_, _, _, _, err = store.ValidateAPIKey(rotated.ApiKey)
+		assert.NoError(t, err)
+		_, _, _, _, err = store.ValidateAPIKey(original.ApiKey)
+		assert.NoError(t, err, "old key works during the grace period")
+
+		past := time.Now().UTC().Add(-time.Minute)
+		require.NoError(t, store.db.Model(&models.ApiCredential{}).Where("id = ?", rotated.ID).
+			Update("previous_expires_at", past).Error)
+
+		_, _, _, _, err = store.ValidateAPIKey(original.ApiKey)
+		assert.ErrorIs(t, err, ErrAPIKeyExpired)
+		_, _, _, _, err = store.ValidateAPIKey(rotated.ApiKey)
+		assert.NoError(t, err)
+	})
+
+	t.Run("without grace period", func(t *testing.T) {
+		original, err := store.IssueAPIKey(user.GithubID, DefaultKeyName)
+		require.NoError(t, err)
+
+		_, err = store.RotateAPIKey(user.GithubID, original.ID, 0)
+		require.NoError(t, err)
+
+		_, _, _, _, err = store.ValidateAPIKey(original.ApiKey)
+		assert.ErrorIs(t, err, ErrAPIKeyExpired)
+	})
+
+	t.Run("other user's credential", func(t *testing.T) {
+		credential, err := store.IssueAPIKey(user.GithubID, DefaultKeyName)
+		require.NoError(t, err)
+
+		_, err = store.RotateAPIKey(999, credential.ID, 0)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
+func TestUserStore_RevokeAPIKey(t *testing.T) {
+	store := setupTestDB(t)
+
+	user := &models.User{GithubID: 654, Login: "revoker", Token: "token654"}
+	require.NoError(t, store.SaveUser(user))
+
+	credential, err := store.IssueAPIKey(user.GithubID, DefaultKeyName)
+	require.NoError(t, err)
+	rotated, err := store.RotateAPIKey(user.GithubID, credential.ID, time.Hour)
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, store.RevokeAPIKey(999, credential.ID), ErrNotFound)
+	require.NoError(t, store.RevokeAPIKey(user.GithubID, credential.ID))
+	assert.ErrorIs(t, store.RevokeAPIKey(user.GithubID, credential.ID), ErrNotFound, "already revoked")
+
+	_, _, _, _, err = store.ValidateAPIKey(rotated.ApiKey)
+	assert.ErrorIs(t, err, ErrAPIKeyRevoked)
+	_, _, _, _, err = store.ValidateAPIKey(credential.ApiKey)
+	assert.ErrorIs(t, err, ErrAPIKeyRevoked, "the grace period key is revoked too")
+
+	credentials, err := store.ListAPICredentials(user.GithubID)
+	require.NoError(t, err)
+	require.Len(t, credentials, 1)
+	assert.NotNil(t, credentials[0].RevokedAt)
+
+	t.Run("signing in again restores access", func(t *testing.T) {
+		reissued, err := store.IssueAPIKey(user.GithubID, DefaultKeyName)
+		require.NoError(t, err)
+
+		_, _, _, _, err = store.ValidateAPIKey(reissued.ApiKey)
+		assert.NoError(t, err)
+	})
+}
+
+func TestUserStore_SetKeyRestrictions(t *testing.T) {
+	store := setupTestDB(t)
+
+	user := &models.User{GithubID: 654, Login: "widget", Token: "token654"}
+	require.NoError(t, store.SaveUser(user))
+
+	credential, err := store.IssueAPIKey(user.GithubID, DefaultKeyName)
+	require.NoError(t, err)
+
+	restrictions := models.KeyRestrictions{
+		AllowedOrigins: []string{"https://joeburgess.dev"},
+		AllowedCIDRs:   []string{"10.0.0.0/8"},
+	}
+	updated, err := store.SetKeyRestrictions(user.GithubID, credential.ID, restrictions)
+	require.NoError(t, err)
+	assert.Equal(t, restrictions, updated.KeyRestrictions)
+
+	validated, _, _, _, err := store.ValidateAPIKey(credential.ApiKey)
+	require.NoError(t, err)
+	assert.Equal(t, restrictions, validated.KeyRestrictions)
+
+	updated, err = store.SetKeyRestrictions(user.GithubID, credential.ID, models.KeyRestrictions{})
+	require.NoError(t, err)
+	assert.Empty(t, updated.AllowedOrigins)
+
+	_, err = store.SetKeyRestrictions(999, credential.ID, restrictions)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -0,0 +1,178 @@
+package store
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+
+	"github.com/josephburgess/breeze/internal/logging"
+	"github.com/josephburgess/breeze/internal/models"
+)
+
+func (s *UserStore) ListSavedLocations(githubUserID int64) ([]models.SavedLocation, error) {
+	var locations []models.SavedLocation
+	if err := s.db.Where("github_user_id = ?", githubUserID).
+		Order("position, id").
+		Find(&locations).Error; err != nil {
+		logging.Error("Failed to list saved locations", err)
+		return nil, err
+	}
+	return locations, nil
+}
+
+func (s *UserStore) GetSavedLocation(githubUserID int64, id uint) (*models.SavedLocation, error) {
+	var location models.SavedLocation
+	err := s.db.Where("id = ? AND github_user_id = ?", id, githubUserID).First(&location).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		logging.Error("Failed to fetch saved location", err)
+		return nil, err
+	}
+	return &location, nil
+}
+
+// GetDefaultLocation returns the user's default location, or nil if they have none.
+func (s *UserStore) GetDefaultLocation(githubUserID int64) (*models.SavedLocation, error) {
+	var location models.SavedLocation
+	err := s.db.Where("github_user_id = ? AND is_default = ?", githubUserID, true).First(&location).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		logging.Error("Failed to fetch default location", err)
+		return nil, err
+	}
+	return &location, nil
+}
+
+// MaxSavedLocations is how many locations a user can save. Weather for all of them
+// is fetched at once, so it bounds the upstream calls one request can make.
+const MaxSavedLocations = 20
+
+// ErrTooManyLocations is returned when a user already has MaxSavedLocations.
+var ErrTooManyLocations = errors.New("too many saved locations")
+
+// CreateSavedLocation adds a location to the end of the user's list. The first
+// location a user saves becomes their default. ErrTooManyLocations is returned if
+// the user already has MaxSavedLocations.
+func (s *UserStore) CreateSavedLocation(location *models.SavedLocation) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.SavedLocation{}).Where("github_user_id = ?", location.GithubUserID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count >= MaxSavedLocations {
+			return ErrTooManyLocations
+		}
+
+		if count == 0 {
+			location.IsDefault = true
+		}
+
+		if location.Position == 0 {
+			var maxPosition *int
+			if err := tx.Model(&models.SavedLocation{}).
+				Where("github_user_id = ?", location.GithubUserID).
+				Select("MAX(position)").
+				Scan(&maxPosition).Error; err != nil {
+				return err
+			}
+			if maxPosition != nil {
+				location.Position = *maxPosition + 1
+			}
+		}
+
+		if location.IsDefault {
+			if err := clearDefaultLocation(tx, location.GithubUserID); err != nil {
+				return err
+			}
+		}
+
+		return tx.Create(location).Error
+	})
+	if errors.Is(err, ErrTooManyLocations) {
+		return err
+	}
+	if err != nil {
+		logging.Error("Failed to create saved location", err)
+		return err
+	}
+
+	logging.Info("Saved location %d created for user: %d", location.ID, location.GithubUserID)
+	return nil
+}
+
+func (s *UserStore) UpdateSavedLocation(location *models.SavedLocation) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if location.IsDefault {
+			if err := clearDefaultLocation(tx, location.GithubUserID); err != nil {
+				return err
+			}
+		}
+
+		result := tx.Model(&models.SavedLocation{}).
+			Where("id = ? AND github_user_id = ?", location.ID, location.GithubUserID).
+			Updates(map[string]any{
+				"name":       location.Name,
+				"lat":        location.Lat,
+				"lon":        location.Lon,
+				"is_default": location.IsDefault,
+				"position":   location.Position,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		logging.Error("Failed to update saved location", err)
+	}
+	return err
+}
+
+// DeleteSavedLocation removes a location. If it was the default, the next location
+// in the user's list takes over.
+func (s *UserStore) DeleteSavedLocation(githubUserID int64, id uint) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var location models.SavedLocation
+		if err := tx.Where("id = ? AND github_user_id = ?", id, githubUserID).First(&location).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrNotFound
+			}
+			return err
+		}
+
+		if err := tx.Delete(&location).Error; err != nil {
+			return err
+		}
+
+		if !location.IsDefault {
+			return nil
+		}
+
+		var next models.SavedLocation
+		err := tx.Where("github_user_id = ?", githubUserID).Order("position, id").First(&next).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return tx.Model(&next).Update("is_default", true).Error
+	})
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		logging.Error("Failed to delete saved location", err)
+	}
+	return err
+}
+
+func clearDefaultLocation(tx *gorm.DB, githubUserID int64) error {
+	return tx.Model(&models.SavedLocation{}).
+		Where("github_user_id = ? AND is_default = ?", githubUserID, true).
+		Update("is_default", false).Error
+}
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/josephburgess/breeze/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserStore_SavedLocations(t *testing.T) {
+	store := setupTestDB(t)
+
+	user := &models.User{GithubID: 123, Login: "testuser", Token: "token123"}
+	require.NoError(t, store.SaveUser(user))
+
+	home := &models.SavedLocation{GithubUserID: user.GithubID, Name: "Home", Lat: 51.5, Lon: -0.12}
+	work := &models.SavedLocation{GithubUserID: user.GithubID, Name: "Work", Lat: 51.52, Lon: -0.08}
+	cabin := &models.SavedLocation{GithubUserID: user.GithubID, Name: "Cabin", Lat: 60.1, Lon: 10.2, IsDefault: true}
+
+	t.Run("create", func(t *testing.T) {
+		require.NoError(t, store.CreateSavedLocation(home))
+		require.NoError(t, store.CreateSavedLocation(work))
+
+		assert.True(t, home.IsDefault, "first location becomes the default")
+		assert.False(t, work.IsDefault)
+		assert.Equal(t, 0, home.Position)
+		assert.Equal(t, 1, work.Position)
+	})
+
+	t.Run("new default replaces the old one", func(t *testing.T) {
+		require.NoError(t, store.CreateSavedLocation(cabin))
+
+		locations, err := store.ListSavedLocations(user.GithubID)
+		require.NoError(t, err)
+		require.Len(t, locations, 3)
+		assert.Equal(t, []string{"Home", "Work", "Cabin"}, []string{locations[0].Name, locations[1].Name, locations[2].Name})
+		assert.False(t, locations[0].IsDefault)
+		assert.True(t, locations[2].IsDefault)
+
+		def, err := store.GetDefaultLocation(user.GithubID)
+		require.NoError(t, err)
+		assert.Equal(t, cabin.ID, def.ID)
+	})
+
+	t.Run("update reorders and sets default", func(t *testing.T) {
+		work.Position = -1
+		work.IsDefault = true
+		require.NoError(t, store.UpdateSavedLocation(work))
+
+		locations, err := store.ListSavedLocations(user.GithubID)
+		require.NoError(t, err)
+		assert.Equal(t, "Work", locations[0].Name)
+
+		def, err := store.GetDefaultLocation(user.GithubID)
+		require.NoError(t, err)
+		assert.Equal(t, work.ID, def.ID)
+	})
+
+	t.Run("other users can't see or change locations", func(t *testing.T) {
+		_, err := store.GetSavedLocation(999, home.ID)
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		assert.ErrorIs(t, store.UpdateSavedLocation(&models.SavedLocation{ID: home.ID, GithubUserID: 999, Name: "x"}), ErrNotFound)
+		assert.ErrorIs(t, store.DeleteSavedLocation(999, home.ID), ErrNotFound)
+	})
+
+	t.Run("deleting the default promotes the next location", func(t *testing.T) {
+		require.NoError(t, store.DeleteSavedLocation(user.GithubID, work.ID))
+
+		def, err := store.GetDefaultLocation(user.GithubID)
+		require.NoError(t, err)
+		require.NotNil(t, def)
+		assert.Equal(t, home.ID, def.ID)
+
+		_, err = store.GetSavedLocation(user.GithubID, work.ID)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("saved locations are capped", func(t *testing.T) {
+		locations, err := store.ListSavedLocations(user.GithubID)
+		require.NoError(t, err)
+		for i := len(locations); i < MaxSavedLocations; i++ {
+			require.NoError(t, store.CreateSavedLocation(&models.SavedLocation{GithubUserID: user.GithubID, Name: fmt.Sprintf("Place %d", i)}))
+		}
+
+		err = store.CreateSavedLocation(&models.SavedLocation{GithubUserID: user.GithubID, Name: "One too many"})
+		assert.ErrorIs(t, err, ErrTooManyLocations)
+
+		require.NoError(t, store.CreateSavedLocation(&models.SavedLocation{GithubUserID: 456, Name: "Elsewhere"}), "the cap is per user")
+	})
+}
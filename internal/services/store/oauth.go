@@ -0,0 +1,54 @@
+package store
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/josephburgess/breeze/internal/logging"
+	"github.com/josephburgess/breeze/internal/models"
+)
+
+// SaveOAuthState records a sign-in in progress, clearing out any that have expired.
+func (s *UserStore) SaveOAuthState(state *models.OAuthState) error {
+	if err := s.db.Where("expires_at <= ?", s.now()).Delete(&models.OAuthState{}).Error; err != nil {
+		logging.Error("Failed to delete expired OAuth states", err)
+		return err
+	}
+
+	if err := s.db.Create(state).Error; err != nil {
+		logging.Error("Failed to save OAuth state", err)
+		return err
+	}
+	return nil
+}
+
+// GetOAuthState returns a sign-in's state, or nil if there is no such state.
+func (s *UserStore) GetOAuthState(state string) (*models.OAuthState, error) {
+	var saved models.OAuthState
+	err := s.db.Where("state = ?", state).First(&saved).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		logging.Error("Failed to fetch OAuth state", err)
+		return nil, err
+	}
+	return &saved, nil
+}
+
+// TakeOAuthState deletes and returns a sign-in's state, or returns nil if there is
+// no such state. The delete and read are one statement, so a state can only be
+// taken once however many requests race for it.
+func (s *UserStore) TakeOAuthState(state string) (*models.OAuthState, error) {
+	var taken []models.OAuthState
+	if err := s.db.Clauses(clause.Returning{}).Where("state = ?", state).Delete(&taken).Error; err != nil {
+		logging.Error("Failed to take OAuth state", err)
+		return nil, err
+	}
+	if len(taken) == 0 {
+		return nil, nil
+	}
+	return &taken[0], nil
+}
@@ -0,0 +1,54 @@
+package store
+
+import (
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/josephburgess/breeze/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserStore_OAuthStates(t *testing.T) {
+	store, err := NewUserStore(filepath.Join(t.TempDir(), "test.db"), "test-pepper")
+	require.NoError(t, err)
+	defer store.Close()
+
+	now := time.Now().UTC()
+	require.NoError(t, store.SaveOAuthState(&models.OAuthState{State: "abandoned", RedirectURI: "http://localhost:9876/callback", CodeVerifier: "v1", ExpiresAt: now.Add(-time.Minute)}))
+	require.NoError(t, store.SaveOAuthState(&models.OAuthState{State: "current", RedirectURI: "http://localhost:9876/callback", CodeVerifier: "v2", ExpiresAt: now.Add(time.Minute)}))
+
+	var count int64
+	require.NoError(t, store.db.Model(&models.OAuthState{}).Where("state = ?", "abandoned").Count(&count).Error)
+	assert.Zero(t, count, "expired states are deleted")
+
+	saved, err := store.GetOAuthState("current")
+	require.NoError(t, err)
+	require.NotNil(t, saved)
+	assert.Equal(t, "http://localhost:9876/callback", saved.RedirectURI, "getting a state doesn't use it up")
+
+	var wg sync.WaitGroup
+	var taken atomic.Int32
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			saved, err := store.TakeOAuthState("current")
+			assert.NoError(t, err)
+			if saved != nil {
+				assert.Equal(t, "v2", saved.CodeVerifier)
+				assert.Equal(t, "http://localhost:9876/callback", saved.RedirectURI)
+				taken.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), taken.Load(), "states can only be taken once")
+
+	saved, err = store.TakeOAuthState("unknown")
+	require.NoError(t, err)
+	assert.Nil(t, saved)
+}
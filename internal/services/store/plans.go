@@ -0,0 +1,129 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/josephburgess/breeze/internal/logging"
+	"github.com/josephburgess/breeze/internal/models"
+)
+
+// seedPlans creates any of the default plans that don't exist yet, leaving existing
+// ones as they were edited.
+func seedPlans(db *gorm.DB) error {
+	plans := make([]models.Plan, len(models.DefaultPlans))
+	copy(plans, models.DefaultPlans)
+	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&plans).Error
+}
+
+// legacyDailyLimit is what credentials were given before plans existed. Keys left on
+// it simply move to the free plan.
+const legacyDailyLimit = 40
+
+// migrateCredentialLimits moves credentials whose own daily limit was changed from
+// the default onto a plan with the same limit, creating a custom-<limit> plan like
+// free when none has it, so dropping the per-credential column keeps their limits.
+func migrateCredentialLimits(db *gorm.DB) error {
+	var limits []int
+	if err := db.Table("api_credentials").
+		Where("rate_limit_per_day <> ? AND plan_name = ?", legacyDailyLimit, models.PlanFree).
+		Distinct().Pluck("rate_limit_per_day", &limits).Error; err != nil {
+		return err
+	}
+	if len(limits) == 0 {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var free models.Plan
+		if err := tx.Where("name = ?", models.PlanFree).First(&free).Error; err != nil {
+			return err
+		}
+
+		for _, limit := range limits {
+			var plan models.Plan
+			err := tx.Where("daily_limit = ?", limit).Order("name").First(&plan).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				plan = models.Plan{
+					Name:             fmt.Sprintf("custom-%d", limit),
+					DailyLimit:       limit,
+					BurstLimit:       free.BurstLimit,
+					AllowedEndpoints: free.AllowedEndpoints,
+				}
+				err = tx.Create(&plan).Error
+			}
+			if err != nil {
+				return err
+			}
+
+			if err := tx.Table("api_credentials").
+				Where("rate_limit_per_day = ? AND plan_name = ?", limit, models.PlanFree).
+				Update("plan_name", plan.Name).Error; err != nil {
+				return err
+			}
+			logging.Info("Credentials limited to %d requests per day moved to plan %s", limit, plan.Name)
+		}
+		return nil
+	})
+}
+
+func (s *UserStore) ListPlans() ([]models.Plan, error) {
+	var plans []models.Plan
+	if err := s.db.Order("daily_limit = 0, daily_limit").Find(&plans).Error; err != nil {
+		logging.Error("Failed to list plans", err)
+		return nil, err
+	}
+	return plans, nil
+}
+
+func (s *UserStore) GetPlan(name string) (*models.Plan, error) {
+	var plan models.Plan
+	if err := s.db.Where("name = ?", name).First(&plan).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		logging.Error("Failed to fetch plan", err)
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// UpdatePlan replaces a plan's limits, which applies to every credential on it from
+// their next request.
+func (s *UserStore) UpdatePlan(plan *models.Plan) error {
+	result := s.db.Model(plan).Select("daily_limit", "burst_limit", "allowed_endpoints", "updated_at").Updates(plan)
+	if result.Error != nil {
+		logging.Error("Failed to update plan", result.Error)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	s.forgetCredentials()
+
+	logging.Info("Plan %s updated: %d per day, burst %d", plan.Name, plan.DailyLimit, plan.BurstLimit)
+	return nil
+}
+
+// SetCredentialPlan moves a credential onto another plan.
+func (s *UserStore) SetCredentialPlan(id string, planName string) error {
+	if _, err := s.GetPlan(planName); err != nil {
+		return err
+	}
+
+	result := s.db.Model(&models.ApiCredential{}).Where("id = ?", id).Update("plan_name", planName)
+	if result.Error != nil {
+		logging.Error("Failed to set credential plan", result.Error)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	s.forgetCredentials()
+
+	logging.Info("API credential %s moved to plan %s", id, planName)
+	return nil
+}
@@ -0,0 +1,74 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/josephburgess/breeze/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserStore_Plans(t *testing.T) {
+	store := setupTestDB(t)
+
+	plans, err := store.ListPlans()
+	require.NoError(t, err)
+	names := []string{}
+	for _, plan := range plans {
+		names = append(names, plan.Name)
+	}
+	assert.Equal(t, []string{models.PlanFree, models.PlanSupporter, models.PlanAdmin, models.PlanUnlimited}, names)
+
+	user := &models.User{GithubID: 4040, Login: "planner", Token: "token4040"}
+	require.NoError(t, store.SaveUser(user))
+
+	credential, err := store.IssueAPIKey(user.GithubID, DefaultKeyName)
+	require.NoError(t, err)
+	assert.Equal(t, models.PlanFree, credential.PlanName)
+
+	t.Run("limits come from the plan", func(t *testing.T) {
+		validated, limit, _, _, err := store.ValidateAPIKey(credential.ApiKey)
+		require.NoError(t, err)
+		assert.Equal(t, 50, limit)
+		assert.Equal(t, models.PlanFree, validated.Plan.Name)
+	})
+
+	t.Run("plan changes apply to existing keys", func(t *testing.T) {
+		free, err := store.GetPlan(models.PlanFree)
+		require.NoError(t, err)
+		free.DailyLimit = 1
+		require.NoError(t, store.UpdatePlan(free))
+
+		_, limit, _, _, err := store.ValidateAPIKey(credential.ApiKey)
+		var rateLimitErr *RateLimitError
+		assert.ErrorAs(t, err, &rateLimitErr, "already used once today")
+		assert.Equal(t, 1, limit)
+
+		free.DailyLimit = 50
+		require.NoError(t, store.UpdatePlan(free))
+	})
+
+	t.Run("unlimited plan", func(t *testing.T) {
+		require.NoError(t, store.SetCredentialPlan(credential.ID, models.PlanUnlimited))
+		require.NoError(t, store.db.Model(&models.ApiCredential{}).Where("id = ?", credential.ID).Update("daily_request_count", 100000).Error)
+
+		_, limit, _, _, err := store.ValidateAPIKey(credential.ApiKey)
+		require.NoError(t, err)
+		assert.Equal(t, 0, limit)
+	})
+
+	t.Run("missing plan falls back to free", func(t *testing.T) {
+		require.NoError(t, store.db.Model(&models.ApiCredential{}).Where("id = ?", credential.ID).Update("plan_name", "deleted").Error)
+
+		_, limit, _, _, err := store.ValidateAPIKey(credential.ApiKey)
+		var rateLimitErr *RateLimitError
+		assert.ErrorAs(t, err, &rateLimitErr)
+		assert.Equal(t, 50, limit)
+	})
+
+	t.Run("unknown plan or key", func(t *testing.T) {
+		assert.ErrorIs(t, store.SetCredentialPlan(credential.ID, "platinum"), ErrNotFound)
+		assert.ErrorIs(t, store.SetCredentialPlan("missing", models.PlanSupporter), ErrNotFound)
+		assert.ErrorIs(t, store.UpdatePlan(&models.Plan{Name: "platinum", DailyLimit: 1}), ErrNotFound)
+	})
+}
@@ -0,0 +1,70 @@
+package store
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/josephburgess/breeze/internal/logging"
+	"github.com/josephburgess/breeze/internal/models"
+)
+
+// GetPreferences returns the user's preferences, or empty defaults if they have
+// never saved any. The default location comes from their saved locations.
+func (s *UserStore) GetPreferences(githubUserID int64) (*models.UserPreferences, error) {
+	preferences := models.UserPreferences{GithubUserID: githubUserID}
+
+	err := s.db.Where("github_user_id = ?", githubUserID).First(&preferences).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		logging.Error("Failed to fetch preferences", err)
+		return nil, err
+	}
+
+	location, err := s.GetDefaultLocation(githubUserID)
+	if err != nil {
+		return nil, err
+	}
+	if location != nil {
+		preferences.DefaultLocationID = &location.ID
+	}
+
+	return &preferences, nil
+}
+
+// SavePreferences replaces the user's preferences. If DefaultLocationID is set, that
+// saved location becomes their default.
+func (s *UserStore) SavePreferences(preferences *models.UserPreferences) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(preferences).Error; err != nil {
+			return err
+		}
+
+		if preferences.DefaultLocationID == nil {
+			return nil
+		}
+
+		var location models.SavedLocation
+		err := tx.Where("id = ? AND github_user_id = ?", *preferences.DefaultLocationID, preferences.GithubUserID).First(&location).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := clearDefaultLocation(tx, preferences.GithubUserID); err != nil {
+			return err
+		}
+		return tx.Model(&location).Update("is_default", true).Error
+	})
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			logging.Error("Failed to save preferences", err)
+		}
+		return err
+	}
+
+	logging.Info("Preferences saved for user: %d", preferences.GithubUserID)
+	return nil
+}
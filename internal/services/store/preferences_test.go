@@ -0,0 +1,64 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/josephburgess/breeze/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserStore_Preferences(t *testing.T) {
+	store := setupTestDB(t)
+
+	user := &models.User{GithubID: 456, Login: "prefsuser", Token: "token456"}
+	require.NoError(t, store.SaveUser(user))
+
+	t.Run("defaults when unset", func(t *testing.T) {
+		preferences, err := store.GetPreferences(user.GithubID)
+		require.NoError(t, err)
+		assert.Equal(t, user.GithubID, preferences.GithubUserID)
+		assert.Empty(t, preferences.Units)
+		assert.Nil(t, preferences.DefaultLocationID)
+	})
+
+	home := &models.SavedLocation{GithubUserID: user.GithubID, Name: "Home", Lat: 51.5, Lon: -0.12}
+	work := &models.SavedLocation{GithubUserID: user.GithubID, Name: "Work", Lat: 51.52, Lon: -0.08}
+	require.NoError(t, store.CreateSavedLocation(home))
+	require.NoError(t, store.CreateSavedLocation(work))
+
+	t.Run("save and update", func(t *testing.T) {
+		preferences := &models.UserPreferences{
+			GithubUserID:      user.GithubID,
+			Units:             "metric",
+			Language:          "de",
+			TimeFormat:        "iso8601",
+			Sections:          []string{"current", "daily"},
+			DefaultLocationID: &work.ID,
+		}
+		require.NoError(t, store.SavePreferences(preferences))
+
+		saved, err := store.GetPreferences(user.GithubID)
+		require.NoError(t, err)
+		assert.Equal(t, "metric", saved.Units)
+		assert.Equal(t, "de", saved.Language)
+		assert.Equal(t, []string{"current", "daily"}, saved.Sections)
+		require.NotNil(t, saved.DefaultLocationID)
+		assert.Equal(t, work.ID, *saved.DefaultLocationID)
+
+		preferences.Units = "imperial"
+		preferences.DefaultLocationID = nil
+		require.NoError(t, store.SavePreferences(preferences))
+
+		saved, err = store.GetPreferences(user.GithubID)
+		require.NoError(t, err)
+		assert.Equal(t, "imperial", saved.Units)
+		assert.Equal(t, work.ID, *saved.DefaultLocationID, "default location is kept when not given")
+	})
+
+	t.Run("unknown default location", func(t *testing.T) {
+		missing := uint(9999)
+		err := store.SavePreferences(&models.UserPreferences{GithubUserID: user.GithubID, DefaultLocationID: &missing})
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
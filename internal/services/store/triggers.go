@@ -0,0 +1,116 @@
+package store
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/josephburgess/breeze/internal/logging"
+	"github.com/josephburgess/breeze/internal/models"
+)
+
+func (s *UserStore) CreateTriggerRule(rule *models.TriggerRule) error {
+	if err := s.db.Create(rule).Error; err != nil {
+		logging.Error("Failed to create trigger rule", err)
+		return err
+	}
+
+	logging.Info("Trigger rule %d created for user: %d", rule.ID, rule.GithubUserID)
+	return nil
+}
+
+// ListTriggerRules returns all of a user's rules, whichever of their keys created
+// them.
+func (s *UserStore) ListTriggerRules(githubUserID int64) ([]models.TriggerRule, error) {
+	var rules []models.TriggerRule
+	if err := s.db.Where("github_user_id = ?", githubUserID).Order("id").Find(&rules).Error; err != nil {
+		logging.Error("Failed to list trigger rules", err)
+		return nil, err
+	}
+	return rules, nil
+}
+
+// ListEnabledTriggerRules returns the enabled rules whose keys can still be used, so
+// rules stop being evaluated once the key that created them is revoked, disabled
+// for being idle or expires.
+func (s *UserStore) ListEnabledTriggerRules() ([]models.TriggerRule, error) {
+	var rules []models.TriggerRule
+	if err := s.db.
+		Joins("JOIN api_credentials ON api_credentials.id = trigger_rules.credential_id").
+		Where("trigger_rules.enabled = ? AND api_credentials.revoked_at IS NULL AND (api_credentials.expires_at IS NULL OR api_credentials.expires_at > ?)", true, s.now()).
+		Order("trigger_rules.id").
+		Find(&rules).Error; err != nil {
+		logging.Error("Failed to list trigger rules", err)
+		return nil, err
+	}
+	return rules, nil
+}
+
+// DeleteTriggerRule deletes one of a user's rules and its firings.
+func (s *UserStore) DeleteTriggerRule(githubUserID int64, id uint) error {
+	result := s.db.Where("id = ? AND github_user_id = ?", id, githubUserID).Delete(&models.TriggerRule{})
+	if result.Error != nil {
+		logging.Error("Failed to delete trigger rule", result.Error)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	if err := s.db.Where("rule_id = ?", id).Delete(&models.TriggerFiring{}).Error; err != nil {
+		logging.Error("Failed to delete trigger firings", err)
+	}
+
+	logging.Info("Trigger rule %d deleted", id)
+	return nil
+}
+
+func (s *UserStore) LatestTriggerFiring(ruleID uint) (*models.TriggerFiring, error) {
+	var firing models.TriggerFiring
+	err := s.db.Where("rule_id = ?", ruleID).Order("id DESC").First(&firing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		logging.Error("Failed to fetch latest trigger firing", err)
+		return nil, err
+	}
+	return &firing, nil
+}
+
+// RecordTriggerFiring stores a firing, returning false if the rule has already
+// fired for the same dedup key.
+func (s *UserStore) RecordTriggerFiring(firing *models.TriggerFiring) (bool, error) {
+	if err := s.db.Create(firing).Error; err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint") {
+			return false, nil
+		}
+		logging.Error("Failed to record trigger firing", err)
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *UserStore) UpdateTriggerFiringDelivery(id uint, delivered bool, deliveryError string) error {
+	return s.db.Model(&models.TriggerFiring{}).Where("id = ?", id).Updates(map[string]any{
+		"delivered": delivered,
+		"error":     deliveryError,
+	}).Error
+}
+
+// ListTriggerFirings returns the firings of all of a user's rules since a time.
+func (s *UserStore) ListTriggerFirings(githubUserID int64, since time.Time) ([]models.TriggerFiring, error) {
+	var firings []models.TriggerFiring
+	if err := s.db.
+		Joins("JOIN trigger_rules ON trigger_rules.id = trigger_firings.rule_id").
+		Where("trigger_rules.github_user_id = ? AND trigger_firings.fired_at >= ?", githubUserID, since).
+		Order("trigger_firings.id").
+		Limit(500).
+		Find(&firings).Error; err != nil {
+		logging.Error("Failed to list trigger firings", err)
+		return nil, err
+	}
+	return firings, nil
+}
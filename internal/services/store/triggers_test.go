@@ -0,0 +1,72 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/josephburgess/breeze/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserStore_TriggerRules(t *testing.T) {
+	store := setupTestDB(t)
+
+	require.NoError(t, store.SaveUser(&models.User{GithubID: 123, Login: "testuser", Token: "token"}))
+	require.NoError(t, store.SaveUser(&models.User{GithubID: 456, Login: "other", Token: "token"}))
+
+	laptop, err := store.IssueAPIKey(123, "laptop")
+	require.NoError(t, err)
+	expiresAt := time.Now().Add(time.Hour)
+	temporary, err := store.CreateAPICredential(123, "temporary", models.AllScopes, &expiresAt, models.KeyRestrictions{}, models.PlanFree)
+	require.NoError(t, err)
+	other, err := store.IssueAPIKey(456, DefaultKeyName)
+	require.NoError(t, err)
+
+	create := func(credential *models.ApiCredential, name string) *models.TriggerRule {
+		rule := &models.TriggerRule{CredentialID: credential.ID, GithubUserID: credential.GithubUserID, Name: name, Expression: "hourly.temp < 0", Enabled: true}
+		require.NoError(t, store.CreateTriggerRule(rule))
+		return rule
+	}
+	fromLaptop := create(laptop, "laptop")
+	fromTemporary := create(temporary, "temporary")
+	create(other, "other")
+
+	enabledNames := func() []string {
+		rules, err := store.ListEnabledTriggerRules()
+		require.NoError(t, err)
+		var names []string
+		for _, rule := range rules {
+			names = append(names, rule.Name)
+		}
+		return names
+	}
+	assert.Equal(t, []string{"laptop", "temporary", "other"}, enabledNames())
+
+	t.Run("rules stop with their key", func(t *testing.T) {
+		_, err := store.RotateAPIKey(123, laptop.ID, 0)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"laptop", "temporary", "other"}, enabledNames(), "rotated keys keep their rules")
+
+		store.now = func() time.Time { return expiresAt.Add(time.Minute) }
+		assert.Equal(t, []string{"laptop", "other"}, enabledNames(), "expired keys' rules stop")
+		store.now = time.Now
+
+		require.NoError(t, store.RevokeAPIKey(123, laptop.ID))
+		assert.Equal(t, []string{"temporary", "other"}, enabledNames(), "revoked keys' rules stop")
+	})
+
+	t.Run("owners manage rules from any of their keys", func(t *testing.T) {
+		rules, err := store.ListTriggerRules(123)
+		require.NoError(t, err)
+		assert.Len(t, rules, 2, "including those of revoked keys")
+
+		assert.ErrorIs(t, store.DeleteTriggerRule(456, fromLaptop.ID), ErrNotFound, "other users can't delete them")
+		require.NoError(t, store.DeleteTriggerRule(123, fromLaptop.ID))
+
+		rules, err = store.ListTriggerRules(123)
+		require.NoError(t, err)
+		require.Len(t, rules, 1)
+		assert.Equal(t, fromTemporary.ID, rules[0].ID)
+	})
+}
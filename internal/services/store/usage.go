@@ -0,0 +1,130 @@
+package store
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/josephburgess/breeze/internal/logging"
+	"github.com/josephburgess/breeze/internal/models"
+)
+
+// usageKey identifies a usage record: a key's requests to an endpoint on a day.
+type usageKey struct {
+	credentialID string
+	githubUserID int64
+	day          string
+	endpoint     string
+}
+
+// RecordUsage adds a request by the credential to endpoint to today's usage history.
+// With the usage cache enabled it is written with the next flush.
+func (s *UserStore) RecordUsage(credential *models.ApiCredential, endpoint string) error {
+	key := usageKey{
+		credentialID: credential.ID,
+		githubUserID: credential.GithubUserID,
+		day:          s.now().UTC().Format(time.DateOnly),
+		endpoint:     endpoint,
+	}
+
+	if s.cache != nil {
+		s.cache.mu.Lock()
+		s.cache.records[key]++
+		s.cache.mu.Unlock()
+		return nil
+	}
+
+	if err := addUsage(s.db, map[usageKey]int{key: 1}); err != nil {
+		logging.Error("Failed to record API usage", err)
+		return err
+	}
+	return nil
+}
+
+// addUsage adds counts to the usage records, creating any that don't exist yet.
+func addUsage(tx *gorm.DB, counts map[usageKey]int) error {
+	for key, count := range counts {
+		record := models.UsageRecord{
+			CredentialID: key.credentialID,
+			GithubUserID: key.githubUserID,
+			Day:          key.day,
+			Endpoint:     key.endpoint,
+			Count:        count,
+		}
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "credential_id"}, {Name: "day"}, {Name: "endpoint"}},
+			DoUpdates: clause.Assignments(map[string]any{"count": gorm.Expr("usage_records.count + excluded.count")}),
+		}).Create(&record).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UsageToday returns the requests counted towards the credential's daily limit
+// today, the limit (0 for none) and when the count resets. The credential must have
+// its Plan loaded, as those returned by LookupAPIKey do.
+func (s *UserStore) UsageToday(credential *models.ApiCredential) (int, int, time.Time, error) {
+	now := s.now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	used, err := s.usedToday(credential, today)
+	if err != nil {
+		return 0, 0, time.Time{}, err
+	}
+	return used, credential.Plan.DailyLimit, today.Add(24 * time.Hour), nil
+}
+
+// ListUsage returns a user's requests per day and endpoint across all their keys,
+// for the days from and to inclusive, oldest first.
+func (s *UserStore) ListUsage(githubUserID int64, from, to time.Time) ([]models.UsageRecord, error) {
+	if err := s.flushPendingUsage(); err != nil {
+		return nil, err
+	}
+
+	var records []models.UsageRecord
+	if err := s.db.Model(&models.UsageRecord{}).
+		Select("day, endpoint, SUM(count) AS count").
+		Where("github_user_id = ? AND day BETWEEN ? AND ?", githubUserID, from.Format(time.DateOnly), to.Format(time.DateOnly)).
+		Group("day, endpoint").
+		Order("day, endpoint").
+		Scan(&records).Error; err != nil {
+		logging.Error("Failed to list API usage", err)
+		return nil, err
+	}
+	return records, nil
+}
+
+// ListEndpointUsage returns the requests made to each endpoint by all keys for the
+// days from and to inclusive, busiest first.
+func (s *UserStore) ListEndpointUsage(from, to time.Time) ([]models.EndpointUsage, error) {
+	if err := s.flushPendingUsage(); err != nil {
+		return nil, err
+	}
+
+	var usage []models.EndpointUsage
+	if err := s.db.Model(&models.UsageRecord{}).
+		Select("endpoint, SUM(count) AS requests, COUNT(DISTINCT credential_id) AS keys, COUNT(DISTINCT github_user_id) AS users").
+		Where("day BETWEEN ? AND ?", from.Format(time.DateOnly), to.Format(time.DateOnly)).
+		Group("endpoint").
+		Order("requests DESC, endpoint").
+		Scan(&usage).Error; err != nil {
+		logging.Error("Failed to list endpoint usage", err)
+		return nil, err
+	}
+	return usage, nil
+}
+
+// flushPendingUsage writes usage held in memory so history read from the database
+// includes the latest requests.
+func (s *UserStore) flushPendingUsage() error {
+	if s.cache == nil {
+		return nil
+	}
+	if err := s.flushUsage(); err != nil {
+		logging.Error("Failed to flush API usage", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/josephburgess/breeze/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserStore_UsageHistory(t *testing.T) {
+	for _, cached := range []bool{false, true} {
+		name := "write-through"
+		if cached {
+			name = "cached"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			store, err := NewUserStore(filepath.Join(t.TempDir(), "test.db"), "test-pepper")
+			require.NoError(t, err)
+			defer store.Close()
+			if cached {
+				store.EnableUsageCache(time.Hour)
+			}
+
+			now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+			store.now = func() time.Time { return now }
+
+			require.NoError(t, store.SaveUser(&models.User{GithubID: 5555, Login: "historian", Token: "token"}))
+			require.NoError(t, store.SaveUser(&models.User{GithubID: 6666, Login: "other", Token: "token"}))
+			laptop, err := store.IssueAPIKey(5555, "laptop")
+			require.NoError(t, err)
+			dashboard, err := store.IssueAPIKey(5555, "dashboard")
+			require.NoError(t, err)
+			other, err := store.IssueAPIKey(6666, "laptop")
+			require.NoError(t, err)
+
+			record := func(credential *models.ApiCredential, endpoint string, times int) {
+				for range times {
+					require.NoError(t, store.RecordUsage(credential, endpoint))
+				}
+			}
+
+			record(laptop, "/api/weather/{city}", 3)
+			record(dashboard, "/api/weather/{city}", 2)
+			record(dashboard, "/api/alerts", 1)
+			record(other, "/api/weather/{city}", 4)
+			now = now.AddDate(0, 0, 1)
+			record(laptop, "/api/weather/{city}", 5)
+
+			records, err := store.ListUsage(5555, time.Date(2024, 4, 30, 0, 0, 0, 0, time.UTC), time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC))
+			require.NoError(t, err)
+			assert.Equal(t, []models.UsageRecord{
+				{Day: "2024-05-01", Endpoint: "/api/alerts", Count: 1},
+				{Day: "2024-05-01", Endpoint: "/api/weather/{city}", Count: 5},
+				{Day: "2024-05-02", Endpoint: "/api/weather/{city}", Count: 5},
+			}, records, "keys are combined per day and endpoint")
+
+			records, err = store.ListUsage(5555, time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC), time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC))
+			require.NoError(t, err)
+			assert.Len(t, records, 1)
+
+			endpoints, err := store.ListEndpointUsage(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+			require.NoError(t, err)
+			assert.Equal(t, []models.EndpointUsage{
+				{Endpoint: "/api/weather/{city}", Requests: 9, Keys: 3, Users: 2},
+				{Endpoint: "/api/alerts", Requests: 1, Keys: 1, Users: 1},
+			}, endpoints)
+		})
+	}
+}
+
+func TestUserStore_UsageToday(t *testing.T) {
+	store, err := NewUserStore(filepath.Join(t.TempDir(), "test.db"), "test-pepper")
+	require.NoError(t, err)
+	defer store.Close()
+
+	require.NoError(t, store.SaveUser(&models.User{GithubID: 7777, Login: "counter", Token: "token"}))
+	issued, err := store.IssueAPIKey(7777, "laptop")
+	require.NoError(t, err)
+
+	var credential *models.ApiCredential
+	for range 2 {
+		credential, _, _, _, err = store.ValidateAPIKey(issued.ApiKey)
+		require.NoError(t, err)
+	}
+
+	used, limit, resetTime, err := store.UsageToday(credential)
+	require.NoError(t, err)
+	assert.Equal(t, 2, used)
+	assert.Equal(t, 50, limit)
+	assert.Equal(t, time.Now().UTC().Truncate(24*time.Hour).Add(24*time.Hour), resetTime)
+}
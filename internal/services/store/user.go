@@ -1,26 +1,60 @@
 package store
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"github.com/google/uuid"
 	"github.com/josephburgess/breeze/internal/logging"
 	"github.com/josephburgess/breeze/internal/models"
+	"github.com/josephburgess/breeze/internal/services/ratelimit"
 )
 
+var (
+	ErrNotFound      = errors.New("record not found")
+	ErrAPIKeyRevoked = errors.New("API key has been revoked")
+	ErrAPIKeyExpired = errors.New("API key has expired")
+
+	ErrDuplicateKeyName = errors.New("user already has an API key with that name")
+)
+
+// daily quota scopes, see UserStore.QuotaScope
+const (
+	QuotaPerKey  = "key"
+	QuotaPerUser = "user"
+)
+
+// DefaultKeyName is used for keys issued by signing in.
+const DefaultKeyName = "default"
+
 type UserStore struct {
-	db *gorm.DB
+	db     *gorm.DB
+	pepper []byte
+	burst  *ratelimit.Limiter
+	now    func() time.Time
+	cache  *usageCache
+
+	// QuotaScope decides whether the daily limit applies to each key separately or
+	// to all of a user's keys together. Defaults to per key.
+	QuotaScope string
 }
 
-func NewUserStore(dbPath string) (*UserStore, error) {
+// NewUserStore opens the database at dbPath. pepper is the server secret API keys are
+// hashed with; changing it invalidates every issued key.
+func NewUserStore(dbPath string, pepper string) (*UserStore, error) {
 	if dbPath == "" {
 		dbPath = "gust.db"
 	}
@@ -36,17 +70,75 @@ func NewUserStore(dbPath string) (*UserStore, error) {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	if err := db.AutoMigrate(&models.User{}, &models.ApiCredential{}); err != nil {
+	if err := db.AutoMigrate(
+		&models.User{},
+		&models.Plan{},
+		&models.ApiCredential{},
+		&models.WebhookSubscription{},
+		&models.WebhookDelivery{},
+		&models.TriggerRule{},
+		&models.TriggerFiring{},
+		&models.SavedLocation{},
+		&models.UserPreferences{},
+		&models.AuditEntry{},
+		&models.UsageRecord{},
+		&models.OAuthState{},
+		&models.DeviceAuthorization{},
+		&models.AccountSequence{},
+	); err != nil {
 		logging.Error("Failed to migrate models", err)
 		return nil, fmt.Errorf("failed to migrate models: %w", err)
 	}
 
+	if err := seedPlans(db); err != nil {
+		logging.Error("Failed to create default plans", err)
+		return nil, fmt.Errorf("failed to create default plans: %w", err)
+	}
+
 	if err := initializeExistingRecords(db); err != nil {
 		logging.Error("Failed to initialize existing records", err)
 		return nil, fmt.Errorf("failed to initialize existing records: %w", err)
 	}
 
-	return &UserStore{db: db}, nil
+	if err := seedAccountSequence(db); err != nil {
+		logging.Error("Failed to create account ID sequence", err)
+		return nil, fmt.Errorf("failed to create account ID sequence: %w", err)
+	}
+
+	// users were limited to a single key before keys were named
+	if db.Migrator().HasConstraint(&models.ApiCredential{}, "uni_api_credentials_github_user_id") {
+		if err := db.Migrator().DropConstraint(&models.ApiCredential{}, "uni_api_credentials_github_user_id"); err != nil {
+			logging.Error("Failed to allow multiple API keys per user", err)
+			return nil, fmt.Errorf("failed to allow multiple API keys per user: %w", err)
+		}
+	}
+
+	store := &UserStore{
+		db:         db,
+		pepper:     []byte(pepper),
+		burst:      ratelimit.NewLimiter(time.Second),
+		now:        time.Now,
+		QuotaScope: QuotaPerKey,
+	}
+
+	if err := store.hashPlaintextKeys(); err != nil {
+		logging.Error("Failed to hash existing API keys", err)
+		return nil, fmt.Errorf("failed to hash existing API keys: %w", err)
+	}
+
+	// daily limits used to be stored on each credential, they come from its plan now
+	if db.Migrator().HasColumn(&models.ApiCredential{}, "rate_limit_per_day") {
+		if err := migrateCredentialLimits(db); err != nil {
+			logging.Error("Failed to move per-credential rate limits to plans", err)
+			return nil, fmt.Errorf("failed to move per-credential rate limits to plans: %w", err)
+		}
+		if err := db.Migrator().DropColumn(&models.ApiCredential{}, "rate_limit_per_day"); err != nil {
+			logging.Error("Failed to drop per-credential rate limits", err)
+			return nil, fmt.Errorf("failed to drop per-credential rate limits: %w", err)
+		}
+	}
+
+	return store, nil
 }
 
 func initializeExistingRecords(db *gorm.DB) error {
@@ -62,10 +154,102 @@ func initializeExistingRecords(db *gorm.DB) error {
 		}
 	}
 
+	// users were identified by their GitHub ID alone before other providers
+	if err := db.Model(&models.User{}).
+		Where("subject IS NULL OR subject = ''").
+		UpdateColumn("subject", gorm.Expr("CAST(github_id AS TEXT)")).Error; err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// the sequence accounts from providers other than GitHub take their IDs from
+const accountSequence = "users"
+
+// seedAccountSequence starts the account ID sequence below any accounts that were
+// given negative IDs before there was one.
+func seedAccountSequence(db *gorm.DB) error {
+	var lowest int64
+	if err := db.Model(&models.User{}).Select("COALESCE(MIN(github_id), 0)").Scan(&lowest).Error; err != nil {
+		return err
+	}
+	return db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.AccountSequence{Name: accountSequence, Next: min(lowest, 0) - 1}).Error
+}
+
+// nextAccountID takes the next ID from the account ID sequence. The decrement is a
+// single UPDATE, so concurrent sign-ins can't be given the same ID, and IDs aren't
+// reused after their accounts are deleted.
+func nextAccountID(tx *gorm.DB) (int64, error) {
+	var ids []int64
+	if err := tx.Raw("UPDATE account_sequences SET next = next - 1 WHERE name = ? RETURNING next + 1", accountSequence).
+		Scan(&ids).Error; err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, fmt.Errorf("account ID sequence %q is missing", accountSequence)
+	}
+	return ids[0], nil
+}
+
+// hashPlaintextKeys migrates credentials from before keys were hashed, when the
+// plaintext key was stored in api_key and doubled as the credential ID. Each one
+// gets its hash and prefix stored and a new random ID, then the column is dropped.
+func (s *UserStore) hashPlaintextKeys() error {
+	migrator := s.db.Migrator()
+	if !migrator.HasColumn(&models.ApiCredential{}, "api_key") {
+		return nil
+	}
+
+	var legacy []struct {
+		ID     string
+		ApiKey string
+	}
+	if err := s.db.Table("api_credentials").Select("id, api_key").Scan(&legacy).Error; err != nil {
+		return err
+	}
+
+	logging.Info("Hashing %d plaintext API keys", len(legacy))
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		for _, credential := range legacy {
+			id := uuid.New().String()
+
+			if err := tx.Table("api_credentials").Where("id = ?", credential.ID).Updates(map[string]any{
+				"id":         id,
+				"key_hash":   s.hashAPIKey(credential.ApiKey),
+				"key_prefix": KeyPrefix(credential.ApiKey),
+			}).Error; err != nil {
+				return err
+			}
+
+			if err := tx.Model(&models.TriggerRule{}).Where("credential_id = ?", credential.ID).
+				Update("credential_id", id).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// sqlite can't drop a column that still has a unique constraint
+	if migrator.HasConstraint(&models.ApiCredential{}, "uni_api_credentials_api_key") {
+		if err := migrator.DropConstraint(&models.ApiCredential{}, "uni_api_credentials_api_key"); err != nil {
+			return err
+		}
+	}
+
+	return migrator.DropColumn(&models.ApiCredential{}, "api_key")
+}
+
 func (s *UserStore) Close() error {
+	if err := s.closeCache(); err != nil {
+		logging.Error("Failed to flush API usage", err)
+	}
+
 	sqlDB, err := s.db.DB()
 	if err != nil {
 		logging.Warn("Failed closing db", err)
@@ -73,25 +257,47 @@ func (s *UserStore) Close() error {
 	return sqlDB.Close()
 }
 
+// SaveUser creates or updates the user signed in with user.Provider as
+// user.Subject, and sets user.GithubID to their account ID. Users of providers other
+// than GitHub get the next negative account ID from the account ID sequence when
+// they first sign in, so they can't clash with GitHub users. Users without a provider are GitHub users.
 func (s *UserStore) SaveUser(user *models.User) error {
-	logging.Info("Saving user: %d (%s)", user.GithubID, user.Login)
-
-	var existingUser models.User
-	result := s.db.Where("github_id = ?", user.GithubID).First(&existingUser)
-
-	if result.Error == nil {
-		existingUser.Login = user.Login
-		existingUser.Name = user.Name
-		existingUser.Email = user.Email
-		existingUser.Token = user.Token
-		existingUser.LastLogin = time.Now()
-
-		return s.db.Save(&existingUser).Error
-	} else if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-		return s.db.Create(user).Error
-	} else {
-		return result.Error
+	if user.Provider == "" {
+		user.Provider = models.ProviderGitHub
+	}
+	if user.Provider == models.ProviderGitHub && user.Subject == "" {
+		user.Subject = strconv.FormatInt(user.GithubID, 10)
 	}
+
+	logging.Info("Saving user: %s %s (%s)", user.Provider, user.Subject, user.Login)
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var existingUser models.User
+		result := tx.Where("provider = ? AND subject = ?", user.Provider, user.Subject).First(&existingUser)
+
+		if result.Error == nil {
+			existingUser.Login = user.Login
+			existingUser.Name = user.Name
+			existingUser.Email = user.Email
+			existingUser.Token = user.Token
+			existingUser.LastLogin = time.Now()
+			user.GithubID = existingUser.GithubID
+
+			s.forgetCredentials()
+			return tx.Save(&existingUser).Error
+		} else if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			if user.Provider != models.ProviderGitHub {
+				id, err := nextAccountID(tx)
+				if err != nil {
+					return err
+				}
+				user.GithubID = id
+			}
+			return tx.Create(user).Error
+		} else {
+			return result.Error
+		}
+	})
 }
 
 func (s *UserStore) GetUser(githubID int64) (*models.User, error) {
@@ -108,39 +314,52 @@ func (s *UserStore) GetUser(githubID int64) (*models.User, error) {
 	return &user, nil
 }
 
-func (s *UserStore) GetOrCreateAPICredential(githubUserID int64) (*models.ApiCredential, error) {
-	var count int64
-	if err := s.db.Model(&models.User{}).Where("github_id = ?", githubUserID).Count(&count).Error; err != nil {
-		logging.Error("db error while checking for user", err)
-		return nil, err
-	}
-	if count == 0 {
-		logging.Warn("user with ID %d not found", githubUserID)
-		return nil, fmt.Errorf("user with ID %d not found", githubUserID)
-	}
+// IssueAPIKey gives the user a new credential for a sign-in, called name or, if they
+// already have a key by that name, the first free name-2, name-3 and so on. Keys the
+// user already has are left alone, so signing in on another machine doesn't break
+// the key used on the first, and revoked keys stay revoked. Keys are only stored
+// hashed, so the returned credential is the only place the plaintext key is
+// available.
+func (s *UserStore) IssueAPIKey(githubUserID int64, name string) (*models.ApiCredential, error) {
+	// another sign-in can take the free name first, in which case try the next
+	for range 3 {
+		var names []string
+		if err := s.db.Model(&models.ApiCredential{}).
+			Where(`github_user_id = ? AND (name = ? OR name LIKE ? ESCAPE '\')`, githubUserID, name, escapeLike(name)+"-%").
+			Pluck("name", &names).Error; err != nil {
+			logging.Error("Failed to list API credential names", err)
+			return nil, err
+		}
 
-	var credential models.ApiCredential
-	err := s.db.Where("github_user_id = ?", githubUserID).First(&credential).Error
+		credential, err := s.CreateAPICredential(githubUserID, freeKeyName(name, names), models.AllScopes, nil, models.KeyRestrictions{}, models.PlanFree)
+		if !errors.Is(err, ErrDuplicateKeyName) {
+			return credential, err
+		}
+	}
+	return nil, ErrDuplicateKeyName
+}
 
-	if err == nil {
-		logging.Info("Found existing API credential for user: %d", githubUserID)
+// freeKeyName returns name, or the first of name-2, name-3 and so on not in taken.
+// escapeLike escapes the LIKE wildcards in s so that it only matches itself.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
 
-		if err := s.db.Model(&credential).Updates(map[string]any{
-			"last_used": gorm.Expr("CURRENT_TIMESTAMP"),
-		}).Error; err != nil {
-			logging.Error("Failed to update API key last used timestamp", err)
+func freeKeyName(name string, taken []string) string {
+	if !slices.Contains(taken, name) {
+		return name
+	}
+	for n := 2; ; n++ {
+		candidate := fmt.Sprintf("%s-%d", name, n)
+		if !slices.Contains(taken, candidate) {
+			return candidate
 		}
-
-		return &credential, nil
-	} else if errors.Is(err, gorm.ErrRecordNotFound) {
-		return s.CreateAPICredential(githubUserID)
-	} else {
-		logging.Error("Database error while checking for credentials", err)
-		return nil, err
 	}
 }
 
-func (s *UserStore) CreateAPICredential(githubUserID int64) (*models.ApiCredential, error) {
+// CreateAPICredential adds a named key on plan for the user, limited to scopes and
+// restrictions and optionally expiring at expiresAt. Names are unique per user.
+func (s *UserStore) CreateAPICredential(githubUserID int64, name string, scopes []string, expiresAt *time.Time, restrictions models.KeyRestrictions, plan string) (*models.ApiCredential, error) {
 	var count int64
 	if err := s.db.Model(&models.User{}).Where("github_id = ?", githubUserID).Count(&count).Error; err != nil {
 		logging.Error("db error while checking for user", err)
@@ -153,78 +372,237 @@ func (s *UserStore) CreateAPICredential(githubUserID int64) (*models.ApiCredenti
 
 	apiKey := generateAPIKey()
 	apiCredential := &models.ApiCredential{
-		ID:              apiKey,
+		ID:              uuid.New().String(),
 		GithubUserID:    githubUserID,
+		Name:            name,
+		KeyHash:         s.hashAPIKey(apiKey),
+		KeyPrefix:       KeyPrefix(apiKey),
 		ApiKey:          apiKey,
+		ExpiresAt:       expiresAt,
+		Scopes:          scopes,
+		KeyRestrictions: restrictions,
 		DailyResetAt:    time.Now().UTC(),
-		RateLimitPerDay: 50,
+		PlanName:        plan,
 	}
 
 	if err := s.db.Create(apiCredential).Error; err != nil {
-		logging.Error("Failed to create API credential", err)
 		if strings.Contains(err.Error(), "UNIQUE constraint") {
-			return nil, fmt.Errorf("user already has an API key")
+			return nil, ErrDuplicateKeyName
 		}
+		logging.Error("Failed to create API credential", err)
 		return nil, err
 	}
 
-	logging.Info("API credential created for user: %d", githubUserID)
+	logging.Info("API credential %q created for user: %d", name, githubUserID)
 	return apiCredential, nil
 }
 
-func (s *UserStore) ValidateAPIKey(apiKey string) (*models.User, int, int, time.Time, error) {
+// ValidateAPIKey checks a key and counts the request against its plan's daily limit,
+// which is returned as 0 for plans without one. It is LookupAPIKey followed by
+// CountAPIRequest, for callers with nothing to check in between.
+func (s *UserStore) ValidateAPIKey(apiKey string) (*models.ApiCredential, int, int, time.Time, error) {
+	credential, err := s.LookupAPIKey(apiKey)
+	if err != nil {
+		return nil, 0, 0, time.Time{}, err
+	}
+
+	limit, used, resetTime, err := s.CountAPIRequest(credential)
+	if err != nil {
+		return nil, limit, used, resetTime, err
+	}
+	return credential, limit, used, resetTime, nil
+}
+
+// LookupAPIKey returns the credential for a key, with its User and Plan preloaded,
+// without counting a request against it. A key replaced by rotation keeps working
+// until its grace period ends, after which ErrAPIKeyExpired is returned; revoked keys
+// return ErrAPIKeyRevoked.
+func (s *UserStore) LookupAPIKey(apiKey string) (*models.ApiCredential, error) {
 	logging.Info("Validating API key")
 
-	var credential models.ApiCredential
-	if err := s.db.Where("api_key = ?", apiKey).First(&credential).Error; err != nil {
+	hash := s.hashAPIKey(apiKey)
+
+	credential, err := s.findCredential(hash)
+	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			logging.Warn("Invalid API key")
-			return nil, 0, 0, time.Time{}, fmt.Errorf("invalid API key")
+			return nil, fmt.Errorf("invalid API key")
 		}
 		logging.Error("Database error", err)
-		return nil, 0, 0, time.Time{}, err
+		return nil, err
 	}
 
-	now := time.Now().UTC()
-	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if credential.RevokedAt != nil {
+		logging.Warn("Revoked API key used: %s...", credential.KeyPrefix)
+		return nil, ErrAPIKeyRevoked
+	}
 
-	resetTime := today.Add(24 * time.Hour)
-	if !credential.DailyResetAt.IsZero() {
-		resetTime = credential.DailyResetAt.Add(24 * time.Hour)
+	now := s.now().UTC()
+
+	if credential.KeyHash != hash && (credential.PreviousExpiresAt == nil || !now.Before(*credential.PreviousExpiresAt)) {
+		logging.Warn("Rotated API key used after its grace period: %s", credential.ID)
+		return nil, ErrAPIKeyExpired
+	}
+
+	if credential.ExpiresAt != nil && !now.Before(*credential.ExpiresAt) {
+		logging.Warn("Expired API key used: %s", credential.ID)
+		return nil, ErrAPIKeyExpired
+	}
+
+	// a credential whose plan has been deleted falls back to the free plan's defaults
+	// rather than having no limits
+	if credential.Plan.Name == "" {
+		logging.Warn("Plan %q not found for API credential %s, using free plan defaults", credential.PlanName, credential.ID)
+		credential.Plan = models.DefaultPlans[0]
 	}
 
-	updates := map[string]any{
-		"last_used":     now,
-		"request_count": gorm.Expr("request_count + 1"),
+	if credential.User.ID == 0 {
+		logging.Warn("user not found for API credential: %d", credential.GithubUserID)
+		return nil, fmt.Errorf("user with ID %d not found", credential.GithubUserID)
 	}
 
-	if credential.DailyResetAt.IsZero() || credential.DailyResetAt.Before(today) {
-		updates["daily_request_count"] = 1
-		updates["daily_reset_at"] = today
+	return &credential, nil
+}
+
+// CountAPIRequest counts a request against the credential's plan limits, returning
+// the daily limit (0 for plans without one), the requests used today and when the
+// count resets. A RateLimitError is returned if the request doesn't fit, in which
+// case it isn't counted. Credentials are those returned by LookupAPIKey.
+func (s *UserStore) CountAPIRequest(credential *models.ApiCredential) (int, int, time.Time, error) {
+	now := s.now().UTC()
+
+	// daily counts reset at midnight UTC
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	resetTime := today.Add(24 * time.Hour)
+
+	// usage is read here to reject keys that are clearly over their limit and to
+	// report usage, but only countRequest decides whether a request fits
+	used, err := s.usedToday(credential, today)
+	if err != nil {
+		return 0, 0, time.Time{}, err
+	}
 
-		credential.DailyRequestCount = 1
-		credential.DailyResetAt = today
-	} else {
-		if credential.DailyRequestCount >= credential.RateLimitPerDay {
-			return nil, credential.RateLimitPerDay, credential.DailyRequestCount, resetTime, &RateLimitError{
-				Message:   fmt.Sprintf("rate limit exceeded: maximum of %d requests per day", credential.RateLimitPerDay),
-				ResetTime: resetTime,
-				RateLimit: credential.RateLimitPerDay,
-				Remaining: 0,
+	limit := credential.Plan.DailyLimit
+	if limit > 0 && used >= limit {
+		return limit, used, resetTime, dailyLimitError(limit, resetTime, now)
+	}
+
+	// the plan's burst limit stops the day's quota being used all at once
+	if burst := credential.Plan.BurstLimit; burst > 0 {
+		bucket := credential.ID
+		if s.QuotaScope == QuotaPerUser {
+			bucket = fmt.Sprintf("user:%d", credential.GithubUserID)
+		}
+		if ok, _, retryAfter := s.burst.Allow(bucket, burst, now); !ok {
+			logging.Warn("API key %s... exceeded its burst limit of %d", credential.KeyPrefix, burst)
+			return limit, used, resetTime, &RateLimitError{
+				Message:    fmt.Sprintf("rate limit exceeded: maximum burst of %d requests, slow down", burst),
+				ResetTime:  resetTime,
+				RateLimit:  limit,
+				Remaining:  max(limit-used, 0),
+				RetryAfter: retryAfter,
+				Burst:      true,
 			}
 		}
-
-		updates["daily_request_count"] = gorm.Expr("daily_request_count + 1")
-		credential.DailyRequestCount++
 	}
 
-	if err := s.db.Model(&credential).Updates(updates).Error; err != nil {
+	counted, err := s.countRequest(credential, today, now, limit)
+	if err != nil {
 		logging.Error("Failed to update API key usage", err)
-		return nil, 0, 0, time.Time{}, fmt.Errorf("failed to update API key usage: %w", err)
+		return 0, 0, time.Time{}, fmt.Errorf("failed to update API key usage: %w", err)
+	}
+	if !counted {
+		// other requests used up the limit since usage was read
+		return limit, limit, resetTime, dailyLimitError(limit, resetTime, now)
+	}
+
+	used, err = s.usedToday(credential, today)
+	if err != nil {
+		return 0, 0, time.Time{}, err
 	}
 
-	user, err := s.GetUser(credential.GithubUserID)
-	return user, credential.RateLimitPerDay, credential.DailyRequestCount, resetTime, err
+	return limit, used, resetTime, nil
+}
+
+// countedTodaySQL is a credential's request count for today, given as @today.
+const countedTodaySQL = "CASE WHEN daily_reset_at >= @today THEN daily_request_count ELSE 0 END"
+
+// countRequest counts a request against the credential's daily usage unless that
+// would take it over limit (0 for no limit). The check and the increment are a
+// single UPDATE, so concurrent requests can't all pass the check before any of them
+// is counted. It reports whether the request was counted.
+func (s *UserStore) countRequest(credential *models.ApiCredential, today, now time.Time, limit int) (bool, error) {
+	if s.cache != nil {
+		return s.cachedCountRequest(credential, today, now, limit)
+	}
+
+	others := "0"
+	if s.QuotaScope == QuotaPerUser {
+		others = "(SELECT COALESCE(SUM(other.daily_request_count), 0) FROM api_credentials other " +
+			"WHERE other.github_user_id = @user AND other.id <> @id AND other.daily_reset_at >= @today)"
+	}
+
+	var counts []int
+	if err := s.db.Raw(
+		"UPDATE api_credentials SET "+
+			"daily_request_count = "+countedTodaySQL+" + 1, "+
+			"daily_reset_at = @today, "+
+			"request_count = request_count + 1, "+
+			"last_used = @now, "+
+			// using a key clears any warning that it was about to be disabled for being idle
+			"idle_flagged_at = NULL "+
+			"WHERE id = @id AND (@limit <= 0 OR "+countedTodaySQL+" + "+others+" < @limit) "+
+			"RETURNING daily_request_count",
+		map[string]any{"id": credential.ID, "user": credential.GithubUserID, "today": today, "now": now, "limit": limit},
+	).Scan(&counts).Error; err != nil {
+		return false, err
+	}
+	if len(counts) == 0 {
+		return false, nil
+	}
+
+	credential.DailyRequestCount = counts[0]
+	credential.DailyResetAt = today
+	credential.RequestCount++
+	credential.LastUsed = &now
+	credential.IdleFlaggedAt = nil
+	return true, nil
+}
+
+// usedToday returns the requests made today that count towards the credential's
+// daily limit: its own, and with a per-user quota, those of the user's other keys.
+func (s *UserStore) usedToday(credential *models.ApiCredential, today time.Time) (int, error) {
+	if s.cache != nil {
+		return s.cachedUsedToday(credential, today)
+	}
+
+	used := 0
+	if !credential.DailyResetAt.Before(today) {
+		used = credential.DailyRequestCount
+	}
+	if s.QuotaScope != QuotaPerUser {
+		return used, nil
+	}
+
+	var others int
+	if err := s.db.Model(&models.ApiCredential{}).
+		Where("github_user_id = ? AND id <> ? AND daily_reset_at >= ?", credential.GithubUserID, credential.ID, today).
+		Select("COALESCE(SUM(daily_request_count), 0)").
+		Scan(&others).Error; err != nil {
+		logging.Error("Failed to count user's API usage", err)
+		return 0, err
+	}
+	return used + others, nil
+}
+
+func dailyLimitError(limit int, resetTime, now time.Time) *RateLimitError {
+	return &RateLimitError{
+		Message:    fmt.Sprintf("rate limit exceeded: maximum of %d requests per day", limit),
+		ResetTime:  resetTime,
+		RateLimit:  limit,
+		Remaining:  0,
+		RetryAfter: resetTime.Sub(now),
+	}
 }
 
 type RateLimitError struct {
@@ -232,6 +610,10 @@ type RateLimitError struct {
 	ResetTime time.Time
 	RateLimit int
 	Remaining int
+	// how long until a request would be allowed again
+	RetryAfter time.Duration
+	// whether the burst limit, rather than the daily limit, was hit
+	Burst bool
 }
 
 func (e *RateLimitError) Error() string {
@@ -240,6 +622,23 @@ func (e *RateLimitError) Error() string {
 
 func generateAPIKey() string {
 	apiKey := fmt.Sprintf("gust_%s", uuid.New().String())
-	logging.Info("Generated API key: %s", apiKey)
+	logging.Info("Generated API key: %s...", KeyPrefix(apiKey))
 	return apiKey
 }
+
+// hashAPIKey returns the keyed hash API keys are stored and looked up by.
+func (s *UserStore) hashAPIKey(apiKey string) string {
+	mac := hmac.New(sha256.New, s.pepper)
+	mac.Write([]byte(apiKey))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// KeyPrefix returns the start of a key, enough for a user to recognise it without
+// revealing it.
+func KeyPrefix(apiKey string) string {
+	const length = len("gust_") + 8
+	if len(apiKey) <= length {
+		return apiKey
+	}
+	return apiKey[:length]
+}
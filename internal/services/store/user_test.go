@@ -1,16 +1,23 @@
 package store
 
 import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/josephburgess/breeze/internal/models"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
 )
 
 func setupTestDB(t *testing.T) *UserStore {
-	store, err := NewUserStore("file::memory:?cache=shared")
+	store, err := NewUserStore("file::memory:?cache=shared", "test-pepper")
 	require.NoError(t, err)
 	require.NotNil(t, store)
 
@@ -72,7 +79,100 @@ func TestUserStore_SaveUser(t *testing.T) {
 	}
 }
 
-func TestUserStore_GetOrCreateAPICredential(t *testing.T) {
+func TestUserStore_SaveUser_Identities(t *testing.T) {
+	store := setupTestDB(t)
+
+	github := &models.User{GithubID: 123, Login: "octocat", Token: "gh-token"}
+	require.NoError(t, store.SaveUser(github))
+	assert.Equal(t, models.ProviderGitHub, github.Provider)
+	assert.Equal(t, "123", github.Subject)
+
+	alice := &models.User{Provider: "keycloak", Subject: "f3b5c9e2", Login: "alice", Token: "kc-token"}
+	require.NoError(t, store.SaveUser(alice))
+	assert.Equal(t, int64(-1), alice.GithubID, "accounts from other providers get negative IDs")
+
+	bob := &models.User{Provider: "authentik", Subject: "f3b5c9e2", Login: "bob", Token: "ak-token"}
+	require.NoError(t, store.SaveUser(bob))
+	assert.Equal(t, int64(-2), bob.GithubID, "the same subject at another provider is someone else")
+
+	again := &models.User{Provider: "keycloak", Subject: "f3b5c9e2", Login: "alice2", Token: "kc-token2"}
+	require.NoError(t, store.SaveUser(again))
+	assert.Equal(t, alice.GithubID, again.GithubID, "signing in again links to the same account")
+
+	saved, err := store.GetUser(alice.GithubID)
+	require.NoError(t, err)
+	assert.Equal(t, "alice2", saved.Login)
+	assert.Equal(t, "keycloak", saved.Provider)
+
+	_, err = store.IssueAPIKey(alice.GithubID, DefaultKeyName)
+	assert.NoError(t, err)
+
+	var count int64
+	require.NoError(t, store.db.Model(&models.User{}).Count(&count).Error)
+	assert.Equal(t, int64(3), count)
+
+	require.NoError(t, store.db.Where("github_id = ?", bob.GithubID).Delete(&models.User{}).Error)
+	carol := &models.User{Provider: "keycloak", Subject: "0c1d2e3f", Login: "carol", Token: "kc-token"}
+	require.NoError(t, store.SaveUser(carol))
+	assert.Equal(t, int64(-3), carol.GithubID, "account IDs aren't reused")
+}
+
+func TestNewUserStore_SeedsAccountSequence(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewUserStore(dbPath, "test-pepper")
+	require.NoError(t, err)
+	// an account given its ID before there was a sequence
+	require.NoError(t, store.db.Create(&models.User{GithubID: -7, Provider: "keycloak", Subject: "old", Login: "old", Token: "token"}).Error)
+	require.NoError(t, store.db.Where("name = ?", accountSequence).Delete(&models.AccountSequence{}).Error)
+	require.NoError(t, store.Close())
+
+	store, err = NewUserStore(dbPath, "test-pepper")
+	require.NoError(t, err)
+	defer store.Close()
+
+	user := &models.User{Provider: "keycloak", Subject: "new", Login: "new", Token: "token"}
+	require.NoError(t, store.SaveUser(user))
+	assert.Equal(t, int64(-8), user.GithubID)
+}
+
+func TestNewUserStore_LinksExistingUsersToGitHub(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "legacy.db")
+
+	// users before sign-in providers
+	type legacyUser struct {
+		ID       uint  `gorm:"primaryKey"`
+		GithubID int64 `gorm:"unique;not null"`
+		Login    string
+		Token    string
+	}
+
+	legacy, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, legacy.Table("users").AutoMigrate(&legacyUser{}))
+	require.NoError(t, legacy.Table("users").Create(&legacyUser{GithubID: 123, Login: "first", Token: "token"}).Error)
+	require.NoError(t, legacy.Table("users").Create(&legacyUser{GithubID: 456, Login: "second", Token: "token"}).Error)
+	sqlDB, err := legacy.DB()
+	require.NoError(t, err)
+	require.NoError(t, sqlDB.Close())
+
+	store, err := NewUserStore(dbPath, "test-pepper")
+	require.NoError(t, err)
+	defer store.Close()
+
+	user, err := store.GetUser(456)
+	require.NoError(t, err)
+	assert.Equal(t, models.ProviderGitHub, user.Provider)
+	assert.Equal(t, "456", user.Subject)
+
+	again := &models.User{GithubID: 123, Login: "first", Token: "new-token"}
+	require.NoError(t, store.SaveUser(again))
+	var count int64
+	require.NoError(t, store.db.Model(&models.User{}).Count(&count).Error)
+	assert.Equal(t, int64(2), count, "existing users sign in to their own accounts")
+}
+
+func TestUserStore_IssueAPIKey(t *testing.T) {
 	store := setupTestDB(t)
 
 	user := &models.User{
@@ -83,24 +183,71 @@ func TestUserStore_GetOrCreateAPICredential(t *testing.T) {
 	require.NoError(t, store.SaveUser(user))
 
 	t.Run("create new credential", func(t *testing.T) {
-		cred, err := store.GetOrCreateAPICredential(user.GithubID)
+		cred, err := store.IssueAPIKey(user.GithubID, DefaultKeyName)
 		require.NoError(t, err)
 		assert.NotEmpty(t, cred.ApiKey)
 		assert.Equal(t, user.GithubID, cred.GithubUserID)
 	})
 
-	t.Run("get existing credential", func(t *testing.T) {
-		cred1, err := store.GetOrCreateAPICredential(user.GithubID)
+	t.Run("sign in again issues another credential", func(t *testing.T) {
+		other := &models.User{GithubID: 124, Login: "other", Token: "token124"}
+		require.NoError(t, store.SaveUser(other))
+
+		cred1, err := store.IssueAPIKey(other.GithubID, DefaultKeyName)
+		require.NoError(t, err)
+		cred2, err := store.IssueAPIKey(other.GithubID, DefaultKeyName)
 		require.NoError(t, err)
 
-		cred2, err := store.GetOrCreateAPICredential(user.GithubID)
+		assert.NotEqual(t, cred1.ID, cred2.ID)
+		assert.Equal(t, DefaultKeyName, cred1.Name)
+		assert.Equal(t, DefaultKeyName+"-2", cred2.Name)
+
+		_, _, _, _, err = store.ValidateAPIKey(cred1.ApiKey)
+		assert.NoError(t, err, "earlier keys keep working")
+		_, _, _, _, err = store.ValidateAPIKey(cred2.ApiKey)
+		assert.NoError(t, err)
+
+		require.NoError(t, store.RevokeAPIKey(other.GithubID, cred2.ID))
+		cred3, err := store.IssueAPIKey(other.GithubID, DefaultKeyName)
 		require.NoError(t, err)
+		assert.Equal(t, DefaultKeyName+"-3", cred3.Name)
+
+		_, _, _, _, err = store.ValidateAPIKey(cred2.ApiKey)
+		assert.Error(t, err, "revoked keys stay revoked")
+	})
+
+	t.Run("names with LIKE wildcards only match themselves", func(t *testing.T) {
+		assert.Equal(t, `100\%\_a\\b`, escapeLike(`100%_a\b`))
 
-		assert.Equal(t, cred1.ApiKey, cred2.ApiKey)
+		for _, name := range []string{"a_b", "axb-2", `100%`, "100x-2"} {
+			_, err := store.CreateAPICredential(user.GithubID, name, models.AllScopes, nil, models.KeyRestrictions{}, models.PlanFree)
+			require.NoError(t, err)
+		}
+
+		cred, err := store.IssueAPIKey(user.GithubID, "a_b")
+		require.NoError(t, err)
+		assert.Equal(t, "a_b-2", cred.Name)
+		cred, err = store.IssueAPIKey(user.GithubID, `100%`)
+		require.NoError(t, err)
+		assert.Equal(t, `100%-2`, cred.Name)
+	})
+
+	t.Run("only the hash is stored", func(t *testing.T) {
+		cred, err := store.IssueAPIKey(user.GithubID, DefaultKeyName)
+		require.NoError(t, err)
+
+		var stored models.ApiCredential
+		require.NoError(t, store.db.Where("id = ?", cred.ID).First(&stored).Error)
+		assert.Empty(t, stored.ApiKey)
+		assert.NotEqual(t, cred.ApiKey, stored.KeyHash)
+		assert.NotContains(t, stored.KeyHash, cred.ApiKey)
+		assert.Equal(t, store.hashAPIKey(cred.ApiKey), stored.KeyHash)
+		assert.True(t, strings.HasPrefix(cred.ApiKey, stored.KeyPrefix))
+		assert.Len(t, stored.KeyPrefix, len("gust_")+8)
 	})
 
 	t.Run("non-existent user", func(t *testing.T) {
-		_, err := store.GetOrCreateAPICredential(999)
+		_, err := store.IssueAPIKey(999, DefaultKeyName)
 		assert.Error(t, err)
 	})
 }
@@ -115,18 +262,35 @@ func TestUserStore_ValidateAPIKey(t *testing.T) {
 	}
 	require.NoError(t, store.SaveUser(user))
 
-	cred, err := store.GetOrCreateAPICredential(user.GithubID)
+	cred, err := store.IssueAPIKey(user.GithubID, DefaultKeyName)
 	require.NoError(t, err)
 
 	t.Run("valid api key", func(t *testing.T) {
-		validatedUser, limit, used, resetTime, err := store.ValidateAPIKey(cred.ApiKey)
+		validatedCred, limit, used, resetTime, err := store.ValidateAPIKey(cred.ApiKey)
 		require.NoError(t, err)
-		assert.Equal(t, user.GithubID, validatedUser.GithubID)
+		assert.Equal(t, user.GithubID, validatedCred.User.GithubID)
 		assert.Greater(t, limit, 0)
 		assert.GreaterOrEqual(t, used, 1)
 		assert.False(t, resetTime.IsZero())
 	})
 
+	t.Run("lookup doesn't count", func(t *testing.T) {
+		looked, err := store.LookupAPIKey(cred.ApiKey)
+		require.NoError(t, err)
+		used, _, _, err := store.UsageToday(looked)
+		require.NoError(t, err)
+
+		_, err = store.LookupAPIKey(cred.ApiKey)
+		require.NoError(t, err)
+		after, _, _, err := store.UsageToday(looked)
+		require.NoError(t, err)
+		assert.Equal(t, used, after)
+
+		_, counted, _, err := store.CountAPIRequest(looked)
+		require.NoError(t, err)
+		assert.Equal(t, used+1, counted)
+	})
+
 	t.Run("invalid api key", func(t *testing.T) {
 		_, _, _, _, err := store.ValidateAPIKey("invalid_key")
 		assert.Error(t, err)
@@ -135,7 +299,7 @@ func TestUserStore_ValidateAPIKey(t *testing.T) {
 
 	t.Run("rate limit exceeded", func(t *testing.T) {
 		err := store.db.Model(&models.ApiCredential{}).
-			Where("api_key = ?", cred.ApiKey).
+			Where("key_hash = ?", store.hashAPIKey(cred.ApiKey)).
 			Updates(map[string]interface{}{
 				"daily_request_count": 50,
 				"daily_reset_at":      time.Now().UTC(),
@@ -151,29 +315,29 @@ func TestUserStore_ValidateAPIKey(t *testing.T) {
 	t.Run("daily reset", func(t *testing.T) {
 		yesterday := time.Now().UTC().Add(-24 * time.Hour)
 		err := store.db.Model(&models.ApiCredential{}).
-			Where("api_key = ?", cred.ApiKey).
+			Where("key_hash = ?", store.hashAPIKey(cred.ApiKey)).
 			Updates(map[string]interface{}{
 				"daily_reset_at":      yesterday,
 				"daily_request_count": 50,
 			}).Error
 		require.NoError(t, err)
 
-		validatedUser, limit, used, resetTime, err := store.ValidateAPIKey(cred.ApiKey)
+		validatedCred, limit, used, resetTime, err := store.ValidateAPIKey(cred.ApiKey)
 		require.NoError(t, err)
-		assert.Equal(t, user.GithubID, validatedUser.GithubID)
+		assert.Equal(t, user.GithubID, validatedCred.User.GithubID)
 		assert.Greater(t, limit, 0)
 		assert.Equal(t, 1, used)
 		assert.False(t, resetTime.IsZero())
 
 		var updatedCred models.ApiCredential
-		err = store.db.Where("api_key = ?", cred.ApiKey).First(&updatedCred).Error
+		err = store.db.Where("key_hash = ?", store.hashAPIKey(cred.ApiKey)).First(&updatedCred).Error
 		require.NoError(t, err)
 		assert.Equal(t, 1, updatedCred.DailyRequestCount)
 	})
 
 	t.Run("request counting", func(t *testing.T) {
 		err := store.db.Model(&models.ApiCredential{}).
-			Where("api_key = ?", cred.ApiKey).
+			Where("key_hash = ?", store.hashAPIKey(cred.ApiKey)).
 			Updates(map[string]interface{}{
 				"daily_request_count": 0,
 				"daily_reset_at":      time.Now().UTC(),
@@ -187,7 +351,7 @@ func TestUserStore_ValidateAPIKey(t *testing.T) {
 		}
 
 		var updatedCred models.ApiCredential
-		err = store.db.Where("api_key = ?", cred.ApiKey).First(&updatedCred).Error
+		err = store.db.Where("key_hash = ?", store.hashAPIKey(cred.ApiKey)).First(&updatedCred).Error
 		require.NoError(t, err)
 		assert.Equal(t, numRequests, updatedCred.DailyRequestCount)
 	})
@@ -204,21 +368,356 @@ func TestUserStore_CreateAPICredential(t *testing.T) {
 	require.NoError(t, store.SaveUser(user))
 
 	t.Run("create credential for existing user", func(t *testing.T) {
-		cred, err := store.CreateAPICredential(user.GithubID)
+		cred, err := store.CreateAPICredential(user.GithubID, "laptop", models.AllScopes, nil, models.KeyRestrictions{}, models.PlanFree)
 		require.NoError(t, err)
 		assert.NotEmpty(t, cred.ApiKey)
 		assert.Equal(t, user.GithubID, cred.GithubUserID)
 	})
 
 	t.Run("create credential for non-existent user", func(t *testing.T) {
-		_, err := store.CreateAPICredential(999)
+		_, err := store.CreateAPICredential(999, "laptop", models.AllScopes, nil, models.KeyRestrictions{}, models.PlanFree)
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "user with ID 999 not found")
 	})
 
-	t.Run("prevent duplicate credentials", func(t *testing.T) {
-		_, err := store.CreateAPICredential(user.GithubID)
+	t.Run("prevent duplicate names", func(t *testing.T) {
+		_, err := store.CreateAPICredential(user.GithubID, "laptop", models.AllScopes, nil, models.KeyRestrictions{}, models.PlanFree)
 		require.Error(t, err)
-		assert.Contains(t, err.Error(), "user already has an API key")
+		assert.ErrorIs(t, err, ErrDuplicateKeyName)
+	})
+
+	t.Run("multiple named keys", func(t *testing.T) {
+		dashboard, err := store.CreateAPICredential(user.GithubID, "dashboard", models.AllScopes, nil, models.KeyRestrictions{}, models.PlanFree)
+		require.NoError(t, err)
+
+		credentials, err := store.ListAPICredentials(user.GithubID)
+		require.NoError(t, err)
+		assert.Len(t, credentials, 2)
+
+		_, _, _, _, err = store.ValidateAPIKey(dashboard.ApiKey)
+		require.NoError(t, err)
+
+		credentials, err = store.ListAPICredentials(user.GithubID)
+		require.NoError(t, err)
+		for _, credential := range credentials {
+			if credential.Name == "dashboard" {
+				assert.Equal(t, 1, credential.RequestCount, "usage is tracked per key")
+				assert.NotNil(t, credential.LastUsed)
+			} else {
+				assert.Zero(t, credential.RequestCount)
+			}
+		}
 	})
+
+	t.Run("expiry", func(t *testing.T) {
+		expiresAt := time.Now().Add(time.Hour)
+		temporary, err := store.CreateAPICredential(user.GithubID, "temporary", models.AllScopes, &expiresAt, models.KeyRestrictions{}, models.PlanFree)
+		require.NoError(t, err)
+
+		_, _, _, _, err = store.ValidateAPIKey(temporary.ApiKey)
+		require.NoError(t, err)
+
+		require.NoError(t, store.db.Model(&models.ApiCredential{}).Where("id = ?", temporary.ID).
+			Update("expires_at", time.Now().Add(-time.Minute)).Error)
+
+		_, _, _, _, err = store.ValidateAPIKey(temporary.ApiKey)
+		assert.ErrorIs(t, err, ErrAPIKeyExpired)
+	})
+}
+
+func TestUserStore_QuotaScope(t *testing.T) {
+	store := setupTestDB(t)
+
+	user := &models.User{GithubID: 789, Login: "quotauser", Token: "token789"}
+	require.NoError(t, store.SaveUser(user))
+
+	laptop, err := store.CreateAPICredential(user.GithubID, "laptop", models.AllScopes, nil, models.KeyRestrictions{}, models.PlanFree)
+	require.NoError(t, err)
+	dashboard, err := store.CreateAPICredential(user.GithubID, "dashboard", models.AllScopes, nil, models.KeyRestrictions{}, models.PlanFree)
+	require.NoError(t, err)
+
+	require.NoError(t, store.db.Model(&models.ApiCredential{}).Where("github_user_id = ?", user.GithubID).
+		Updates(map[string]any{"daily_request_count": 30, "daily_reset_at": time.Now().UTC()}).Error)
+
+	t.Run("per key", func(t *testing.T) {
+		store.QuotaScope = QuotaPerKey
+
+		_, limit, used, _, err := store.ValidateAPIKey(laptop.ApiKey)
+		require.NoError(t, err)
+		assert.Equal(t, 50, limit)
+		assert.Equal(t, 31, used)
+	})
+
+	t.Run("per user", func(t *testing.T) {
+		store.QuotaScope = QuotaPerUser
+
+		_, _, _, _, err := store.ValidateAPIKey(dashboard.ApiKey)
+		var rateLimitErr *RateLimitError
+		assert.ErrorAs(t, err, &rateLimitErr, "31 + 30 requests is over the shared limit of 50")
+	})
+}
+
+// legacyCredential is the credential table as it was when keys were stored in
+// plaintext and each had its own daily limit
+type legacyCredential struct {
+	ID                string `gorm:"primaryKey"`
+	GithubUserID      int64  `gorm:"not null;unique;index"`
+	ApiKey            string `gorm:"unique;not null"`
+	LastUsed          *time.Time
+	CreatedAt         time.Time
+	RequestCount      int `gorm:"default:0"`
+	DailyRequestCount int `gorm:"default:0"`
+	RateLimitPerDay   int `gorm:"default:40"`
+	DailyResetAt      time.Time
+}
+
+func TestUserStore_HashPlaintextKeys(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "legacy.db")
+
+	legacy, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, legacy.AutoMigrate(&models.User{}, &models.TriggerRule{}))
+	require.NoError(t, legacy.Table("api_credentials").AutoMigrate(&legacyCredential{}))
+
+	apiKey := "gust_11111111-2222-3333-4444-555555555555"
+	require.NoError(t, legacy.Create(&models.User{GithubID: 123, Login: "legacy", Token: "token"}).Error)
+	require.NoError(t, legacy.Table("api_credentials").Create(&legacyCredential{
+		ID: apiKey, GithubUserID: 123, ApiKey: apiKey, RateLimitPerDay: 50, DailyResetAt: time.Now().UTC(),
+	}).Error)
+	require.NoError(t, legacy.Create(&models.TriggerRule{CredentialID: apiKey, GithubUserID: 123, Expression: "hourly.temp < 0"}).Error)
+	sqlDB, err := legacy.DB()
+	require.NoError(t, err)
+	require.NoError(t, sqlDB.Close())
+
+	store, err := NewUserStore(dbPath, "test-pepper")
+	require.NoError(t, err)
+	defer store.Close()
+
+	assert.False(t, store.db.Migrator().HasColumn(&models.ApiCredential{}, "api_key"))
+	assert.False(t, store.db.Migrator().HasConstraint(&models.ApiCredential{}, "uni_api_credentials_github_user_id"))
+	assert.False(t, store.db.Migrator().HasColumn(&models.ApiCredential{}, "rate_limit_per_day"))
+
+	credential, _, _, _, err := store.ValidateAPIKey(apiKey)
+	require.NoError(t, err, "existing keys keep working")
+	assert.NotEqual(t, apiKey, credential.ID)
+	assert.Equal(t, "gust_11111111", credential.KeyPrefix)
+	assert.Equal(t, DefaultKeyName, credential.Name)
+	assert.Equal(t, models.PlanFree, credential.PlanName)
+
+	_, err = store.CreateAPICredential(123, "dashboard", models.AllScopes, nil, models.KeyRestrictions{}, models.PlanFree)
+	assert.NoError(t, err, "existing users can add more keys")
+
+	rules, err := store.ListEnabledTriggerRules()
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+	assert.Equal(t, credential.ID, rules[0].CredentialID, "rules follow the credential's new ID")
+}
+
+func TestUserStore_MigrateCredentialLimits(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "legacy.db")
+
+	legacy, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, legacy.AutoMigrate(&models.User{}))
+	require.NoError(t, legacy.Table("api_credentials").AutoMigrate(&legacyCredential{}))
+
+	limits := map[string]int{
+		"gust_00000000-0000-0000-0000-000000000040": 40,
+		"gust_00000000-0000-0000-0000-000000000500": 500,
+		"gust_00000000-0000-0000-0000-000000000250": 250,
+		"gust_11111111-0000-0000-0000-000000000250": 250,
+	}
+	githubID := int64(1)
+	for apiKey, limit := range limits {
+		require.NoError(t, legacy.Create(&models.User{GithubID: githubID, Subject: fmt.Sprint(githubID), Login: apiKey, Token: "token"}).Error)
+		require.NoError(t, legacy.Table("api_credentials").Create(&legacyCredential{
+			ID: apiKey, GithubUserID: githubID, ApiKey: apiKey, RateLimitPerDay: limit, DailyResetAt: time.Now().UTC(),
+		}).Error)
+		githubID++
+	}
+	sqlDB, err := legacy.DB()
+	require.NoError(t, err)
+	require.NoError(t, sqlDB.Close())
+
+	store, err := NewUserStore(dbPath, "test-pepper")
+	require.NoError(t, err)
+	defer store.Close()
+
+	assert.False(t, store.db.Migrator().HasColumn(&models.ApiCredential{}, "rate_limit_per_day"))
+
+	plans := map[string]string{}
+	for apiKey, limit := range limits {
+		credential, dailyLimit, _, _, err := store.ValidateAPIKey(apiKey)
+		require.NoError(t, err)
+		plans[apiKey] = credential.PlanName
+
+		if limit == 40 {
+			assert.Equal(t, 50, dailyLimit, "keys on the old default get the free plan")
+		} else {
+			assert.Equal(t, limit, dailyLimit, "custom limits are kept")
+		}
+	}
+
+	assert.Equal(t, models.PlanFree, plans["gust_00000000-0000-0000-0000-000000000040"])
+	assert.Equal(t, models.PlanSupporter, plans["gust_00000000-0000-0000-0000-000000000500"], "a plan with the same limit is reused")
+	assert.Equal(t, "custom-250", plans["gust_00000000-0000-0000-0000-000000000250"])
+	assert.Equal(t, "custom-250", plans["gust_11111111-0000-0000-0000-000000000250"], "keys with the same limit share a plan")
+
+	plan, err := store.GetPlan("custom-250")
+	require.NoError(t, err)
+	assert.Equal(t, 10, plan.BurstLimit, "created plans are otherwise like free")
+}
+
+func TestUserStore_ValidateAPIKey_BurstAndDailyLimits(t *testing.T) {
+	store := setupTestDB(t)
+
+	user := &models.User{GithubID: 4141, Login: "bursty", Token: "token4141"}
+	require.NoError(t, store.SaveUser(user))
+
+	cred, err := store.IssueAPIKey(user.GithubID, DefaultKeyName)
+	require.NoError(t, err)
+
+	// ten seconds before midnight UTC tomorrow
+	tomorrow := time.Now().UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+	now := tomorrow.Add(24*time.Hour - 10*time.Second)
+	store.now = func() time.Time { return now }
+
+	t.Run("burst", func(t *testing.T) {
+		for i := range 10 {
+			_, limit, used, resetTime, err := store.ValidateAPIKey(cred.ApiKey)
+			require.NoError(t, err)
+			assert.Equal(t, 50, limit)
+			assert.Equal(t, i+1, used)
+			assert.Equal(t, tomorrow.Add(24*time.Hour), resetTime, "resets at the next midnight UTC")
+		}
+
+		_, _, used, _, err := store.ValidateAPIKey(cred.ApiKey)
+		var rateLimitErr *RateLimitError
+		require.ErrorAs(t, err, &rateLimitErr)
+		assert.True(t, rateLimitErr.Burst)
+		assert.Equal(t, time.Second, rateLimitErr.RetryAfter)
+		assert.Equal(t, 40, rateLimitErr.Remaining)
+		assert.Equal(t, 10, used, "refused requests aren't counted")
+
+		now = now.Add(time.Second)
+		_, _, used, _, err = store.ValidateAPIKey(cred.ApiKey)
+		require.NoError(t, err)
+		assert.Equal(t, 11, used)
+	})
+
+	t.Run("daily", func(t *testing.T) {
+		require.NoError(t, store.db.Model(&models.ApiCredential{}).Where("id = ?", cred.ID).Update("daily_request_count", 50).Error)
+
+		now = tomorrow.Add(24*time.Hour - 5*time.Second)
+		_, _, _, resetTime, err := store.ValidateAPIKey(cred.ApiKey)
+		var rateLimitErr *RateLimitError
+		require.ErrorAs(t, err, &rateLimitErr)
+		assert.False(t, rateLimitErr.Burst)
+		assert.Equal(t, 5*time.Second, rateLimitErr.RetryAfter)
+		assert.Equal(t, tomorrow.Add(24*time.Hour), resetTime)
+
+		now = tomorrow.Add(24 * time.Hour)
+		_, _, used, resetTime, err := store.ValidateAPIKey(cred.ApiKey)
+		require.NoError(t, err)
+		assert.Equal(t, 1, used, "the count resets at midnight")
+		assert.Equal(t, tomorrow.Add(48*time.Hour), resetTime)
+	})
+}
+
+func TestUserStore_ValidateAPIKey_Concurrent(t *testing.T) {
+	tests := []struct {
+		scope  string
+		cached bool
+	}{
+		{QuotaPerKey, false},
+		{QuotaPerUser, false},
+		{QuotaPerKey, true},
+		{QuotaPerUser, true},
+	}
+
+	for _, tt := range tests {
+		scope := tt.scope
+		t.Run(fmt.Sprintf("%s cached=%t", scope, tt.cached), func(t *testing.T) {
+			store, err := NewUserStore(filepath.Join(t.TempDir(), "test.db"), "test-pepper")
+			require.NoError(t, err)
+			t.Cleanup(func() { store.Close() })
+			store.QuotaScope = scope
+			if tt.cached {
+				store.EnableUsageCache(time.Hour)
+			}
+
+			// only the daily limit is under test
+			free, err := store.GetPlan(models.PlanFree)
+			require.NoError(t, err)
+			free.BurstLimit = 0
+			require.NoError(t, store.UpdatePlan(free))
+
+			require.NoError(t, store.SaveUser(&models.User{GithubID: 4343, Login: "racer", Token: "token"}))
+			laptop, err := store.IssueAPIKey(4343, "laptop")
+			require.NoError(t, err)
+			dashboard, err := store.IssueAPIKey(4343, "dashboard")
+			require.NoError(t, err)
+
+			var (
+				wg          sync.WaitGroup
+				mu          sync.Mutex
+				allowed     = map[string]int{}
+				rateLimited int
+				failures    []error
+			)
+			for i := range 300 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					apiKey := laptop.ApiKey
+					if i%2 == 1 {
+						apiKey = dashboard.ApiKey
+					}
+					credential, _, _, _, err := store.ValidateAPIKey(apiKey)
+
+					mu.Lock()
+					defer mu.Unlock()
+					var rateLimitErr *RateLimitError
+					switch {
+					case err == nil:
+						allowed[credential.Name]++
+					case errors.As(err, &rateLimitErr):
+						rateLimited++
+					default:
+						failures = append(failures, err)
+					}
+				}()
+			}
+			wg.Wait()
+
+			require.Empty(t, failures)
+
+			credentials, err := store.ListAPICredentials(4343)
+			require.NoError(t, err)
+			counted := map[string]int{}
+			for _, credential := range credentials {
+				counted[credential.Name] = credential.DailyRequestCount
+			}
+			assert.Equal(t, allowed, counted, "every allowed request is counted")
+
+			if tt.cached {
+				require.NoError(t, store.flushUsage())
+				var flushed []models.ApiCredential
+				require.NoError(t, store.db.Where("github_user_id = ?", 4343).Find(&flushed).Error)
+				for _, credential := range flushed {
+					assert.Equal(t, allowed[credential.Name], credential.DailyRequestCount)
+					assert.Equal(t, allowed[credential.Name], credential.RequestCount)
+				}
+			}
+
+			if scope == QuotaPerKey {
+				assert.Equal(t, map[string]int{"laptop": 50, "dashboard": 50}, allowed)
+				assert.Equal(t, 200, rateLimited)
+			} else {
+				assert.Equal(t, 50, allowed["laptop"]+allowed["dashboard"])
+				assert.Equal(t, 250, rateLimited)
+			}
+		})
+	}
 }
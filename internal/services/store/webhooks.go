@@ -0,0 +1,96 @@
+package store
+
+import (
+	"github.com/josephburgess/breeze/internal/logging"
+	"github.com/josephburgess/breeze/internal/models"
+)
+
+func (s *UserStore) CreateWebhookSubscription(subscription *models.WebhookSubscription) error {
+	if err := s.db.Create(subscription).Error; err != nil {
+		logging.Error("Failed to create webhook subscription", err)
+		return err
+	}
+
+	logging.Info("Webhook subscription %d created for user: %d", subscription.ID, subscription.GithubUserID)
+	return nil
+}
+
+func (s *UserStore) ListWebhookSubscriptions(githubUserID int64) ([]models.WebhookSubscription, error) {
+	var subscriptions []models.WebhookSubscription
+	if err := s.db.Where("github_user_id = ?", githubUserID).Order("id").Find(&subscriptions).Error; err != nil {
+		logging.Error("Failed to list webhook subscriptions", err)
+		return nil, err
+	}
+	return subscriptions, nil
+}
+
+func (s *UserStore) ListAllWebhookSubscriptions() ([]models.WebhookSubscription, error) {
+	var subscriptions []models.WebhookSubscription
+	if err := s.db.Order("id").Find(&subscriptions).Error; err != nil {
+		logging.Error("Failed to list webhook subscriptions", err)
+		return nil, err
+	}
+	return subscriptions, nil
+}
+
+func (s *UserStore) DeleteWebhookSubscription(githubUserID int64, id uint) error {
+	result := s.db.Where("id = ? AND github_user_id = ?", id, githubUserID).Delete(&models.WebhookSubscription{})
+	if result.Error != nil {
+		logging.Error("Failed to delete webhook subscription", result.Error)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	if err := s.db.Where("subscription_id = ?", id).Delete(&models.WebhookDelivery{}).Error; err != nil {
+		logging.Error("Failed to delete webhook deliveries", err)
+	}
+
+	logging.Info("Webhook subscription %d deleted for user: %d", id, githubUserID)
+	return nil
+}
+
+func (s *UserStore) RecordWebhookDelivery(delivery *models.WebhookDelivery) error {
+	if err := s.db.Create(delivery).Error; err != nil {
+		logging.Error("Failed to record webhook delivery", err)
+		return err
+	}
+	return nil
+}
+
+// HasWebhookDelivery reports whether an alert has already been sent (successfully or
+// not) to a subscription, so each alert is only delivered once.
+func (s *UserStore) HasWebhookDelivery(subscriptionID uint, alertID string) (bool, error) {
+	var count int64
+	if err := s.db.Model(&models.WebhookDelivery{}).
+		Where("subscription_id = ? AND alert_id = ?", subscriptionID, alertID).
+		Count(&count).Error; err != nil {
+		logging.Error("Failed to check webhook deliveries", err)
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (s *UserStore) ListWebhookDeliveries(githubUserID int64, subscriptionID uint, limit int) ([]models.WebhookDelivery, error) {
+	var count int64
+	if err := s.db.Model(&models.WebhookSubscription{}).
+		Where("id = ? AND github_user_id = ?", subscriptionID, githubUserID).
+		Count(&count).Error; err != nil {
+		logging.Error("Failed to check webhook subscription", err)
+		return nil, err
+	}
+	if count == 0 {
+		return nil, ErrNotFound
+	}
+
+	var deliveries []models.WebhookDelivery
+	if err := s.db.Where("subscription_id = ?", subscriptionID).
+		Order("id DESC").
+		Limit(limit).
+		Find(&deliveries).Error; err != nil {
+		logging.Error("Failed to list webhook deliveries", err)
+		return nil, err
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,102 @@
+package timeformat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	// the alpine runtime image ships without zoneinfo, so embed the tz database
+	_ "time/tzdata"
+)
+
+const (
+	Unix    = "unix"
+	ISO8601 = "iso8601"
+	Local   = "local"
+)
+
+// keys holding unix timestamps in weather and astronomy payloads
+var timestampKeys = map[string]bool{
+	"dt":         true,
+	"sunrise":    true,
+	"sunset":     true,
+	"moonrise":   true,
+	"moonset":    true,
+	"start":      true,
+	"end":        true,
+	"solar_noon": true,
+	"dawn":       true,
+	"dusk":       true,
+}
+
+// Parse validates a time_format value, defaulting to unix when empty.
+func Parse(format string) (string, error) {
+	switch format {
+	case "":
+		return Unix, nil
+	case Unix, ISO8601, Local:
+		return format, nil
+	default:
+		return "", fmt.Errorf("invalid time_format %q: expected unix, iso8601 or local", format)
+	}
+}
+
+// LoadLocation resolves an IANA zone name, falling back to a fixed offset when the
+// name is empty or unknown.
+func LoadLocation(name string, fallbackOffset int) *time.Location {
+	if name != "" {
+		if loc, err := time.LoadLocation(name); err == nil {
+			return loc
+		}
+	}
+	return time.FixedZone("", fallbackOffset)
+}
+
+// Rewrite returns a copy of v with every timestamp field converted to an RFC 3339
+// string in loc. Zero timestamps (events that didn't happen) become null.
+func Rewrite(v any, loc *time.Location) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal response: %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var tree any
+	if err := decoder.Decode(&tree); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return rewrite(tree, loc), nil
+}
+
+func rewrite(node any, loc *time.Location) any {
+	switch value := node.(type) {
+	case map[string]any:
+		for key, child := range value {
+			if number, ok := child.(json.Number); ok && timestampKeys[key] {
+				value[key] = format(number, loc)
+				continue
+			}
+			value[key] = rewrite(child, loc)
+		}
+	case []any:
+		for i, child := range value {
+			value[i] = rewrite(child, loc)
+		}
+	}
+	return node
+}
+
+func format(number json.Number, loc *time.Location) any {
+	seconds, err := number.Int64()
+	if err != nil {
+		return number
+	}
+	if seconds == 0 {
+		return nil
+	}
+	return time.Unix(seconds, 0).In(loc).Format(time.RFC3339)
+}
@@ -0,0 +1,75 @@
+package timeformat_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/josephburgess/breeze/internal/models"
+	"github.com/josephburgess/breeze/internal/services/timeformat"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	format, err := timeformat.Parse("")
+	require.NoError(t, err)
+	assert.Equal(t, timeformat.Unix, format)
+
+	for _, valid := range []string{"unix", "iso8601", "local"} {
+		format, err := timeformat.Parse(valid)
+		require.NoError(t, err)
+		assert.Equal(t, valid, format)
+	}
+
+	_, err = timeformat.Parse("rfc822")
+	assert.Error(t, err)
+}
+
+func TestLoadLocation(t *testing.T) {
+	loc := timeformat.LoadLocation("Europe/London", 0)
+	assert.Equal(t, "Europe/London", loc.String())
+
+	loc = timeformat.LoadLocation("Not/AZone", 3600)
+	_, offset := time.Now().In(loc).Zone()
+	assert.Equal(t, 3600, offset)
+}
+
+func TestRewrite_DSTTransition(t *testing.T) {
+	// clocks went forward in London at 01:00 UTC on 2024-03-31
+	before := time.Date(2024, 3, 30, 12, 0, 0, 0, time.UTC).Unix()
+	after := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC).Unix()
+
+	response := models.WeatherResponse{
+		City: &models.City{Name: "London", Lat: 51.5074},
+		Weather: &models.OneCallResponse{
+			Timezone:       "Europe/London",
+			TimezoneOffset: 0,
+			Current:        models.CurrentWeather{Dt: before, Sunrise: before, Temp: 10.5},
+			Hourly:         []models.HourData{{Dt: before}, {Dt: after}},
+			Daily:          []models.DayData{{Dt: after, Moonrise: 0}},
+			Alerts:         []models.Alert{{Start: before, End: after}},
+		},
+	}
+
+	rewritten, err := timeformat.Rewrite(response, timeformat.LoadLocation("Europe/London", 0))
+	require.NoError(t, err)
+
+	root := rewritten.(map[string]any)
+	weather := root["weather"].(map[string]any)
+
+	current := weather["current"].(map[string]any)
+	assert.Equal(t, "2024-03-30T12:00:00Z", current["dt"])
+	assert.Equal(t, "10.5", current["temp"].(interface{ String() string }).String())
+
+	hourly := weather["hourly"].([]any)
+	assert.Equal(t, "2024-03-31T13:00:00+01:00", hourly[1].(map[string]any)["dt"])
+
+	daily := weather["daily"].([]any)
+	assert.Nil(t, daily[0].(map[string]any)["moonrise"])
+
+	alerts := weather["alerts"].([]any)
+	assert.Equal(t, "2024-03-31T13:00:00+01:00", alerts[0].(map[string]any)["end"])
+
+	city := root["city"].(map[string]any)
+	assert.Equal(t, "London", city["name"])
+}
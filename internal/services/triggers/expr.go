@@ -0,0 +1,388 @@
+package triggers
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/josephburgess/breeze/internal/models"
+)
+
+const (
+	ScopeHourly = "hourly"
+	ScopeDaily  = "daily"
+)
+
+// limits on expressions, so parsing one can't exhaust the stack
+const (
+	MaxExpressionLength = 1024
+	maxNesting          = 32
+)
+
+var hourlyFields = map[string]func(models.HourData) float64{
+	"temp":       func(h models.HourData) float64 { return h.Temp },
+	"feels_like": func(h models.HourData) float64 { return h.FeelsLike },
+	"pressure":   func(h models.HourData) float64 { return float64(h.Pressure) },
+	"humidity":   func(h models.HourData) float64 { return float64(h.Humidity) },
+	"dew_point":  func(h models.HourData) float64 { return h.DewPoint },
+	"uvi":        func(h models.HourData) float64 { return h.UVI },
+	"clouds":     func(h models.HourData) float64 { return float64(h.Clouds) },
+	"visibility": func(h models.HourData) float64 { return float64(h.Visibility) },
+	"wind_speed": func(h models.HourData) float64 { return h.WindSpeed },
+	"wind_gust":  func(h models.HourData) float64 { return h.WindGust },
+	"wind_deg":   func(h models.HourData) float64 { return float64(h.WindDeg) },
+	"pop":        func(h models.HourData) float64 { return h.Pop },
+	"rain": func(h models.HourData) float64 {
+		if h.Rain == nil {
+			return 0
+		}
+		return h.Rain.OneHour
+	},
+	"snow": func(h models.HourData) float64 {
+		if h.Snow == nil {
+			return 0
+		}
+		return h.Snow.OneHour
+	},
+}
+
+var dailyFields = map[string]func(models.DayData) float64{
+	"temp.day":         func(d models.DayData) float64 { return d.Temp.Day },
+	"temp.min":         func(d models.DayData) float64 { return d.Temp.Min },
+	"temp.max":         func(d models.DayData) float64 { return d.Temp.Max },
+	"temp.night":       func(d models.DayData) float64 { return d.Temp.Night },
+	"temp.eve":         func(d models.DayData) float64 { return d.Temp.Eve },
+	"temp.morn":        func(d models.DayData) float64 { return d.Temp.Morn },
+	"feels_like.day":   func(d models.DayData) float64 { return d.FeelsLike.Day },
+	"feels_like.night": func(d models.DayData) float64 { return d.FeelsLike.Night },
+	"feels_like.eve":   func(d models.DayData) float64 { return d.FeelsLike.Eve },
+	"feels_like.morn":  func(d models.DayData) float64 { return d.FeelsLike.Morn },
+	"pressure":         func(d models.DayData) float64 { return float64(d.Pressure) },
+	"humidity":         func(d models.DayData) float64 { return float64(d.Humidity) },
+	"dew_point":        func(d models.DayData) float64 { return d.DewPoint },
+	"wind_speed":       func(d models.DayData) float64 { return d.WindSpeed },
+	"wind_gust":        func(d models.DayData) float64 { return d.WindGust },
+	"wind_deg":         func(d models.DayData) float64 { return float64(d.WindDeg) },
+	"clouds":           func(d models.DayData) float64 { return float64(d.Clouds) },
+	"uvi":              func(d models.DayData) float64 { return d.UVI },
+	"pop":              func(d models.DayData) float64 { return d.Pop },
+	"rain":             func(d models.DayData) float64 { return d.Rain },
+	"snow":             func(d models.DayData) float64 { return d.Snow },
+	"moon_phase":       func(d models.DayData) float64 { return d.MoonPhase },
+}
+
+// Expression is a parsed rule condition such as
+//
+//	hourly.pop > 70% and hourly.temp < 2
+//
+// Comparisons can be combined with and/or (and binds tighter) and grouped with
+// parentheses. Every field in an expression must come from the same scope, and the
+// whole expression is checked against one forecast entry at a time.
+type Expression struct {
+	Scope  string
+	Fields []string
+	root   node
+}
+
+type node interface {
+	eval(row any) bool
+}
+
+type comparison struct {
+	field string
+	op    string
+	value float64
+	get   func(row any) float64
+}
+
+type logical struct {
+	op          string
+	left, right node
+}
+
+func (c *comparison) eval(row any) bool {
+	actual := c.get(row)
+	switch c.op {
+	case ">":
+		return actual > c.value
+	case ">=":
+		return actual >= c.value
+	case "<":
+		return actual < c.value
+	case "<=":
+		return actual <= c.value
+	case "==":
+		return actual == c.value
+	default:
+		return actual != c.value
+	}
+}
+
+func (l *logical) eval(row any) bool {
+	if l.op == "and" {
+		return l.left.eval(row) && l.right.eval(row)
+	}
+	return l.left.eval(row) || l.right.eval(row)
+}
+
+// Parse compiles an expression, reporting the position of any syntax error.
+func Parse(input string) (*Expression, error) {
+	if len(input) > MaxExpressionLength {
+		return nil, fmt.Errorf("expression is longer than %d characters", MaxExpressionLength)
+	}
+
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("expression is empty")
+	}
+
+	p := &parser{tokens: tokens, expr: &Expression{}}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.peek().text, p.peek().pos)
+	}
+
+	p.expr.root = root
+	return p.expr, nil
+}
+
+// MatchHourly returns the first hour that satisfies the expression.
+func (e *Expression) MatchHourly(hours []models.HourData) (*models.HourData, bool) {
+	for i := range hours {
+		if e.root.eval(hours[i]) {
+			return &hours[i], true
+		}
+	}
+	return nil, false
+}
+
+// MatchDaily returns the first day that satisfies the expression.
+func (e *Expression) MatchDaily(days []models.DayData) (*models.DayData, bool) {
+	for i := range days {
+		if e.root.eval(days[i]) {
+			return &days[i], true
+		}
+	}
+	return nil, false
+}
+
+// Values returns the value of each field the expression references for row, for
+// use in notification summaries.
+func (e *Expression) Values(row any) map[string]float64 {
+	values := make(map[string]float64, len(e.Fields))
+	for _, field := range e.Fields {
+		values[e.Scope+"."+field] = getter(e.Scope, field)(row)
+	}
+	return values
+}
+
+func getter(scope, field string) func(row any) float64 {
+	if scope == ScopeHourly {
+		get := hourlyFields[field]
+		return func(row any) float64 { return get(row.(models.HourData)) }
+	}
+	get := dailyFields[field]
+	return func(row any) float64 { return get(row.(models.DayData)) }
+}
+
+type token struct {
+	text string
+	pos  int
+}
+
+func tokenize(input string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(input); {
+		c := rune(input[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, token{string(c), i})
+			i++
+		case strings.ContainsRune("<>=!", c):
+			start := i
+			i++
+			if i < len(input) && input[i] == '=' {
+				i++
+			}
+			op := input[start:i]
+			if op == "=" || op == "!" {
+				return nil, fmt.Errorf("invalid operator %q at position %d", op, start)
+			}
+			tokens = append(tokens, token{op, start})
+		case c == '-' || c == '.' || unicode.IsDigit(c):
+			start := i
+			i++
+			for i < len(input) && (input[i] == '.' || unicode.IsDigit(rune(input[i]))) {
+				i++
+			}
+			if i < len(input) && input[i] == '%' {
+				i++
+			}
+			tokens = append(tokens, token{input[start:i], start})
+		case unicode.IsLetter(c) || c == '_':
+			start := i
+			for i < len(input) && (input[i] == '_' || input[i] == '.' || unicode.IsLetter(rune(input[i])) || unicode.IsDigit(rune(input[i]))) {
+				i++
+			}
+			tokens = append(tokens, token{strings.ToLower(input[start:i]), start})
+		default:
+			return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+		}
+	}
+
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	expr   *Expression
+	// parentheses open at pos
+	depth int
+}
+
+func (p *parser) peek() token {
+	if p.pos >= len(p.tokens) {
+		return token{}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() (token, error) {
+	if p.pos >= len(p.tokens) {
+		return token{}, fmt.Errorf("unexpected end of expression")
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	return t, nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().text == "or" {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{op: "or", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().text == "and" {
+		p.pos++
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{op: "and", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseTerm() (node, error) {
+	if open := p.peek(); open.text == "(" {
+		if p.depth == maxNesting {
+			return nil, fmt.Errorf("parentheses nested more than %d deep at position %d", maxNesting, open.pos)
+		}
+		p.pos++
+		p.depth++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		closing, err := p.next()
+		if err != nil || closing.text != ")" {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		p.depth--
+		return inner, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	fieldToken, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+
+	scope, field, found := strings.Cut(fieldToken.text, ".")
+	if !found || (scope != ScopeHourly && scope != ScopeDaily) {
+		return nil, fmt.Errorf("expected a field like hourly.temp or daily.temp.min at position %d, got %q", fieldToken.pos, fieldToken.text)
+	}
+	if scope == ScopeHourly && hourlyFields[field] == nil || scope == ScopeDaily && dailyFields[field] == nil {
+		return nil, fmt.Errorf("unknown field %q at position %d", fieldToken.text, fieldToken.pos)
+	}
+	if p.expr.Scope != "" && p.expr.Scope != scope {
+		return nil, fmt.Errorf("cannot mix hourly and daily fields in one expression (position %d)", fieldToken.pos)
+	}
+	p.expr.Scope = scope
+	if !slices.Contains(p.expr.Fields, field) {
+		p.expr.Fields = append(p.expr.Fields, field)
+	}
+
+	opToken, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	switch opToken.text {
+	case ">", ">=", "<", "<=", "==", "!=":
+	default:
+		return nil, fmt.Errorf("expected a comparison operator at position %d, got %q", opToken.pos, opToken.text)
+	}
+
+	valueToken, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	value, err := parseNumber(valueToken.text, field)
+	if err != nil {
+		return nil, fmt.Errorf("%w at position %d", err, valueToken.pos)
+	}
+
+	return &comparison{field: field, op: opToken.text, value: value, get: getter(scope, field)}, nil
+}
+
+// parseNumber accepts plain numbers and, for fields that are percentages, values
+// like "70%". pop is reported by OpenWeather as 0-1 so it gets scaled to match.
+func parseNumber(text, field string) (float64, error) {
+	percent, isPercent := strings.CutSuffix(text, "%")
+
+	value, err := strconv.ParseFloat(percent, 64)
+	if err != nil {
+		return 0, fmt.Errorf("expected a number, got %q", text)
+	}
+	if !isPercent {
+		return value, nil
+	}
+
+	switch field {
+	case "pop":
+		return value / 100, nil
+	case "humidity", "clouds":
+		return value, nil
+	default:
+		return 0, fmt.Errorf("%s is not a percentage", field)
+	}
+}
@@ -0,0 +1,249 @@
+package triggers
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/josephburgess/breeze/internal/logging"
+	"github.com/josephburgess/breeze/internal/models"
+	"github.com/josephburgess/breeze/internal/services/timeformat"
+	"github.com/josephburgess/breeze/internal/services/webhook"
+)
+
+const FiredEvent = "trigger.fired"
+
+type WeatherFetcher interface {
+	GetWeather(lat, lon float64, units string, customApiKey string) (*models.OneCallResponse, error)
+}
+
+type RuleStore interface {
+	ListEnabledTriggerRules() ([]models.TriggerRule, error)
+	LatestTriggerFiring(ruleID uint) (*models.TriggerFiring, error)
+	RecordTriggerFiring(firing *models.TriggerFiring) (bool, error)
+	UpdateTriggerFiringDelivery(id uint, delivered bool, deliveryError string) error
+}
+
+type FiredPayload struct {
+	Firing     models.TriggerFiring `json:"firing"`
+	RuleID     uint                 `json:"rule_id"`
+	RuleName   string               `json:"rule_name"`
+	Expression string               `json:"expression"`
+	Location   string               `json:"location"`
+	Lat        float64              `json:"lat"`
+	Lon        float64              `json:"lon"`
+	Values     map[string]float64   `json:"values"`
+}
+
+type Scheduler struct {
+	store    RuleStore
+	weather  WeatherFetcher
+	sender   *webhook.Sender
+	interval time.Duration
+	now      func() time.Time
+}
+
+func NewScheduler(store RuleStore, weather WeatherFetcher, sender *webhook.Sender, interval time.Duration) *Scheduler {
+	return &Scheduler{
+		store:    store,
+		weather:  weather,
+		sender:   sender,
+		interval: interval,
+		now:      time.Now,
+	}
+}
+
+// Run evaluates all rules on every interval until ctx is cancelled.
+func (s *Scheduler) Run(ctx context.Context) {
+	logging.Info("Starting trigger scheduler (interval: %s)", s.interval)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.Evaluate(ctx)
+
+		select {
+		case <-ctx.Done():
+			logging.Info("Stopping trigger scheduler")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Evaluate checks every enabled rule once against the (cached) forecast for its
+// location.
+func (s *Scheduler) Evaluate(ctx context.Context) {
+	rules, err := s.store.ListEnabledTriggerRules()
+	if err != nil {
+		logging.Error("Failed to load trigger rules", err)
+		return
+	}
+
+	for _, rule := range rules {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := s.evaluateRule(ctx, rule); err != nil {
+			logging.Error(fmt.Sprintf("Failed to evaluate trigger rule %d", rule.ID), err)
+		}
+	}
+}
+
+func (s *Scheduler) evaluateRule(ctx context.Context, rule models.TriggerRule) error {
+	expr, err := Parse(rule.Expression)
+	if err != nil {
+		return fmt.Errorf("invalid expression: %w", err)
+	}
+
+	forecast, err := s.weather.GetWeather(rule.Lat, rule.Lon, rule.Units, "")
+	if err != nil {
+		return fmt.Errorf("failed to fetch forecast: %w", err)
+	}
+
+	now := s.now()
+	local := now.In(timeformat.LoadLocation(forecast.Timezone, forecast.TimezoneOffset))
+	if InQuietHours(local, rule.QuietStart, rule.QuietEnd) {
+		return nil
+	}
+
+	matchedAt, values, ok := match(expr, forecast, now, rule.WindowHours)
+	if !ok {
+		return nil
+	}
+
+	// a rule fires at most once per window, so an ongoing condition isn't repeated
+	// every time the scheduler runs
+	latest, err := s.store.LatestTriggerFiring(rule.ID)
+	if err != nil {
+		return err
+	}
+	if latest != nil && now.Sub(latest.FiredAt) < window(rule.WindowHours) {
+		return nil
+	}
+
+	firing := &models.TriggerFiring{
+		RuleID:    rule.ID,
+		DedupKey:  fmt.Sprintf("%s:%d", expr.Scope, matchedAt),
+		MatchedAt: matchedAt,
+		Summary:   summarize(values),
+		FiredAt:   now,
+	}
+
+	created, err := s.store.RecordTriggerFiring(firing)
+	if err != nil || !created {
+		return err
+	}
+
+	logging.Info("Trigger rule %d fired: %s", rule.ID, firing.Summary)
+
+	if rule.WebhookURL == "" {
+		return nil
+	}
+
+	payload := FiredPayload{
+		Firing:     *firing,
+		RuleID:     rule.ID,
+		RuleName:   rule.Name,
+		Expression: rule.Expression,
+		Location:   rule.LocationName,
+		Lat:        rule.Lat,
+		Lon:        rule.Lon,
+		Values:     values,
+	}
+
+	attempts, err := s.sender.Send(ctx, rule.WebhookURL, rule.Secret, FiredEvent, fmt.Sprint(firing.ID), payload)
+	if err != nil {
+		logging.Error("Failed to deliver trigger webhook", err)
+	}
+
+	delivered := len(attempts) > 0 && attempts[len(attempts)-1].Success()
+	deliveryError := ""
+	if !delivered && len(attempts) > 0 && attempts[len(attempts)-1].Err != nil {
+		deliveryError = attempts[len(attempts)-1].Err.Error()
+	}
+	return s.store.UpdateTriggerFiringDelivery(firing.ID, delivered, deliveryError)
+}
+
+// match finds the first forecast entry within the rule's window that satisfies
+// the expression.
+func match(expr *Expression, forecast *models.OneCallResponse, now time.Time, windowHours int) (int64, map[string]float64, bool) {
+	from := now.Add(-time.Hour).Unix()
+	until := now.Add(window(windowHours)).Unix()
+
+	if expr.Scope == ScopeHourly {
+		var hours []models.HourData
+		for _, hour := range forecast.Hourly {
+			if hour.Dt >= from && hour.Dt <= until {
+				hours = append(hours, hour)
+			}
+		}
+		if hour, ok := expr.MatchHourly(hours); ok {
+			return hour.Dt, expr.Values(*hour), true
+		}
+		return 0, nil, false
+	}
+
+	// daily entries are stamped around midday, so today's still counts this evening
+	var days []models.DayData
+	for _, day := range forecast.Daily {
+		if day.Dt >= now.Add(-12*time.Hour).Unix() && day.Dt <= until {
+			days = append(days, day)
+		}
+	}
+	if day, ok := expr.MatchDaily(days); ok {
+		return day.Dt, expr.Values(*day), true
+	}
+	return 0, nil, false
+}
+
+// InQuietHours reports whether local falls within the HH:MM range [start, end),
+// which may wrap past midnight. Empty bounds mean no quiet hours.
+func InQuietHours(local time.Time, start, end string) bool {
+	if start == "" || end == "" {
+		return false
+	}
+
+	startMinutes, err := ParseClock(start)
+	if err != nil {
+		return false
+	}
+	endMinutes, err := ParseClock(end)
+	if err != nil {
+		return false
+	}
+
+	minutes := local.Hour()*60 + local.Minute()
+	if startMinutes <= endMinutes {
+		return minutes >= startMinutes && minutes < endMinutes
+	}
+	return minutes >= startMinutes || minutes < endMinutes
+}
+
+// ParseClock converts an HH:MM string into minutes after midnight.
+func ParseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func window(hours int) time.Duration {
+	if hours <= 0 {
+		hours = 24
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+func summarize(values map[string]float64) string {
+	parts := make([]string, 0, len(values))
+	for field, value := range values {
+		parts = append(parts, fmt.Sprintf("%s=%g", field, value))
+	}
+	slices.Sort(parts)
+	return strings.Join(parts, ", ")
+}
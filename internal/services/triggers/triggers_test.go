@@ -0,0 +1,222 @@
+package triggers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/josephburgess/breeze/internal/models"
+	"github.com/josephburgess/breeze/internal/services/store"
+	"github.com/josephburgess/breeze/internal/services/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	valid := []string{
+		"hourly.pop > 70%",
+		"hourly.temp < 0",
+		"hourly.pop >= 0.5 and hourly.wind_gust > 15",
+		"(hourly.temp <= -2 or hourly.snow > 0) and hourly.humidity > 80%",
+		"daily.temp.min < 0",
+		"DAILY.UVI >= 8",
+		strings.Repeat("(", 32) + "hourly.temp > 1" + strings.Repeat(")", 32),
+	}
+	for _, input := range valid {
+		_, err := Parse(input)
+		assert.NoError(t, err, input)
+	}
+
+	invalid := map[string]string{
+		"":                                  "empty",
+		"hourly.pop >":                      "end of expression",
+		"hourly.nope > 1":                   "unknown field",
+		"temp > 1":                          "expected a field",
+		"hourly.temp = 1":                   "invalid operator",
+		"hourly.temp > warm":                "expected a number",
+		"hourly.temp > 5%":                  "not a percentage",
+		"hourly.temp > 1 and daily.uvi > 5": "cannot mix",
+		"(hourly.temp > 1":                  "closing parenthesis",
+		"hourly.temp > 1 hourly.pop > 0.5":  "unexpected",
+		"hourly.temp > 1 # comment":         "unexpected character",
+		strings.Repeat("(", 33) + "hourly.temp > 1" + strings.Repeat(")", 33): "nested more than 32",
+		strings.Repeat("(", 1000):  "nested more than 32",
+		strings.Repeat("(", 1<<20): "longer than 1024",
+	}
+	for input, message := range invalid {
+		_, err := Parse(input)
+		if assert.Error(t, err, input) {
+			assert.Contains(t, err.Error(), message, input)
+		}
+	}
+}
+
+func TestExpression_Match(t *testing.T) {
+	expr, err := Parse("hourly.pop > 70% and hourly.temp < 2 or hourly.snow > 0")
+	require.NoError(t, err)
+	assert.Equal(t, ScopeHourly, expr.Scope)
+	assert.ElementsMatch(t, []string{"pop", "temp", "snow"}, expr.Fields)
+
+	hours := []models.HourData{
+		{Dt: 1, Pop: 0.9, Temp: 5},
+		{Dt: 2, Pop: 0.8, Temp: 1},
+		{Dt: 3, Snow: &models.SnowData{OneHour: 0.4}, Temp: 10},
+	}
+
+	hour, ok := expr.MatchHourly(hours)
+	require.True(t, ok)
+	assert.Equal(t, int64(2), hour.Dt)
+
+	hour, ok = expr.MatchHourly(hours[2:])
+	require.True(t, ok, "and binds tighter than or")
+	assert.Equal(t, int64(3), hour.Dt)
+
+	_, ok = expr.MatchHourly(hours[:1])
+	assert.False(t, ok)
+
+	daily, err := Parse("daily.temp.min < 0")
+	require.NoError(t, err)
+	day, ok := daily.MatchDaily([]models.DayData{{Dt: 1, Temp: models.TempData{Min: 3}}, {Dt: 2, Temp: models.TempData{Min: -1}}})
+	require.True(t, ok)
+	assert.Equal(t, int64(2), day.Dt)
+	assert.Equal(t, map[string]float64{"daily.temp.min": -1}, daily.Values(*day))
+}
+
+func TestInQuietHours(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 1, 1, hour, minute, 0, 0, time.UTC)
+	}
+
+	assert.False(t, InQuietHours(at(23, 0), "", ""))
+
+	assert.True(t, InQuietHours(at(23, 0), "22:00", "07:00"))
+	assert.True(t, InQuietHours(at(3, 30), "22:00", "07:00"))
+	assert.False(t, InQuietHours(at(7, 0), "22:00", "07:00"))
+	assert.False(t, InQuietHours(at(12, 0), "22:00", "07:00"))
+
+	assert.True(t, InQuietHours(at(13, 0), "12:30", "14:00"))
+	assert.False(t, InQuietHours(at(14, 0), "12:30", "14:00"))
+}
+
+type stubWeather struct {
+	forecast *models.OneCallResponse
+}
+
+func (s *stubWeather) GetWeather(lat, lon float64, units string, customApiKey string) (*models.OneCallResponse, error) {
+	return s.forecast, nil
+}
+
+// setupScheduler returns a scheduler and its store, with a user 123 whose key's ID
+// is returned for their rules.
+func setupScheduler(t *testing.T, forecast *models.OneCallResponse, now time.Time) (*Scheduler, *store.UserStore, string) {
+	userStore, err := store.NewUserStore(filepath.Join(t.TempDir(), "test.db"), "test-pepper")
+	require.NoError(t, err)
+	t.Cleanup(func() { userStore.Close() })
+
+	require.NoError(t, userStore.SaveUser(&models.User{GithubID: 123, Login: "testuser", Token: "token"}))
+	credential, err := userStore.IssueAPIKey(123, store.DefaultKeyName)
+	require.NoError(t, err)
+
+	sender := webhook.NewSender()
+	sender.RetryDelay = time.Millisecond
+	// test receivers listen on loopback, which the guarded client refuses
+	sender.Client = &http.Client{Timeout: time.Second}
+
+	scheduler := NewScheduler(userStore, &stubWeather{forecast: forecast}, sender, time.Minute)
+	scheduler.now = func() time.Time { return now }
+	return scheduler, userStore, credential.ID
+}
+
+func TestScheduler_Evaluate(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	forecast := &models.OneCallResponse{
+		Timezone: "Europe/London",
+		Hourly: []models.HourData{
+			{Dt: now.Unix(), Pop: 0.2},
+			{Dt: now.Add(time.Hour).Unix(), Pop: 0.5},
+			{Dt: now.Add(2 * time.Hour).Unix(), Pop: 0.85},
+			{Dt: now.Add(5 * time.Hour).Unix(), Pop: 0.95},
+		},
+	}
+
+	var received []FiredPayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, "sha256="+webhook.Sign("secret", r.Header.Get(webhook.TimestampHeader), body), r.Header.Get(webhook.SignatureHeader))
+
+		var payload FiredPayload
+		require.NoError(t, json.Unmarshal(body, &payload))
+		received = append(received, payload)
+	}))
+	defer server.Close()
+
+	scheduler, userStore, credentialID := setupScheduler(t, forecast, now)
+
+	rule := &models.TriggerRule{
+		CredentialID: credentialID,
+		GithubUserID: 123,
+		Name:         "rain soon",
+		LocationName: "Home",
+		Expression:   "hourly.pop > 70%",
+		WindowHours:  3,
+		WebhookURL:   server.URL,
+		Secret:       "secret",
+		Enabled:      true,
+	}
+	require.NoError(t, userStore.CreateTriggerRule(rule))
+
+	scheduler.Evaluate(context.Background())
+	scheduler.Evaluate(context.Background())
+
+	firings, err := userStore.ListTriggerFirings(123, now.Add(-time.Hour))
+	require.NoError(t, err)
+	require.Len(t, firings, 1, "repeat evaluations are deduplicated")
+	assert.Equal(t, now.Add(2*time.Hour).Unix(), firings[0].MatchedAt)
+	assert.Equal(t, "hourly.pop=0.85", firings[0].Summary)
+	assert.True(t, firings[0].Delivered)
+
+	require.Len(t, received, 1)
+	assert.Equal(t, "rain soon", received[0].RuleName)
+	assert.Equal(t, 0.85, received[0].Values["hourly.pop"])
+
+	// after the window has passed the rule can fire again for a new hour
+	scheduler.now = func() time.Time { return now.Add(4 * time.Hour) }
+	scheduler.Evaluate(context.Background())
+
+	firings, err = userStore.ListTriggerFirings(123, now.Add(-time.Hour))
+	require.NoError(t, err)
+	require.Len(t, firings, 2)
+	assert.Equal(t, now.Add(5*time.Hour).Unix(), firings[1].MatchedAt)
+}
+
+func TestScheduler_QuietHours(t *testing.T) {
+	now := time.Date(2024, 1, 1, 23, 0, 0, 0, time.UTC)
+	forecast := &models.OneCallResponse{
+		Timezone: "Europe/London",
+		Hourly:   []models.HourData{{Dt: now.Unix(), Temp: -3}},
+	}
+
+	scheduler, userStore, credentialID := setupScheduler(t, forecast, now)
+
+	rule := &models.TriggerRule{
+		CredentialID: credentialID,
+		GithubUserID: 123,
+		Expression:   "hourly.temp < 0",
+		QuietStart:   "22:00",
+		QuietEnd:     "07:00",
+		Enabled:      true,
+	}
+	require.NoError(t, userStore.CreateTriggerRule(rule))
+
+	scheduler.Evaluate(context.Background())
+
+	firings, err := userStore.ListTriggerFirings(123, time.Time{})
+	require.NoError(t, err)
+	assert.Empty(t, firings)
+}
@@ -0,0 +1,74 @@
+package weather
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/josephburgess/breeze/internal/logging"
+	"github.com/josephburgess/breeze/internal/models"
+)
+
+type cacheEntry struct {
+	weather   *models.OneCallResponse
+	fetchedAt time.Time
+}
+
+// Cache keeps recent forecasts in memory so background jobs checking the same
+// locations don't spend upstream quota on every run.
+type Cache struct {
+	client  *Client
+	ttl     time.Duration
+	now     func() time.Time
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+func NewCache(client *Client, ttl time.Duration) *Cache {
+	return &Cache{
+		client:  client,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]cacheEntry),
+	}
+}
+
+// GetWeather returns a cached forecast for the location if one is fresh enough.
+// Requests made with a custom API key always go upstream. Each caller gets its own
+// copy, which it is free to change.
+func (c *Cache) GetWeather(lat, lon float64, units string, customApiKey string) (*models.OneCallResponse, error) {
+	if customApiKey != "" {
+		return c.client.GetWeather(lat, lon, units, customApiKey)
+	}
+
+	key := fmt.Sprintf("%.2f,%.2f,%s", lat, lon, units)
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+
+	if ok && c.now().Sub(entry.fetchedAt) < c.ttl {
+		logging.Info("Using cached weather data for lat: %f, lon: %f", lat, lon)
+		return entry.weather.Clone(), nil
+	}
+
+	weather, err := c.client.GetWeather(lat, lon, units, "")
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = cacheEntry{weather: weather, fetchedAt: c.now()}
+	c.evictExpired()
+
+	return weather.Clone(), nil
+}
+
+func (c *Cache) evictExpired() {
+	for key, entry := range c.entries {
+		if c.now().Sub(entry.fetchedAt) >= c.ttl {
+			delete(c.entries, key)
+		}
+	}
+}
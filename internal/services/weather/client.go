@@ -68,6 +68,12 @@ func (c *Client) GetCoordinates(city string, customApiKey string) (*models.City,
 }
 
 func (c *Client) GetWeather(lat, lon float64, units string, customApiKey string) (*models.OneCallResponse, error) {
+	return c.GetLocalizedWeather(lat, lon, units, "", customApiKey)
+}
+
+// GetLocalizedWeather is GetWeather with condition descriptions (and alerts, where the
+// issuing agency supports it) translated into lang.
+func (c *Client) GetLocalizedWeather(lat, lon float64, units, lang string, customApiKey string) (*models.OneCallResponse, error) {
 	var url string
 	apiKey := c.ApiKey
 	if customApiKey != "" {
@@ -79,9 +85,13 @@ func (c *Client) GetWeather(lat, lon float64, units string, customApiKey string)
 		logging.Info("Fetching weather data with units=%s", units)
 	} else {
 		url = fmt.Sprintf("%sdata/3.0/onecall?lat=%f&lon=%f&appid=%s",
-			c.BaseURL, lat, lon, c.ApiKey)
+			c.BaseURL, lat, lon, apiKey)
 		logging.Info("Fetching weather data with default units (Kelvin)")
 	}
+	if lang != "" {
+		url += "&lang=" + lang
+		logging.Info("Fetching weather data with lang=%s", lang)
+	}
 
 	logging.Info("Fetching weather data for lat: %f, lon: %f", lat, lon)
 
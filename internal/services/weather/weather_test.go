@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/josephburgess/breeze/internal/models"
 	"github.com/josephburgess/breeze/internal/services/weather"
@@ -149,6 +150,23 @@ func TestClient_GetWeather_DefaultUnits(t *testing.T) {
 	assert.Equal(t, 288.15, weather.Current.Temp)
 }
 
+func TestClient_GetLocalizedWeather(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "metric", r.URL.Query().Get("units"))
+		assert.Equal(t, "de", r.URL.Query().Get("lang"))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(models.OneCallResponse{Lat: 51.5074, Lon: -0.1278})
+	}))
+	defer server.Close()
+
+	client := weather.NewClient("test-api-key")
+	client.BaseURL = server.URL + "/"
+
+	_, err := client.GetLocalizedWeather(51.5074, -0.1278, "metric", "de", apiKey)
+	require.NoError(t, err)
+}
+
 func TestClient_SearchCities(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		assert.Equal(t, "/geo/1.0/direct", r.URL.Path)
@@ -237,3 +255,65 @@ func TestClient_SearchCities_ErrorResponse(t *testing.T) {
 	assert.Nil(t, cities)
 	assert.Contains(t, err.Error(), "API returned status 401")
 }
+
+func TestCache_GetWeather(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(models.OneCallResponse{Lat: 51.5074, Lon: -0.1278})
+	}))
+	defer server.Close()
+
+	client := weather.NewClient("test-api-key")
+	client.BaseURL = server.URL + "/"
+	cache := weather.NewCache(client, time.Minute)
+
+	for range 3 {
+		result, err := cache.GetWeather(51.5074, -0.1278, "metric", "")
+		require.NoError(t, err)
+		assert.Equal(t, 51.5074, result.Lat)
+	}
+	assert.Equal(t, 1, calls)
+
+	_, err := cache.GetWeather(51.5074, -0.1278, "imperial", "")
+	require.NoError(t, err)
+	assert.Equal(t, 2, calls, "different units are cached separately")
+
+	_, err = cache.GetWeather(51.5074, -0.1278, "metric", "custom-key")
+	require.NoError(t, err)
+	assert.Equal(t, 3, calls, "custom keys bypass the cache")
+}
+
+func TestCache_GetWeather_ReturnsCopies(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(models.OneCallResponse{
+			Timezone: "Europe/London",
+			Current:  models.CurrentWeather{Temp: 12, Weather: []models.WeatherCondition{{Main: "Rain"}}},
+			Hourly:   []models.HourData{{Dt: 1700000000, Rain: &models.RainData{OneHour: 1.5}}},
+			Alerts:   []models.Alert{{Event: "Wind", Tags: []string{"wind"}}},
+		})
+	}))
+	defer server.Close()
+
+	client := weather.NewClient("test-api-key")
+	client.BaseURL = server.URL + "/"
+	cache := weather.NewCache(client, time.Minute)
+
+	first, err := cache.GetWeather(51.5074, -0.1278, "metric", "")
+	require.NoError(t, err)
+	first.Timezone = "UTC"
+	first.Current.Weather[0].Main = "Clear"
+	first.Hourly[0].Rain.OneHour = 0
+	first.Hourly = first.Hourly[:0]
+	first.Alerts[0].Tags[0] = "changed"
+
+	second, err := cache.GetWeather(51.5074, -0.1278, "metric", "")
+	require.NoError(t, err)
+	assert.Equal(t, "Europe/London", second.Timezone)
+	assert.Equal(t, "Rain", second.Current.Weather[0].Main)
+	require.Len(t, second.Hourly, 1)
+	assert.Equal(t, 1.5, second.Hourly[0].Rain.OneHour)
+	assert.Equal(t, "wind", second.Alerts[0].Tags[0])
+}
@@ -0,0 +1,101 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenAddress is returned for webhook URLs, or connections, to addresses
+// that aren't on the public internet, so webhooks can't be used to reach breeze's
+// own network.
+var ErrForbiddenAddress = errors.New("webhook address is not public")
+
+// reserved ranges the netip checks don't cover
+var forbiddenPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// publicAddr reports whether addr is on the public internet, rejecting loopback,
+// private, link-local (including cloud metadata services), multicast and
+// unspecified addresses.
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return false
+	}
+	for _, prefix := range forbiddenPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateURL checks rawURL is an http(s) URL whose host only resolves to public
+// addresses, returning it parsed. Senders check again when they connect, as DNS
+// can change in between.
+func ValidateURL(ctx context.Context, rawURL string) (*url.URL, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil || (target.Scheme != "https" && target.Scheme != "http") || target.Hostname() == "" {
+		return nil, errors.New("a valid http(s) url is required")
+	}
+
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", target.Hostname())
+	if err != nil || len(addrs) == 0 {
+		return nil, fmt.Errorf("couldn't resolve %s", target.Hostname())
+	}
+	for _, addr := range addrs {
+		if !publicAddr(addr) {
+			return nil, fmt.Errorf("%w: %s resolves to %s", ErrForbiddenAddress, target.Hostname(), addr)
+		}
+	}
+
+	return target, nil
+}
+
+// NewClient returns an HTTP client for webhook deliveries that only connects to
+// public addresses, checked for each connection after DNS resolution so a host
+// can't be pointed at an internal address after it was validated, and doesn't
+// follow redirects.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !publicAddr(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s", ErrForbiddenAddress, addrPort.Addr())
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		// no proxy, which would make the connection the check sees the proxy's
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
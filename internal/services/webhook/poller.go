@@ -0,0 +1,149 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/josephburgess/breeze/internal/logging"
+	"github.com/josephburgess/breeze/internal/models"
+	"github.com/josephburgess/breeze/internal/services/alerts"
+	"github.com/josephburgess/breeze/internal/services/timeformat"
+)
+
+const AlertEvent = "weather.alert"
+
+type WeatherFetcher interface {
+	GetWeather(lat, lon float64, units string, customApiKey string) (*models.OneCallResponse, error)
+}
+
+type SubscriptionStore interface {
+	ListAllWebhookSubscriptions() ([]models.WebhookSubscription, error)
+	HasWebhookDelivery(subscriptionID uint, alertID string) (bool, error)
+	RecordWebhookDelivery(delivery *models.WebhookDelivery) error
+}
+
+type AlertPayload struct {
+	SubscriptionID uint                `json:"subscription_id"`
+	Location       AlertLocation       `json:"location"`
+	Alert          models.WeatherAlert `json:"alert"`
+}
+
+type AlertLocation struct {
+	Name     string  `json:"name"`
+	Lat      float64 `json:"lat"`
+	Lon      float64 `json:"lon"`
+	Timezone string  `json:"timezone"`
+}
+
+type Poller struct {
+	store    SubscriptionStore
+	weather  WeatherFetcher
+	sender   *Sender
+	interval time.Duration
+	now      func() time.Time
+}
+
+func NewPoller(store SubscriptionStore, weather WeatherFetcher, sender *Sender, interval time.Duration) *Poller {
+	return &Poller{
+		store:    store,
+		weather:  weather,
+		sender:   sender,
+		interval: interval,
+		now:      time.Now,
+	}
+}
+
+// Run polls on every interval until ctx is cancelled.
+func (p *Poller) Run(ctx context.Context) {
+	logging.Info("Starting alert webhook poller (interval: %s)", p.interval)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		p.Poll(ctx)
+
+		select {
+		case <-ctx.Done():
+			logging.Info("Stopping alert webhook poller")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll checks alerts for every subscribed location once and delivers any that
+// haven't been sent to their subscription yet.
+func (p *Poller) Poll(ctx context.Context) {
+	subscriptions, err := p.store.ListAllWebhookSubscriptions()
+	if err != nil {
+		logging.Error("Failed to load webhook subscriptions", err)
+		return
+	}
+
+	// subscriptions at (almost) the same spot share a single upstream call
+	forecasts := make(map[string]*models.OneCallResponse)
+
+	for _, subscription := range subscriptions {
+		if ctx.Err() != nil {
+			return
+		}
+
+		key := fmt.Sprintf("%.2f,%.2f", subscription.Lat, subscription.Lon)
+		weather, ok := forecasts[key]
+		if !ok {
+			weather, err = p.weather.GetWeather(subscription.Lat, subscription.Lon, "", "")
+			if err != nil {
+				logging.Error("Failed to fetch alerts for webhook subscription", err)
+				continue
+			}
+			forecasts[key] = weather
+		}
+
+		loc := timeformat.LoadLocation(weather.Timezone, weather.TimezoneOffset)
+		for _, alert := range alerts.Normalize(weather.Alerts, loc, p.now()) {
+			p.deliver(ctx, subscription, weather.Timezone, alert)
+		}
+	}
+}
+
+func (p *Poller) deliver(ctx context.Context, subscription models.WebhookSubscription, timezone string, alert models.WeatherAlert) {
+	delivered, err := p.store.HasWebhookDelivery(subscription.ID, alert.ID)
+	if err != nil || delivered {
+		return
+	}
+
+	payload := AlertPayload{
+		SubscriptionID: subscription.ID,
+		Location: AlertLocation{
+			Name:     subscription.Name,
+			Lat:      subscription.Lat,
+			Lon:      subscription.Lon,
+			Timezone: timezone,
+		},
+		Alert: alert,
+	}
+
+	logging.Info("Delivering alert %s to webhook subscription %d", alert.ID, subscription.ID)
+
+	attempts, err := p.sender.Send(ctx, subscription.URL, subscription.Secret, AlertEvent, alert.ID, payload)
+	if err != nil {
+		logging.Error("Failed to deliver webhook", err)
+	}
+
+	for _, attempt := range attempts {
+		delivery := &models.WebhookDelivery{
+			SubscriptionID: subscription.ID,
+			AlertID:        alert.ID,
+			Event:          AlertEvent,
+			Attempt:        attempt.Number,
+			StatusCode:     attempt.StatusCode,
+			Success:        attempt.Success(),
+		}
+		if attempt.Err != nil {
+			delivery.Error = attempt.Err.Error()
+		}
+		p.store.RecordWebhookDelivery(delivery)
+	}
+}
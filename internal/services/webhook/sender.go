@@ -0,0 +1,122 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/josephburgess/breeze/internal/logging"
+)
+
+const (
+	SignatureHeader = "X-Breeze-Signature"
+	TimestampHeader = "X-Breeze-Timestamp"
+	EventHeader     = "X-Breeze-Event"
+	DeliveryHeader  = "X-Breeze-Delivery"
+)
+
+type Sender struct {
+	Client      *http.Client
+	MaxAttempts int
+	RetryDelay  time.Duration
+}
+
+type Attempt struct {
+	Number     int
+	StatusCode int
+	Err        error
+}
+
+func (a Attempt) Success() bool {
+	return a.Err == nil && a.StatusCode >= 200 && a.StatusCode < 300
+}
+
+// NewSender returns a Sender whose client only delivers to public addresses, see
+// NewClient.
+func NewSender() *Sender {
+	return &Sender{
+		Client:      NewClient(10 * time.Second),
+		MaxAttempts: 3,
+		RetryDelay:  2 * time.Second,
+	}
+}
+
+// Sign returns the hex HMAC-SHA256 of "<timestamp>.<body>" using secret. Receivers
+// should recompute it and reject stale timestamps to guard against replays.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Send POSTs payload as signed JSON, retrying with a linear backoff on network
+// errors, 429s and 5xx responses. Every attempt is returned so callers can log them.
+func (s *Sender) Send(ctx context.Context, url, secret, event, deliveryID string, payload any) ([]Attempt, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal webhook payload: %w", err)
+	}
+
+	var attempts []Attempt
+	for n := 1; n <= s.MaxAttempts; n++ {
+		attempt := s.post(ctx, url, secret, event, deliveryID, body)
+		attempt.Number = n
+		attempts = append(attempts, attempt)
+
+		if attempt.Success() || !retryable(attempt) || n == s.MaxAttempts {
+			break
+		}
+
+		logging.Warn("Webhook delivery %s to %s failed (attempt %d), retrying", deliveryID, url, n)
+		select {
+		case <-ctx.Done():
+			return attempts, ctx.Err()
+		case <-time.After(s.RetryDelay * time.Duration(n)):
+		}
+	}
+
+	return attempts, nil
+}
+
+func (s *Sender) post(ctx context.Context, url, secret, event, deliveryID string, body []byte) Attempt {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return Attempt{Err: fmt.Errorf("failed to create request: %w", err)}
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "breeze-webhooks")
+	req.Header.Set(EventHeader, event)
+	req.Header.Set(DeliveryHeader, deliveryID)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, "sha256="+Sign(secret, timestamp, body))
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return Attempt{Err: fmt.Errorf("webhook request failed: %w", err)}
+	}
+	defer resp.Body.Close()
+
+	attempt := Attempt{StatusCode: resp.StatusCode}
+	if !attempt.Success() {
+		attempt.Err = fmt.Errorf("webhook endpoint returned status %d", resp.StatusCode)
+	}
+	return attempt
+}
+
+func retryable(attempt Attempt) bool {
+	if attempt.StatusCode == 0 {
+		return true
+	}
+	return attempt.StatusCode == http.StatusTooManyRequests || attempt.StatusCode >= 500
+}
@@ -0,0 +1,256 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/josephburgess/breeze/internal/models"
+	"github.com/josephburgess/breeze/internal/services/store"
+	"github.com/josephburgess/breeze/internal/services/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type stubWeather struct {
+	mu       sync.Mutex
+	calls    int
+	response *models.OneCallResponse
+}
+
+func (s *stubWeather) GetWeather(lat, lon float64, units string, customApiKey string) (*models.OneCallResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	return s.response, nil
+}
+
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	bodies   [][]byte
+	headers  []http.Header
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	rc.bodies = append(rc.bodies, body)
+	rc.headers = append(rc.headers, r.Header.Clone())
+
+	status := http.StatusOK
+	if len(rc.statuses) > 0 {
+		status = rc.statuses[0]
+		rc.statuses = rc.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func setupStore(t *testing.T) *store.UserStore {
+	userStore, err := store.NewUserStore(filepath.Join(t.TempDir(), "test.db"), "test-pepper")
+	require.NoError(t, err)
+	t.Cleanup(func() { userStore.Close() })
+
+	require.NoError(t, userStore.SaveUser(&models.User{GithubID: 123, Login: "testuser", Token: "token"}))
+	return userStore
+}
+
+func newSender() *webhook.Sender {
+	sender := webhook.NewSender()
+	sender.RetryDelay = time.Millisecond
+	// test receivers listen on loopback, which the guarded client refuses
+	sender.Client = &http.Client{Timeout: time.Second}
+	return sender
+}
+
+func TestSign(t *testing.T) {
+	signature := webhook.Sign("secret", "1700000000", []byte(`{"a":1}`))
+	assert.Len(t, signature, 64)
+	assert.Equal(t, signature, webhook.Sign("secret", "1700000000", []byte(`{"a":1}`)))
+	assert.NotEqual(t, signature, webhook.Sign("other", "1700000000", []byte(`{"a":1}`)))
+	assert.NotEqual(t, signature, webhook.Sign("secret", "1700000001", []byte(`{"a":1}`)))
+}
+
+func TestPoller_DeliversNewAlertsOnce(t *testing.T) {
+	userStore := setupStore(t)
+
+	rc := &receiver{}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	subscription := &models.WebhookSubscription{
+		GithubUserID: 123,
+		Name:         "Home",
+		Lat:          51.5,
+		Lon:          -0.12,
+		URL:          server.URL,
+		Secret:       "whsec_test",
+	}
+	require.NoError(t, userStore.CreateWebhookSubscription(subscription))
+
+	weather := &stubWeather{response: &models.OneCallResponse{
+		Lat:      51.5,
+		Lon:      -0.12,
+		Timezone: "Europe/London",
+		Alerts: []models.Alert{{
+			SenderName: "Met Office",
+			Event:      "Amber wind warning",
+			Start:      time.Now().Add(-time.Hour).Unix(),
+			End:        time.Now().Add(time.Hour).Unix(),
+		}},
+	}}
+
+	poller := webhook.NewPoller(userStore, weather, newSender(), time.Minute)
+	poller.Poll(context.Background())
+	poller.Poll(context.Background())
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	require.Len(t, rc.bodies, 1, "alert should only be delivered once")
+
+	headers := rc.headers[0]
+	assert.Equal(t, webhook.AlertEvent, headers.Get(webhook.EventHeader))
+	expected := "sha256=" + webhook.Sign("whsec_test", headers.Get(webhook.TimestampHeader), rc.bodies[0])
+	assert.Equal(t, expected, headers.Get(webhook.SignatureHeader))
+
+	var payload webhook.AlertPayload
+	require.NoError(t, json.Unmarshal(rc.bodies[0], &payload))
+	assert.Equal(t, subscription.ID, payload.SubscriptionID)
+	assert.Equal(t, "Home", payload.Location.Name)
+	assert.Equal(t, "severe", payload.Alert.Severity)
+	assert.Equal(t, headers.Get(webhook.DeliveryHeader), payload.Alert.ID)
+
+	deliveries, err := userStore.ListWebhookDeliveries(123, subscription.ID, 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.True(t, deliveries[0].Success)
+	assert.Equal(t, http.StatusOK, deliveries[0].StatusCode)
+}
+
+func TestPoller_RetriesFailedDeliveries(t *testing.T) {
+	userStore := setupStore(t)
+
+	rc := &receiver{statuses: []int{http.StatusInternalServerError, http.StatusBadGateway}}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	subscription := &models.WebhookSubscription{GithubUserID: 123, Lat: 1, Lon: 2, URL: server.URL, Secret: "s"}
+	require.NoError(t, userStore.CreateWebhookSubscription(subscription))
+
+	weather := &stubWeather{response: &models.OneCallResponse{
+		Alerts: []models.Alert{{Event: "Flood Warning", Start: time.Now().Unix()}},
+	}}
+
+	webhook.NewPoller(userStore, weather, newSender(), time.Minute).Poll(context.Background())
+
+	deliveries, err := userStore.ListWebhookDeliveries(123, subscription.ID, 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 3)
+
+	// newest first
+	assert.True(t, deliveries[0].Success)
+	assert.Equal(t, 3, deliveries[0].Attempt)
+	assert.False(t, deliveries[1].Success)
+	assert.Equal(t, http.StatusBadGateway, deliveries[1].StatusCode)
+	assert.Contains(t, deliveries[2].Error, "500")
+}
+
+func TestPoller_DoesNotRetryClientErrors(t *testing.T) {
+	userStore := setupStore(t)
+
+	rc := &receiver{statuses: []int{http.StatusGone}}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	subscription := &models.WebhookSubscription{GithubUserID: 123, Lat: 1, Lon: 2, URL: server.URL, Secret: "s"}
+	require.NoError(t, userStore.CreateWebhookSubscription(subscription))
+
+	weather := &stubWeather{response: &models.OneCallResponse{
+		Alerts: []models.Alert{{Event: "Flood Warning", Start: time.Now().Unix()}},
+	}}
+
+	webhook.NewPoller(userStore, weather, newSender(), time.Minute).Poll(context.Background())
+
+	deliveries, err := userStore.ListWebhookDeliveries(123, subscription.ID, 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.False(t, deliveries[0].Success)
+}
+
+func TestPoller_SharesUpstreamCallsPerLocation(t *testing.T) {
+	userStore := setupStore(t)
+
+	rc := &receiver{}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	for range 3 {
+		require.NoError(t, userStore.CreateWebhookSubscription(&models.WebhookSubscription{
+			GithubUserID: 123, Lat: 51.501, Lon: -0.121, URL: server.URL, Secret: "s",
+		}))
+	}
+
+	weather := &stubWeather{response: &models.OneCallResponse{}}
+	webhook.NewPoller(userStore, weather, newSender(), time.Minute).Poll(context.Background())
+
+	assert.Equal(t, 1, weather.calls)
+}
+
+func TestValidateURL(t *testing.T) {
+	for _, rawURL := range []string{
+		"https://8.8.8.8/hook",
+		"http://[2001:4860:4860::8888]:8080/hook",
+	} {
+		target, err := webhook.ValidateURL(context.Background(), rawURL)
+		require.NoError(t, err, rawURL)
+		assert.Equal(t, rawURL, target.String())
+	}
+
+	for _, rawURL := range []string{
+		"http://127.0.0.1/hook",
+		"http://localhost:8080/hook",
+		"http://10.0.0.5/hook",
+		"http://192.168.1.1/hook",
+		"http://172.16.0.1/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://0.0.0.0/hook",
+		"http://100.64.0.1/hook",
+		"http://[::1]/hook",
+		"http://[fd00::1]/hook",
+		"http://[fe80::1]/hook",
+		"http://[::ffff:127.0.0.1]/hook",
+	} {
+		_, err := webhook.ValidateURL(context.Background(), rawURL)
+		assert.ErrorIs(t, err, webhook.ErrForbiddenAddress, rawURL)
+	}
+
+	for _, rawURL := range []string{"", "ftp://8.8.8.8/hook", "https:///hook", "not a url"} {
+		_, err := webhook.ValidateURL(context.Background(), rawURL)
+		assert.Error(t, err, rawURL)
+	}
+}
+
+func TestNewClient_RefusesInternalAddresses(t *testing.T) {
+	var called bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer server.Close()
+
+	// as if the host had passed validation, then been pointed at loopback
+	client := webhook.NewClient(time.Second)
+	_, err := client.Post(server.URL, "application/json", nil)
+	assert.ErrorIs(t, err, webhook.ErrForbiddenAddress)
+	assert.False(t, called)
+
+	req := httptest.NewRequest(http.MethodPost, "https://8.8.8.8/hook", nil)
+	assert.ErrorIs(t, client.CheckRedirect(req, []*http.Request{req}), http.ErrUseLastResponse, "redirects aren't followed")
+}
@@ -64,6 +64,7 @@ const authSuccessTemplateContent = `<!DOCTYPE html>
     <p class="info">Your Gust API key has been generated. Use this API key in your CLI application:</p>
     <div class="api-key">{{.ApiKey}}</div>
     <p>This key will allow you to access weather data through the Gust API.</p>
+    <p>This is the only time the key will be shown, so copy it somewhere safe. Signing in again issues a new key.</p>
 
     <div class="next-steps">
         <p>You can now return to your terminal. The CLI application should automatically continue.</p>
@@ -72,10 +73,117 @@ const authSuccessTemplateContent = `<!DOCTYPE html>
 </body>
 </html>`
 
+// styles shared by the device sign-in pages
+const deviceStyleTemplateContent = `{{define "device_style"}}
+    <style>
+        body {
+            font-family: system-ui, sans-serif;
+            max-width: 600px;
+            margin: 0 auto;
+            padding: 2rem;
+            text-align: center;
+            line-height: 1.6;
+        }
+        h1 {
+            color: #333;
+            margin-bottom: 1.5rem;
+        }
+        .success {
+            color: #4CAF50;
+            font-weight: bold;
+            font-size: 1.2rem;
+        }
+        .error {
+            color: #c62828;
+        }
+        input {
+            font-family: monospace;
+            font-size: 1.5rem;
+            text-align: center;
+            text-transform: uppercase;
+            letter-spacing: 0.2rem;
+            padding: 0.5rem;
+            width: 12rem;
+        }
+        .code {
+            font-family: monospace;
+            font-size: 1.5rem;
+            letter-spacing: 0.2rem;
+        }
+        button {
+            font-size: 1rem;
+            padding: 0.6rem 1.2rem;
+            margin-top: 1rem;
+            border: none;
+            border-radius: 4px;
+            background: #2d2d2d;
+            color: #fff;
+            cursor: pointer;
+        }
+    </style>
+{{end}}`
+
+const deviceVerifyTemplateContent = `<!DOCTYPE html>
+<html>
+<head>
+    <title>Gust Device Sign In</title>
+    {{template "device_style"}}
+</head>
+<body>
+    <h1>Sign in on another device</h1>
+    <p>Enter the code shown in your terminal. Only continue if you started signing in to gust yourself.</p>
+    {{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+    <form method="post">
+        <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+        <input name="user_code" value="{{.UserCode}}" placeholder="XXXX-XXXX" autocomplete="off" autofocus required>
+        <br>
+        {{range .Providers}}<button type="submit" name="provider" value="{{.}}">Continue with {{if eq . "github"}}GitHub{{else}}{{.}}{{end}}</button>
+        {{end}}
+    </form>
+</body>
+</html>`
+
+const deviceConfirmTemplateContent = `<!DOCTYPE html>
+<html>
+<head>
+    <title>Gust Approve Device</title>
+    {{template "device_style"}}
+</head>
+<body>
+    <h1>Approve this device?</h1>
+    <p>Signed in as <strong>{{.Login}}</strong>.</p>
+    <p>A device is asking for an API key called <strong>{{.KeyName}}</strong>. Check the code below matches the one shown in your terminal.</p>
+    <p class="code">{{.UserCode}}</p>
+    <p>Only approve it if you started signing in to gust yourself. Otherwise, close this window.</p>
+    <form method="post" action="{{.ApproveURL}}">
+        <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+        <input type="hidden" name="user_code" value="{{.UserCode}}">
+        <button type="submit">Approve device</button>
+    </form>
+</body>
+</html>`
+
+const deviceApprovedTemplateContent = `<!DOCTYPE html>
+<html>
+<head>
+    <title>Gust Device Approved</title>
+    {{template "device_style"}}
+</head>
+<body>
+    <h1>Device approved</h1>
+    <p class="success">Welcome, {{.Login}}!</p>
+    <p>Your device will receive its API key in a few seconds. You can close this window and return to your terminal.</p>
+</body>
+</html>`
+
 var templates *template.Template
 
 func init() {
 	templates = template.Must(template.New("auth_success").Parse(authSuccessTemplateContent))
+	template.Must(templates.New("device_style").Parse(deviceStyleTemplateContent))
+	template.Must(templates.New("device_verify").Parse(deviceVerifyTemplateContent))
+	template.Must(templates.New("device_confirm").Parse(deviceConfirmTemplateContent))
+	template.Must(templates.New("device_approved").Parse(deviceApprovedTemplateContent))
 }
 
 func RenderSuccessTemplate(w io.Writer, login, apiKey string) error {
@@ -89,3 +197,53 @@ func RenderSuccessTemplate(w io.Writer, login, apiKey string) error {
 
 	return templates.ExecuteTemplate(w, "auth_success", data)
 }
+
+// RenderDeviceVerifyTemplate renders the page where users enter a device's user
+// code and pick one of providers to sign in with, with errorMessage shown if it
+// isn't empty. csrfToken is submitted with the form.
+func RenderDeviceVerifyTemplate(w io.Writer, userCode, errorMessage string, providers []string, csrfToken string) error {
+	data := struct {
+		UserCode  string
+		Error     string
+		Providers []string
+		CSRFToken string
+	}{
+		UserCode:  userCode,
+		Error:     errorMessage,
+		Providers: providers,
+		CSRFToken: csrfToken,
+	}
+
+	return templates.ExecuteTemplate(w, "device_verify", data)
+}
+
+// RenderDeviceConfirmTemplate renders the page where a user who has signed in
+// confirms they want to approve the device with userCode, which posts to approveURL
+// with csrfToken.
+func RenderDeviceConfirmTemplate(w io.Writer, login, userCode, keyName, approveURL, csrfToken string) error {
+	data := struct {
+		Login      string
+		UserCode   string
+		KeyName    string
+		ApproveURL string
+		CSRFToken  string
+	}{
+		Login:      login,
+		UserCode:   userCode,
+		KeyName:    keyName,
+		ApproveURL: approveURL,
+		CSRFToken:  csrfToken,
+	}
+
+	return templates.ExecuteTemplate(w, "device_confirm", data)
+}
+
+func RenderDeviceApprovedTemplate(w io.Writer, login string) error {
+	data := struct {
+		Login string
+	}{
+		Login: login,
+	}
+
+	return templates.ExecuteTemplate(w, "device_approved", data)
+}